@@ -4,12 +4,15 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gongfarmer/ntap/encoding/ade"
@@ -19,11 +22,33 @@ var (
 	FlagFilename    = flag.String("o", "", "write output to file")
 	FlagOutputXML   = flag.Bool("x", false, "print atom as xml")
 	FlagOutputHex   = flag.Bool("X", false, "print atom as hex string")
+	FlagOutputJSON  = flag.Bool("j", false, "print atom as JSON objects keyed by atom name, for use with jq")
+	FlagOutputJSONL = flag.Bool("jsonl", false, "print each leaf atom as a JSON object on its own line, with its path, type, value and offset")
+	FlagOutputCSV   = flag.Bool("csv", false, "print a table of atom values as CSV, with a row for each atom matching --row")
+	FlagOutputTSV   = flag.Bool("tsv", false, "print a table of atom values as tab separated values, like --csv")
+	FlagRow         = flag.String("row", "", "find row atoms matching PATH for --csv and --tsv")
+	FlagColumns     stringList
 	FlagOutputDebug = flag.Bool("d", false, "print atoms in verbose debug format")
-	FlagPath        = flag.String("p", "", "find atoms matching PATH")
+	FlagPath        = flag.String("p", "", "find atoms matching PATH, or print the values of a PATH expression such as count(//NODE)")
 	FlagVerbose     = flag.Bool("v", false, "enable verbose logging")
+	FlagSalvage     = flag.Bool("salvage", false, "recover atoms from damaged binary input, and report the damaged regions")
+	FlagWorkers     = flag.Int("workers", 0, "number of files to read concurrently, default is one per CPU")
 )
 
+func init() {
+	flag.Var(&FlagColumns, "col", "add a table column for atoms at PATH relative to each row atom, may be repeated")
+}
+
+// stringList is a flag value holding each value given for a repeated flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func usage() {
 	fmt.Fprintln(os.Stderr, "Usage: ccat [options] [<file> ...]")
 	fmt.Fprintln(os.Stderr, "       cat <file> | ccat [options]")
@@ -39,6 +64,29 @@ func usage() {
 	fmt.Fprintln(os.Stderr, ``)
 	fmt.Fprintln(os.Stderr, `       # print all atoms with data values > 0x2D000000`)
 	fmt.Fprintln(os.Stderr, `       ccat -p="//*[data() > 0x2D000000]" test.FC32.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # print the number of nodes, and their total capacity`)
+	fmt.Fprintln(os.Stderr, `       ccat -p="count(//NODE)" nodes.bin`)
+	fmt.Fprintln(os.Stderr, `       ccat -p="sum(//NODE/CAPA/data())" nodes.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # print the undamaged atoms of a truncated file, with comments describing the damage`)
+	fmt.Fprintln(os.Stderr, `       ccat --salvage truncated.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # print grid ID from GINF bundle using jq`)
+	fmt.Fprintln(os.Stderr, `       ccat -j GINF.bin | jq '.GINF.GIDV.AVAL["0x00000001"]'`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # find leaf atoms by value with grep, then print one with its path`)
+	fmt.Fprintln(os.Stderr, `       ccat --jsonl GINF.bin | grep 10.4.0`)
+	fmt.Fprintln(os.Stderr, `       ccat -p="/GINF/GSIV[1]/AVAL[1]/0x00000001[1]" GINF.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # print the name and capacity of every node as CSV, with a header row`)
+	fmt.Fprintln(os.Stderr, `       ccat --csv --row=//NODE --col=NAME --col=CAPA nodes.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # convert to containerxml and back to binary`)
+	fmt.Fprintln(os.Stderr, `       ccat -x GINF.bin | ctac GINF.copy.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # print many files, reading 8 at a time`)
+	fmt.Fprintln(os.Stderr, `       ccat -workers=8 *.bin`)
 
 	os.Exit(2)
 }
@@ -62,18 +110,41 @@ func main() {
 	}
 
 	// Read atom data
-	var files = filter(os.Args[1:], func(s string) bool { return !strings.HasPrefix(s, "-") && s != *FlagFilename })
-	atoms, err := ReadAtomsFromInput(files)
+	var files = flag.Args()
+	var atoms []*ade.Atom
+	var damaged []DamageNote
+	var err error
+	var searched bool
+	var pathValues bool // the path evaluates to values, such as count(//NODE), rather than atoms
+	if "" != *FlagPath {
+		atomPath, err := ade.NewAtomPath(*FlagPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pathValues = !atomPath.SelectsAtoms()
+	}
+	if *FlagSalvage {
+		atoms, damaged, err = SalvageAtomsFromInput(files)
+	} else if "" != *FlagPath && !pathValues && len(files) > 0 {
+		// Search files directly, so only atoms on the path are decoded
+		atoms, err = FilePathSearch(files, *FlagPath)
+		searched = true
+	} else {
+		atoms, err = ReadAtomsFromInput(files)
+	}
 	if err != nil {
-		log.Fatalf(err.Error())
+		log.Fatal(err)
 	}
 
 	// Apply path to root atom
-	if "" != *FlagPath {
+	var values []string
+	if pathValues {
+		values, err = PathValues(atoms, *FlagPath)
+	} else if "" != *FlagPath && !searched {
 		atoms, err = PathSearch(atoms, *FlagPath)
-		if err != nil {
-			log.Fatalf(err.Error())
-		}
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Make Writer for output stream
@@ -83,7 +154,7 @@ func main() {
 	} else {
 		output, err = os.OpenFile(*FlagFilename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 		if err != nil {
-			log.Fatalf(err.Error())
+			log.Fatal(err)
 		}
 	}
 
@@ -94,11 +165,48 @@ func main() {
 	} else if true == *FlagOutputHex {
 		atomPrinterFunc = formatWriter(printAtomHex).formatter(output)
 	} else if true == *FlagOutputXML {
-		panic("XML output not implemented yet")
+		atomPrinterFunc = formatWriter(printAtomXML).formatter(output)
+	} else if true == *FlagOutputJSON {
+		atomPrinterFunc = formatWriter(printAtomJSONView).formatter(output)
+	} else if true == *FlagOutputJSONL {
+		atomPrinterFunc = formatWriter(printAtomJSONLines).formatter(output)
 	} else {
 		atomPrinterFunc = formatWriter(printAtomText).formatter(output)
 	}
 
+	// Describe damage found in salvage mode. Text output gets comments where
+	// the damage was found, which ctac ignores. Other formats can't hold
+	// comments, and a path separates atoms from the damage, so use STDERR.
+	var damageComments = len(damaged) > 0 && "" == *FlagPath &&
+		!(*FlagOutputDebug || *FlagOutputHex || *FlagOutputXML || *FlagOutputJSON || *FlagOutputJSONL ||
+			*FlagOutputCSV || *FlagOutputTSV)
+	if !damageComments {
+		for _, d := range damaged {
+			log.Print(d)
+		}
+	}
+
+	// Values of a path expression are printed instead of atoms
+	if pathValues {
+		for _, v := range values {
+			fmt.Fprintln(output, v)
+		}
+		os.Exit(0)
+	}
+
+	// Tables are written for all atoms at once, rather than atom by atom
+	if *FlagOutputCSV || *FlagOutputTSV {
+		if err = writeTable(output, atoms, *FlagRow, FlagColumns, *FlagOutputTSV); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if damageComments {
+		printSalvagedText(output, atoms, nil, damaged, 0)
+		os.Exit(0)
+	}
+
 	WriteAtoms(atoms, atomPrinterFunc)
 	os.Exit(0)
 }
@@ -152,6 +260,101 @@ func printAtomText(w io.Writer, a *ade.Atom) {
 	fmt.Fprint(w, string(buf))
 }
 
+// Print atoms held by the given container as ADE Container Text, with a
+// comment describing each damaged region at the point where it was found.
+// Top-level atoms have a nil container.
+func printSalvagedText(w io.Writer, atoms []*ade.Atom, container *ade.Atom, damaged []DamageNote, depth int) {
+	var indent = strings.Repeat("\t", depth)
+	var printComments = func(index int) {
+		for _, d := range damaged {
+			if d.Container == container && d.Index == index {
+				fmt.Fprintf(w, "%s# %s\n", indent, d)
+			}
+		}
+	}
+	for i, a := range atoms {
+		printComments(i)
+		if string(a.Type()) != "CONT" {
+			fmt.Fprint(w, indent)
+			printAtomText(w, a)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s:%s:\n", indent, a.Name(), a.Type())
+		printSalvagedText(w, a.Children(), a, damaged, depth+1)
+		fmt.Fprintf(w, "%sEND\n", indent)
+	}
+	printComments(len(atoms))
+}
+
+// Print atom as a containerxml document
+func printAtomXML(w io.Writer, a *ade.Atom) {
+	buf, err := ade.AtomToXMLDocumentText(a)
+	if err != nil {
+		log.Printf("failed to print AtomContainer: %s\n", err)
+		return
+	}
+	w.Write(buf)
+}
+
+// Print atom as a JSON object keyed by atom name
+func printAtomJSONView(w io.Writer, a *ade.Atom) {
+	buf, err := ade.MarshalJSONView(a, ade.JSONViewOptions{Indent: "  "})
+	if err != nil {
+		log.Printf("failed to print AtomContainer: %s\n", err)
+		return
+	}
+	fmt.Fprintln(w, string(buf))
+}
+
+// Print each leaf atom as a line of JSON giving its path, type, value and offset
+func printAtomJSONLines(w io.Writer, a *ade.Atom) {
+	if err := ade.WriteJSONLines(w, a); err != nil {
+		log.Printf("failed to print AtomContainer: %s\n", err)
+	}
+}
+
+// writeTable writes a header row of column paths, followed by a row of values
+// for each atom matching the row path within each of the atoms. Values are
+// separated by commas, or by tabs if tsv is true, and quoted as needed.
+func writeTable(w io.Writer, atoms []*ade.Atom, rowPath string, columns []string, tsv bool) error {
+	if rowPath == "" || len(columns) == 0 {
+		return fmt.Errorf("--row and --col are required for table output")
+	}
+	var cw = csv.NewWriter(w)
+	if tsv {
+		cw.Comma = '\t'
+	}
+	cw.Write(columns)
+
+	var record = make([]string, len(columns))
+	for _, a := range atoms {
+		rows, err := ade.Table(a, rowPath, columns...)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			for i, v := range row {
+				record[i] = tableCell(v)
+			}
+			cw.Write(record)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tableCell returns a table value as text. Missing values are empty.
+func tableCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // Print atom as hex representation of binary-form bytes
 func printAtomHex(w io.Writer, a *ade.Atom) {
 	buf, err := a.MarshalBinary()
@@ -169,7 +372,8 @@ func stdinIsEmpty() bool {
 
 // ReadAtomsFromInput takes in a possibly empty list of files.
 // If files are provided, read each file as an ADE binary atom, returning the
-// results as a slice of atomPtrs.
+// results as a slice of atomPtrs. Files are read concurrently, but results
+// are in the same order as the files.
 // If no files are provided, then attempt to read a single binary atom from STDIN.
 // An empty array and nil error are returned if no input is found.
 // A non-nil error is returned if invalid input is encountered.
@@ -202,38 +406,82 @@ func ReadAtomsFromInput(files []string) (atoms []*ade.Atom, err error) {
 		atoms = append(atoms, someAtoms...)
 	}
 
-	// Read each file, expecting ADE binary data
-	for _, path := range files {
-		buffer, err = ioutil.ReadFile(path)
-		if err != nil && err != io.EOF {
-			return atoms, err // no need to add filepath, it's in the error
+	// Read each file, expecting ADE binary data, or hex
+	for _, result := range ade.LoadFiles(files, *FlagWorkers) {
+		if result.Err != nil {
+			return atoms, fmt.Errorf("unable to parse file '%s' as a binary atom container: %w", result.Path, result.Err)
 		}
+		atoms = append(atoms, result.Atoms...)
+	}
+	return
+}
 
-		// convert to atoms.
-		if uint32(len(buffer)) == binary.BigEndian.Uint32(buffer[0:4]) {
-			someAtoms, err = ade.ReadAtomsFromBinary(bytes.NewReader(buffer))
-		} else if string(buffer[0:1]) == "0x" {
-			someAtoms, err = ade.ReadAtomsFromHex(bytes.NewReader(buffer))
+// A DamageNote describes a damaged region of an input file.
+type DamageNote struct {
+	ade.DamagedRegion
+	File string
+}
+
+// String returns a one-line description of the damage.
+func (d DamageNote) String() string {
+	return fmt.Sprintf("%s: %s", d.File, d.DamagedRegion)
+}
+
+// SalvageAtomsFromInput reads binary atoms from a possibly empty list of files,
+// or from STDIN if no files are provided, in the same way as
+// ReadAtomsFromInput.
+// Unlike ReadAtomsFromInput, input may be damaged. Every atom that can be
+// decoded is returned, along with a description of each damaged region that
+// was skipped. The index of top-level damage counts the atoms of all files.
+func SalvageAtomsFromInput(files []string) (atoms []*ade.Atom, damaged []DamageNote, err error) {
+	var inputs = files
+	if len(files) == 0 {
+		if stdinIsEmpty() {
+			return
+		}
+		inputs = []string{"STDIN"}
+	}
+
+	for _, path := range inputs {
+		var buffer []byte
+		if len(files) == 0 {
+			buffer, err = ioutil.ReadAll(os.Stdin)
 		} else {
-			log.Fatalf("file size (%d) does not match encoded size(%d), this is not a binary atom container: %s", len(buffer), binary.BigEndian.Uint32(buffer[0:4]), path)
+			buffer, err = ioutil.ReadFile(path)
 		}
 		if err != nil {
-			log.Fatalf("unable to parse file '%s' as a binary atom container: %s", path, err.Error())
+			return atoms, damaged, err
 		}
 
+		// hex input is converted to binary first
+		if bytes.HasPrefix(buffer, []byte("0x")) {
+			if buffer, err = decodeHex(buffer); err != nil {
+				return atoms, damaged, fmt.Errorf("unable to read hex input from %s: %s", path, err)
+			}
+		}
+
+		someAtoms, regions := ade.SalvageAtomsFromBinary(buffer)
+		for _, r := range regions {
+			if r.Container == nil {
+				r.Index += len(atoms)
+			}
+			damaged = append(damaged, DamageNote{r, path})
+		}
 		atoms = append(atoms, someAtoms...)
 	}
 	return
 }
 
-// Filter array items based on test function
-func filter(ss []string, testFunc func(string) bool) (out []string) {
-	for _, s := range ss {
-		if testFunc(s) {
-			out = append(out, s)
+// decodeHex converts a hex string with leading 0x to bytes, ignoring
+// whitespace.
+func decodeHex(buffer []byte) ([]byte, error) {
+	var clean = make([]byte, 0, len(buffer))
+	for _, b := range bytes.TrimPrefix(buffer, []byte("0x")) {
+		if b != '\n' && b != '\r' && b != ' ' && b != '\t' {
+			clean = append(clean, b)
 		}
 	}
-	return out
+	return hex.DecodeString(string(clean))
 }
 
 // WriteAtoms writes each atom using the given print function, which includes
@@ -247,6 +495,47 @@ func WriteAtoms(atoms []*ade.Atom, printFunc func(*ade.Atom)) {
 	}
 }
 
+// FilePathSearch returns the atoms matching the path in each of the given
+// files. Binary files are searched without being read in full: atoms which the
+// path cannot reach are skipped. Hex files are read in full.
+func FilePathSearch(files []string, path string) (results []*ade.Atom, err error) {
+	atomPath, err := ade.NewAtomPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		moreResults, err := filePathSearch(name, atomPath)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, moreResults...)
+	}
+	return
+}
+
+func filePathSearch(name string, atomPath *ade.AtomPath) (results []*ade.Atom, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic = make([]byte, 2)
+	if _, err = f.ReadAt(magic, 0); err == nil && string(magic) == "0x" {
+		atoms, err := ReadAtomsFromInput([]string{name})
+		if err != nil {
+			return nil, err
+		}
+		return PathSearch(atoms, atomPath.Path)
+	}
+
+	results, err = atomPath.GetAtomsFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse file '%s' as a binary atom container: %s", name, err)
+	}
+	return results, nil
+}
+
 func PathSearch(atoms []*ade.Atom, path string) (results []*ade.Atom, err error) {
 	for _, a := range atoms {
 		if moreResults, e := a.AtomsAtPath(path); e != nil {
@@ -257,3 +546,25 @@ func PathSearch(atoms []*ade.Atom, path string) (results []*ade.Atom, err error)
 	}
 	return
 }
+
+// PathValues returns the values of a path expression that evaluates to values
+// rather than atoms, such as count(//NODE), as text.  The expression is
+// evaluated with each of the atoms as the root.
+func PathValues(atoms []*ade.Atom, path string) (values []string, err error) {
+	atomPath, err := ade.NewAtomPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range atoms {
+		result, err := atomPath.Evaluate(a)
+		if err != nil {
+			return nil, err
+		}
+		strs, err := result.SliceOfString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, strs...)
+	}
+	return values, nil
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/gongfarmer/ntap/encoding/ade"
@@ -18,15 +21,15 @@ func init() {
 	for _, f := range findTestFiles() {
 		buf, err := ioutil.ReadFile(f)
 		if err != nil {
-			log.Fatalf(err.Error())
+			log.Fatal(err)
 		}
 		TestBytes = append(TestBytes, buf)
 	}
 }
 
 func findTestFiles() []string {
-	_, dir, _, _ := runtime.Caller(1)
-	testdir := filepath.Join(dir, "../../encoding/ade/testdata/from_grid/")
+	_, dir, _, _ := runtime.Caller(0)
+	testdir := filepath.Join(dir, "../../../encoding/ade/testdata/from_grid/")
 	files, _ := filepath.Glob(filepath.Join(testdir, "*.bin"))
 	return files
 }
@@ -51,3 +54,215 @@ func BenchmarkMarshalText(b *testing.B) {
 		}
 	}
 }
+
+func TestFilePathSearch(t *testing.T) {
+	files := findTestFiles()
+	atoms, err := ReadAtomsFromInput(files)
+	if err != nil {
+		t.Fatalf("TestFilePathSearch: unable to read test files: %s", err)
+	}
+	for _, path := range []string{"/*/*", "//*[@type = CONT]"} {
+		want, err := PathSearch(atoms, path)
+		if err != nil {
+			t.Fatalf("FilePathSearch(%s): unable to search test atoms: %s", path, err)
+		}
+		got, err := FilePathSearch(files, path)
+		if err != nil {
+			t.Errorf("FilePathSearch(%s): expect no error, got %s", path, err)
+			continue
+		}
+		if len(got) != len(want) {
+			t.Errorf("FilePathSearch(%s): got %d atoms, want %d", path, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].String() != want[i].String() {
+				t.Errorf("FilePathSearch(%s): result %d is %s, want %s", path, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestPrintAtomXML(t *testing.T) {
+	for _, binFile := range findTestFiles() {
+		atoms, err := ReadAtomsFromInput([]string{binFile})
+		if err != nil {
+			t.Fatalf("printAtomXML(%s): unable to read test file: %s", binFile, err)
+		}
+		want, err := ioutil.ReadFile(strings.TrimSuffix(binFile, ".bin") + ".xml")
+		if err != nil {
+			t.Fatalf("printAtomXML(%s): unable to read test file: %s", binFile, err)
+		}
+		var got bytes.Buffer
+		WriteAtoms(atoms, formatWriter(printAtomXML).formatter(&got))
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("printAtomXML(%s): output differs from xml test data", filepath.Base(binFile))
+		}
+	}
+}
+
+func TestPrintAtomJSONView(t *testing.T) {
+	atoms, err := ReadAtomsFromInput(findTestFiles())
+	if err != nil {
+		t.Fatalf("printAtomJSONView: unable to read test files: %s", err)
+	}
+	var out bytes.Buffer
+	WriteAtoms(atoms, formatWriter(printAtomJSONView).formatter(&out))
+
+	// output is a stream of JSON objects, one per atom, keyed by atom name
+	var d = json.NewDecoder(&out)
+	for _, a := range atoms {
+		var got map[string]interface{}
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("printAtomJSONView(%s): output is not valid JSON: %s", a.Name(), err)
+		}
+		if _, ok := got[a.Name()]; !ok || len(got) != 1 {
+			t.Errorf("printAtomJSONView(%s): got keys %v, want only %s", a.Name(), got, a.Name())
+		}
+	}
+	if d.More() {
+		t.Errorf("printAtomJSONView: unexpected output after last atom")
+	}
+}
+
+func TestPrintAtomJSONLines(t *testing.T) {
+	atoms, err := ReadAtomsFromInput(findTestFiles())
+	if err != nil {
+		t.Fatalf("printAtomJSONLines: unable to read test files: %s", err)
+	}
+	for _, a := range atoms {
+		var out bytes.Buffer
+		printAtomJSONLines(&out, a)
+
+		// each line describes a leaf atom, with a path which selects it
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		for _, line := range lines {
+			var got struct {
+				Path string
+				Type string
+			}
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("printAtomJSONLines(%s): line is not valid JSON: %s\n%s", a.Name(), err, line)
+			}
+			found, err := a.AtomsAtPath(got.Path)
+			if err != nil || len(found) != 1 || found[0].Type() != got.Type {
+				t.Errorf("printAtomJSONLines(%s): path %s selects %d atoms with err %v, want one %s atom", a.Name(), got.Path, len(found), err, got.Type)
+			}
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var a = new(ade.Atom)
+	err := a.UnmarshalText([]byte("NODS:CONT:\n" +
+		"\tNODE:CONT:\n\t\tNAME:CSTR:\"dc1, s1\"\n\t\tCAPA:UI64:1000\n\t\tUSED:FP32:0.25\n\tEND\n" +
+		"\tNODE:CONT:\n\t\tNAME:CSTR:\"dc1 \\\"s2\\\"\"\n\t\tONLN:UI01:1\n\tEND\n" +
+		"END\n"))
+	if err != nil {
+		t.Fatalf("writeTable: unable to create test atom: %s", err)
+	}
+	tests := []struct {
+		tsv  bool
+		cols []string
+		want string
+	}{
+		{false, []string{"NAME", "CAPA", "USED", "ONLN"},
+			"NAME,CAPA,USED,ONLN\n\"dc1, s1\",1000,0.25,\n\"dc1 \"\"s2\"\"\",,,true\n"},
+		{true, []string{"NAME", "CAPA"},
+			"NAME\tCAPA\ndc1, s1\t1000\n\"dc1 \"\"s2\"\"\"\t\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := writeTable(&out, []*ade.Atom{a}, "//NODE", test.cols, test.tsv); err != nil {
+			t.Errorf("writeTable(%q): expect no error, got %s", test.cols, err)
+			continue
+		}
+		if got := out.String(); got != test.want {
+			t.Errorf("writeTable(%q): got\n%s\nwant\n%s", test.cols, got, test.want)
+		}
+	}
+
+	if err := writeTable(ioutil.Discard, []*ade.Atom{a}, "//NODE", nil, false); err == nil {
+		t.Errorf("writeTable(): expect error for missing columns, got none")
+	}
+}
+
+func TestPathValues(t *testing.T) {
+	var a = new(ade.Atom)
+	err := a.UnmarshalText([]byte("NODS:CONT:\n\tNODE:CONT:\n\t\tCAPA:UI64:1000\n\tEND\n\tNODE:CONT:\n\t\tCAPA:UI64:500\n\tEND\nEND\n"))
+	if err != nil {
+		t.Fatalf("PathValues: unable to create test atom: %s", err)
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"count(//NODE)", []string{"2"}},
+		{"sum(//CAPA/data())", []string{"1500"}},
+		{"//CAPA/data() div 1000", []string{"1", "0.5"}},
+		{"count(//NODE) = 2", []string{"true"}},
+	}
+	for _, test := range tests {
+		got, err := PathValues([]*ade.Atom{a, a}, test.path)
+		if err != nil {
+			t.Errorf("PathValues(%s): expect no error, got %s", test.path, err)
+			continue
+		}
+		want := append(test.want, test.want...) // once for each root
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("PathValues(%s): got %q, want %q", test.path, got, want)
+		}
+	}
+}
+
+func TestPrintSalvagedText(t *testing.T) {
+	var a = new(ade.Atom)
+	err := a.UnmarshalText([]byte("ROOT:CONT:\n\tNODE:CONT:\n\t\tLEAF:UI32:1\n\t\tLEAF:UI32:2\n\tEND\n\tBVER:UI32:3\nEND\n"))
+	if err != nil {
+		t.Fatalf("printSalvagedText: unable to create test atom: %s", err)
+	}
+	buf, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("printSalvagedText: unable to create test input: %s", err)
+	}
+	atoms, regions := ade.SalvageAtomsFromBinary(buf[:50])
+	var damaged []DamageNote
+	for _, r := range regions {
+		damaged = append(damaged, DamageNote{r, "x.bin"})
+	}
+
+	// damage comments are where the input ends, inside each truncated container
+	want := []string{
+		"ROOT:CONT:",
+		"\tNODE:CONT:",
+		"\t\tLEAF:UI32:1",
+		"\t\t# x.bin: damaged region at byte 12, length 0: container NODE",
+		"\t\t# x.bin: damaged region at byte 40, length 10: unexpected end of input",
+		"\tEND",
+		"\t# x.bin: damaged region at byte 0, length 0: container ROOT",
+		"END",
+		"",
+	}
+	var out bytes.Buffer
+	printSalvagedText(&out, atoms, nil, damaged, 0)
+	got := strings.Split(out.String(), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("printSalvagedText: got\n%s\nwant %d lines", out.String(), len(want)-1)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("printSalvagedText: line %d: got %q, want prefix %q", i+1, got[i], want[i])
+		}
+	}
+
+	// comments are ignored when the output is read back
+	var b = new(ade.Atom)
+	if err := b.UnmarshalText(out.Bytes()); err != nil {
+		t.Fatalf("printSalvagedText: unable to read output as text: %s", err)
+	}
+	wantText, _ := atoms[0].MarshalText()
+	if gotText, _ := b.MarshalText(); string(gotText) != string(wantText) {
+		t.Errorf("printSalvagedText: output read back as\n%s\nwant\n%s", gotText, wantText)
+	}
+}
@@ -0,0 +1,478 @@
+// cgen writes Go types for ADE AtomContainers, inferred from sample containers.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade"
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+var (
+	FlagFilename = flag.String("o", "", "write output to file")
+	FlagPackage  = flag.String("package", "main", "package name of the generated source")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cgen [options] <file> ...")
+	fmt.Fprintln(os.Stderr, "       cat <file> | cgen [options]")
+	fmt.Fprintln(os.Stderr, "Purpose:")
+	fmt.Fprintln(os.Stderr, "       Read sample atoms from ADE binary container format, write Go struct types with ade")
+	fmt.Fprintln(os.Stderr, "       struct tags that ade.Marshal and ade.Unmarshal can use for containers like them.")
+	fmt.Fprintln(os.Stderr, "       Reads input from STDIN if no filenames given. Input may also be a hex representation")
+	fmt.Fprintln(os.Stderr, "       of the binary format.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "       Each container becomes a struct type, and each atom within it a field. Atoms that")
+	fmt.Fprintln(os.Stderr, "       are repeated in any sample become slices, and atoms missing from any sample become")
+	fmt.Fprintln(os.Stderr, "       pointers. Give several samples to cover atoms that are sometimes repeated or absent.")
+	fmt.Fprintln(os.Stderr, "       Atoms seen with more than one ADE type become *ade.Atom fields, which hold any type.")
+	fmt.Fprintln(os.Stderr, "       Fields keep the order of atoms in the samples. Containers holding atoms in an order")
+	fmt.Fprintln(os.Stderr, "       that a struct can't keep, such as repeated atoms interleaved with others, become")
+	fmt.Fprintln(os.Stderr, "       *ade.Atom fields.")
+	fmt.Fprintln(os.Stderr, "Options:")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "Examples:")
+	fmt.Fprintln(os.Stderr, `       # write types for node bundles to a package`)
+	fmt.Fprintln(os.Stderr, `       cgen -package=bundle -o bundle/types.go NODE.*.bin`)
+	fmt.Fprintln(os.Stderr, ``)
+	fmt.Fprintln(os.Stderr, `       # write types for a container printed as text`)
+	fmt.Fprintln(os.Stderr, `       ctac GINF.txt - | cgen`)
+
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("cgen: ")
+	if flag.NArg() == 0 && stdinIsEmpty() {
+		usage()
+	}
+
+	atoms, err := readAtoms(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(atoms) == 0 {
+		log.Fatal("no atoms found in input")
+	}
+
+	var buf bytes.Buffer
+	if err = generate(&buf, *FlagPackage, flag.Args(), atoms); err != nil {
+		log.Fatal(err)
+	}
+
+	if "" == *FlagFilename {
+		_, err = os.Stdout.Write(buf.Bytes())
+	} else {
+		err = ioutil.WriteFile(*FlagFilename, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func stdinIsEmpty() bool {
+	stat, _ := os.Stdin.Stat()
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+// readAtoms reads binary or hex atoms from each of the files, or from STDIN if
+// no files are given.
+func readAtoms(files []string) (atoms []*ade.Atom, err error) {
+	if len(files) == 0 {
+		buf, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(buf, []byte("0x")) {
+			atoms, err = ade.ReadAtomsFromHex(bytes.NewReader(buf))
+		} else {
+			atoms, err = ade.ReadAtomsFromBinary(bytes.NewReader(buf))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse STDIN as a binary atom container: %w", err)
+		}
+		return atoms, nil
+	}
+
+	for _, result := range ade.LoadFiles(files, 0) {
+		if result.Err != nil {
+			return nil, fmt.Errorf("unable to parse file '%s' as a binary atom container: %w", result.Path, result.Err)
+		}
+		atoms = append(atoms, result.Atoms...)
+	}
+	return atoms, nil
+}
+
+// structType describes a Go struct type for the containers found at one path.
+type structType struct {
+	name   string // Go type name
+	atom   string // atom name
+	path   string // path of the containers, such as /GINF/GIDV
+	count  int    // number of containers seen
+	fields []*structField
+	byAtom map[string]*structField
+	orders [][]*structField // fields of the atoms of each container seen, in order
+}
+
+// structField describes a struct field for the atoms of one name found in the
+// containers of a structType.
+type structField struct {
+	name     string          // Go field name
+	atom     string          // atom name
+	types    []codec.ADEType // ADE types seen, in order of appearance
+	seen     int             // number of containers holding the atom
+	repeated bool            // true if a container holds the atom more than once
+	elem     *structType     // struct type of a container atom
+}
+
+// generator infers struct types from sample containers.
+type generator struct {
+	types  []*structType // in order of appearance, containing types first
+	byPath map[string]*structType
+	names  map[string]bool // Go type names in use
+
+	usesAtom bool // true if a field of type *ade.Atom was written
+}
+
+// adePackage is the import path of package ade, which holds the Atom type.
+const adePackage = "github.com/gongfarmer/ntap/encoding/ade"
+
+// generate writes formatted Go source holding struct types for the sample
+// atoms, which were read from the named sources.
+func generate(w io.Writer, pkg string, sources []string, atoms []*ade.Atom) error {
+	g, err := newGenerator(atoms)
+	if err != nil {
+		return err
+	}
+
+	// types are written first, to learn whether package ade is needed
+	var types bytes.Buffer
+	for _, t := range g.reachableTypes() {
+		g.writeType(&types, t)
+	}
+
+	var buf bytes.Buffer
+	if len(sources) == 0 {
+		sources = []string{"STDIN"}
+	}
+	fmt.Fprintf(&buf, "// Types for ADE containers, inferred by cgen from %s.\n\n", strings.Join(sources, ", "))
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+	if g.usesAtom {
+		fmt.Fprintf(&buf, "\nimport %q\n", adePackage)
+	}
+	buf.Write(types.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to format generated source: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// newGenerator returns a generator holding the struct types inferred from the
+// sample atoms, which must be containers.
+func newGenerator(atoms []*ade.Atom) (*generator, error) {
+	var g = generator{byPath: make(map[string]*structType), names: make(map[string]bool)}
+	for _, a := range atoms {
+		if a.Type() != string(codec.CONT) {
+			return nil, fmt.Errorf("sample atom %s:%s is not a container", a.Name(), a.Type())
+		}
+		g.addContainer(a, "", nil)
+	}
+	return &g, nil
+}
+
+// addContainer records a sample container found within the parent type, or
+// at the top level if parent is nil.
+func (g *generator) addContainer(a *ade.Atom, parentPath string, parent *structType) *structType {
+	var path = parentPath + "/" + tagName(a)
+	t, ok := g.byPath[path]
+	if !ok {
+		t = &structType{name: g.typeName(a, parent), atom: tagName(a), path: path, byAtom: make(map[string]*structField)}
+		g.byPath[path] = t
+		g.types = append(g.types, t)
+	}
+	t.count++
+
+	var counts = make(map[string]int)
+	var prev *structField
+	var order []*structField
+	for _, child := range a.Children() {
+		var name = tagName(child)
+		f, ok := t.byAtom[name]
+		if !ok {
+			f = &structField{name: fieldName(t, name), atom: name}
+			t.byAtom[name] = f
+			t.insertField(f, prev)
+		}
+		if f != prev {
+			order = append(order, f)
+		}
+		prev = f
+		counts[name]++
+		if counts[name] == 1 {
+			f.seen++
+		} else {
+			f.repeated = true
+		}
+		var typ = codec.ADEType(child.Type())
+		if !hasType(f.types, typ) {
+			f.types = append(f.types, typ)
+		}
+		if typ == codec.CONT {
+			f.elem = g.addContainer(child, path, t)
+		}
+	}
+	t.orders = append(t.orders, order)
+	return t
+}
+
+// keepsOrder returns true if the fields of the struct type are in the order of
+// the atoms in every container seen, so that Marshal gives back the same
+// container. Repeated atoms interleaved with others, or atoms in a different
+// order in different containers, can't be kept in order by a struct.
+func (t *structType) keepsOrder() bool {
+	var index = make(map[*structField]int, len(t.fields))
+	for i, f := range t.fields {
+		index[f] = i
+	}
+	for _, order := range t.orders {
+		for i := 1; i < len(order); i++ {
+			if index[order[i]] <= index[order[i-1]] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// insertField adds a field to the struct type after the field prev, or first
+// if prev is nil, so that fields keep the order of atoms in the samples.
+func (t *structType) insertField(f, prev *structField) {
+	var i = 0
+	for j, field := range t.fields {
+		if field == prev {
+			i = j + 1
+		}
+	}
+	t.fields = append(t.fields, nil)
+	copy(t.fields[i+1:], t.fields[i:])
+	t.fields[i] = f
+}
+
+// typeName returns an unused Go type name for containers of the atom's name.
+// The name of the parent type is added if the atom name is already in use for
+// containers elsewhere.
+func (g *generator) typeName(a *ade.Atom, parent *structType) string {
+	var name = goName(tagName(a))
+	if g.names[name] && parent != nil {
+		name = parent.name + name
+	}
+	name = uniqueName(name, g.names)
+	g.names[name] = true
+	return name
+}
+
+// reachableTypes returns the struct types in order of appearance, leaving
+// out those only used for atoms that are held in a field of type *ade.Atom.
+func (g *generator) reachableTypes() (types []*structType) {
+	var reachable = make(map[*structType]bool)
+	for _, t := range g.types {
+		if strings.Count(t.path, "/") == 1 {
+			reachable[t] = true
+		}
+		if !reachable[t] {
+			continue
+		}
+		types = append(types, t)
+		if !t.keepsOrder() {
+			continue // written as ade.Atom
+		}
+		for _, f := range t.fields {
+			if !f.isAtom() && f.elem != nil {
+				reachable[f.elem] = true
+			}
+		}
+	}
+	return types
+}
+
+// writeType writes the Go declaration of a struct type.
+func (g *generator) writeType(w io.Writer, t *structType) {
+	fmt.Fprintf(w, "\n// %s holds the %s containers at %s.\n", t.name, t.atom, t.path)
+	if !t.keepsOrder() {
+		fmt.Fprintf(w, "// It is an ade.Atom, since a struct cannot keep the order of their atoms.\n")
+		fmt.Fprintf(w, "type %s = ade.Atom\n", t.name)
+		g.usesAtom = true
+		return
+	}
+	fmt.Fprintf(w, "type %s struct {\n", t.name)
+	if strings.Count(t.path, "/") == 1 {
+		fmt.Fprintf(w, "ADEName struct{} `ade:%q`\n", t.atom)
+	}
+	for _, f := range t.fields {
+		goType, tag, comment := f.declaration(t.count)
+		if f.isAtom() {
+			g.usesAtom = true
+		}
+		fmt.Fprintf(w, "%s %s `ade:%q`", f.name, goType, tag)
+		if comment != "" {
+			fmt.Fprintf(w, " // %s", comment)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// isAtom returns true if the field holds atoms of more than one type, or
+// containers whose atoms a struct can't keep in order, so that its atoms are
+// kept as *ade.Atom.
+func (f *structField) isAtom() bool {
+	return len(f.types) > 1 || f.elem != nil && !f.elem.keepsOrder()
+}
+
+// declaration returns the Go type and ade struct tag of the field in a struct
+// type of count sample containers, along with a comment describing any
+// conflict between the atoms seen.
+func (f *structField) declaration(count int) (goType, tag, comment string) {
+	goType, tag, comment = f.elemDeclaration()
+	if f.repeated {
+		goType = "[]" + goType
+	} else if f.seen < count && !f.isAtom() {
+		goType = "*" + goType // optional
+	}
+	return
+}
+
+// elemDeclaration returns the Go type and ade struct tag of a single atom of
+// the field, along with a comment describing any conflict between the atoms
+// seen.
+func (f *structField) elemDeclaration() (goType, tag, comment string) {
+	var typ = f.types[0]
+	if f.isAtom() {
+		// an *ade.Atom holds any atom, and is nil if it is missing
+		if len(f.types) == 1 {
+			return "*ade.Atom", f.atom, "a struct cannot keep the order of atoms in the containers"
+		}
+		var names = make([]string, len(f.types))
+		for i, t := range f.types {
+			names[i] = string(t)
+		}
+		return "*ade.Atom", f.atom, "atoms have types " + strings.Join(names, ", ")
+	}
+	if typ == codec.CONT {
+		return f.elem.name, f.atom, comment
+	}
+	return goTypes[typ], f.atom + "," + string(typ), comment
+}
+
+// goTypes holds the Go type used for each ADE type other than CONT. UF64 and
+// SF64 have more precision than a float64, so their values are kept as strings.
+var goTypes = map[codec.ADEType]string{
+	codec.UI01: "bool",
+	codec.UI08: "uint8",
+	codec.UI16: "uint16",
+	codec.UI32: "uint32",
+	codec.UI64: "uint64",
+	codec.SI08: "int8",
+	codec.SI16: "int16",
+	codec.SI32: "int32",
+	codec.SI64: "int64",
+	codec.FP32: "float32",
+	codec.FP64: "float64",
+	codec.UF32: "float64",
+	codec.UF64: "string",
+	codec.SF32: "float64",
+	codec.SF64: "string",
+	codec.UR32: "[]uint64",
+	codec.UR64: "[]uint64",
+	codec.SR32: "[]int64",
+	codec.SR64: "[]int64",
+	codec.FC32: "string",
+	codec.IP32: "string",
+	codec.IPAD: "string",
+	codec.CSTR: "string",
+	codec.USTR: "string",
+	codec.DATA: "[]byte",
+	codec.CNCT: "[]byte",
+	codec.Cnct: "[]byte",
+	codec.ENUM: "int32",
+	codec.UUID: "string",
+	codec.NULL: "string",
+}
+
+// tagName returns the atom name as written in an ade struct tag. Names that
+// hold characters other than letters, digits and underscore are written in
+// hex, so that they can't be mistaken for tag syntax.
+func tagName(a *ade.Atom) string {
+	var name = a.Name()
+	if strings.IndexFunc(name, func(r rune) bool { return !isIdentRune(r) }) >= 0 {
+		return fmt.Sprintf("0x%08X", a.NameAsUint32())
+	}
+	return name
+}
+
+// goName returns an exported Go identifier for an atom name, such as Bver for
+// BVER, or X00000001 for 0x00000001.
+func goName(atom string) string {
+	if strings.HasPrefix(atom, "0x") {
+		return "X" + atom[2:]
+	}
+	var name = []rune(strings.ToLower(atom))
+	for i, r := range name {
+		if !isIdentRune(r) {
+			name[i] = '_'
+		}
+	}
+	if name[0] < 'a' || name[0] > 'z' {
+		return "X" + string(name)
+	}
+	return strings.ToUpper(string(name[0])) + string(name[1:])
+}
+
+// fieldName returns an unused field name of the struct type for the atom
+// name.
+func fieldName(t *structType, atom string) string {
+	var used = map[string]bool{"ADEName": true}
+	for _, f := range t.fields {
+		used[f.name] = true
+	}
+	return uniqueName(goName(atom), used)
+}
+
+// uniqueName returns the name, with a number added if it is already used.
+func uniqueName(name string, used map[string]bool) string {
+	if !used[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		if n := fmt.Sprintf("%s%d", name, i); !used[n] {
+			return n
+		}
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+func hasType(types []codec.ADEType, typ codec.ADEType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
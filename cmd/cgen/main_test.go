@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade"
+)
+
+func TestGenerate(t *testing.T) {
+	samples := []string{
+		"GRID:CONT:\n\tBVER:UI32:1\n\tNODE:CONT:\n\t\tNDID:UI32:1\n\t\tAVAL:CONT:\n\t\t\t0x00000001:UI32:1\n\t\tEND\n\tEND\n" +
+			"\tNODE:CONT:\n\t\tNDID:UI32:2\n\t\tADDR:IP32:10.0.0.2\n\t\tAVAL:CONT:\n\t\tEND\n\tEND\n\tAVAL:CONT:\n\t\t0x00000001:SI32:-1\n\tEND\nEND\n",
+		"GRID:CONT:\n\tBVER:UI32:2\n\tRATE:UF32:1.5\n\tAVAL:CONT:\n\t\t0x00000001:CSTR:\"x\"\n\tEND\n\ta:bc:DATA:0x00\nEND\n",
+	}
+	// backquotes can't appear in a raw string, so tags are written with single quotes
+	want := strings.Replace(`// Types for ADE containers, inferred by cgen from one.bin, two.bin.
+
+package grid
+
+import "github.com/gongfarmer/ntap/encoding/ade"
+
+// Grid holds the GRID containers at /GRID.
+type Grid struct {
+	ADEName   struct{} 'ade:"GRID"'
+	Bver      uint32   'ade:"BVER,UI32"'
+	Rate      *float64 'ade:"RATE,UF32"'
+	Node      []Node   'ade:"NODE"'
+	Aval      GridAval 'ade:"AVAL"'
+	X613A6263 *[]byte  'ade:"0x613A6263,DATA"'
+}
+
+// Node holds the NODE containers at /GRID/NODE.
+type Node struct {
+	Ndid uint32  'ade:"NDID,UI32"'
+	Addr *string 'ade:"ADDR,IP32"'
+	Aval Aval    'ade:"AVAL"'
+}
+
+// Aval holds the AVAL containers at /GRID/NODE/AVAL.
+type Aval struct {
+	X00000001 *uint32 'ade:"0x00000001,UI32"'
+}
+
+// GridAval holds the AVAL containers at /GRID/AVAL.
+type GridAval struct {
+	X00000001 *ade.Atom 'ade:"0x00000001"' // atoms have types SI32, CSTR
+}
+`, "'", "`", -1)
+	var atoms []*ade.Atom
+	for _, sample := range samples {
+		var a = new(ade.Atom)
+		if err := a.UnmarshalText([]byte(sample)); err != nil {
+			t.Fatalf("generate: unable to create test atom: %s", err)
+		}
+		atoms = append(atoms, a)
+	}
+	var buf bytes.Buffer
+	if err := generate(&buf, "grid", []string{"one.bin", "two.bin"}, atoms); err != nil {
+		t.Fatalf("generate: expect no error, got %s", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("generate: got\n%s\nwant\n%s", got, want)
+	}
+
+	// containers holding atoms in an order that a struct can't keep are atoms
+	var interleaved = "\tPAIR:CONT:\n\t\tNAME:CSTR:\"a\"\n\t\tVALU:UI32:1\n\t\tNAME:CSTR:\"b\"\n\t\tVALU:UI32:2\n\tEND\n"
+	samples = []string{"LIST:CONT:\n" + interleaved + "\tSIZE:UI32:1\nEND\n", "PAIR:CONT:\n\tVALU:UI32:1\n\tNAME:CSTR:\"a\"\nEND\n", "PAIR:CONT:\n\tNAME:CSTR:\"a\"\n\tVALU:UI32:1\nEND\n"}
+	want = strings.Replace(`// Types for ADE containers, inferred by cgen from STDIN.
+
+package main
+
+import "github.com/gongfarmer/ntap/encoding/ade"
+
+// List holds the LIST containers at /LIST.
+type List struct {
+	ADEName struct{}  'ade:"LIST"'
+	Pair    *ade.Atom 'ade:"PAIR"' // a struct cannot keep the order of atoms in the containers
+	Size    uint32    'ade:"SIZE,UI32"'
+}
+
+// Pair2 holds the PAIR containers at /PAIR.
+// It is an ade.Atom, since a struct cannot keep the order of their atoms.
+type Pair2 = ade.Atom
+`, "'", "`", -1)
+	atoms = nil
+	for _, sample := range samples {
+		var a = new(ade.Atom)
+		if err := a.UnmarshalText([]byte(sample)); err != nil {
+			t.Fatalf("generate: unable to create test atom: %s", err)
+		}
+		atoms = append(atoms, a)
+	}
+	buf.Reset()
+	if err := generate(&buf, "main", nil, atoms); err != nil {
+		t.Fatalf("generate(interleaved): expect no error, got %s", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("generate(interleaved): got\n%s\nwant\n%s", got, want)
+	}
+
+	// samples must be containers
+	var a = new(ade.Atom)
+	if err := a.UnmarshalText([]byte("BVER:UI32:1\n")); err != nil {
+		t.Fatalf("generate: unable to create test atom: %s", err)
+	}
+	err := generate(&buf, "grid", nil, []*ade.Atom{a})
+	if err == nil || !strings.Contains(err.Error(), "not a container") {
+		t.Errorf("generate(BVER:UI32): got err {%v}, want {not a container}", err)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"BVER", "Bver"},
+		{"KEY_", "Key_"},
+		{"0x00000001", "X00000001"},
+		{"1ABC", "X1abc"},
+		{"_ABC", "X_abc"},
+	}
+	for _, test := range tests {
+		if got := goName(test.input); got != test.want {
+			t.Errorf("goName(%s): got %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+// Verify that the types generated for each binary test file compile, and
+// hold its atoms through Unmarshal and Marshal without losing anything. Each
+// file's types are written to their own package, used by a program that
+// checks the round trip.
+func TestGenerateRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling generated types is slow")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	files, err := filepath.Glob("../../encoding/ade/testdata/*.bin")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("generate: unable to find test files: %v", err)
+	}
+	files = append(files, "../../testdata/adeBinaryContainer/test03.bin")
+
+	dir, err := ioutil.TempDir("", "cgen")
+	if err != nil {
+		t.Fatalf("generate: unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var imports, samples bytes.Buffer
+	for i, result := range ade.LoadFiles(files, 0) {
+		if result.Err != nil {
+			t.Fatalf("generate: unable to read test file %s: %s", result.Path, result.Err)
+		}
+		var pkg = fmt.Sprintf("sample%d", i)
+		var src bytes.Buffer
+		if err := generate(&src, pkg, []string{filepath.Base(result.Path)}, result.Atoms); err != nil {
+			t.Errorf("generate(%s): expect no error, got %s", result.Path, err)
+			continue
+		}
+		writeTestFile(t, filepath.Join(dir, "src", "cgentest", pkg, "types.go"), src.Bytes())
+
+		// the generator names the same types as generate did
+		g, _ := newGenerator(result.Atoms)
+		path, _ := filepath.Abs(result.Path)
+		fmt.Fprintf(&imports, "\t%q\n", "cgentest/"+pkg)
+		fmt.Fprintf(&samples, "\t{%q, []func() interface{}{", path)
+		for _, a := range result.Atoms {
+			fmt.Fprintf(&samples, "func() interface{} { return new(%s.%s) }, ", pkg, g.byPath["/"+tagName(a)].name)
+		}
+		fmt.Fprintf(&samples, "}},\n")
+	}
+	writeTestFile(t, filepath.Join(dir, "src", "cgentest", "main.go"),
+		[]byte(fmt.Sprintf(roundTripProgram, imports.String(), samples.String())))
+
+	var cmd = exec.Command(goTool, "run", "main.go")
+	cmd.Dir = filepath.Join(dir, "src", "cgentest")
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOFLAGS=",
+		"GOPATH="+dir+string(filepath.ListSeparator)+build.Default.GOPATH)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generate: round trip through generated types failed: %s\n%s", err, out)
+	}
+}
+
+// roundTripProgram checks that each sample file's atoms convert to the Go
+// types generated for them and back. It is completed with the imports of the
+// generated packages, and the samples holding a constructor of the type for
+// each atom in the file.
+const roundTripProgram = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade"
+%s)
+
+var samples = []struct {
+	path  string
+	types []func() interface{}
+}{
+%s}
+
+func main() {
+	var failed bool
+	for _, sample := range samples {
+		result := ade.LoadFiles([]string{sample.path}, 1)[0]
+		if result.Err != nil || len(result.Atoms) != len(sample.types) {
+			fmt.Printf("%%s: unable to read %%d atoms: %%v\n", sample.path, len(sample.types), result.Err)
+			failed = true
+			continue
+		}
+		for i, a := range result.Atoms {
+			for _, opts := range []ade.UnmarshalOptions{{}, {Strict: true}} {
+				v := sample.types[i]()
+				if err := ade.Unmarshal(a, v, opts); err != nil {
+					fmt.Printf("%%s: Unmarshal(%%+v): %%s\n", sample.path, opts, err)
+					failed = true
+					continue
+				}
+				b, err := ade.Marshal(v)
+				if err != nil {
+					fmt.Printf("%%s: Marshal: %%s\n", sample.path, err)
+					failed = true
+					continue
+				}
+				got, _ := b.MarshalBinary()
+				want, _ := a.MarshalBinary()
+				if !bytes.Equal(got, want) {
+					fmt.Printf("%%s: round trip differs: %%s\n", sample.path, firstDifference(b, a))
+					failed = true
+				}
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// firstDifference describes the first line that differs in the text of the
+// atoms.
+func firstDifference(got, want *ade.Atom) string {
+	gotText, _ := got.MarshalText()
+	wantText, _ := want.MarshalText()
+	gotLines := strings.Split(string(gotText), "\n")
+	wantLines := strings.Split(string(wantText), "\n")
+	for i := range wantLines {
+		if i >= len(gotLines) {
+			return fmt.Sprintf("line %%d: missing, want %%q", i+1, wantLines[i])
+		}
+		if gotLines[i] != wantLines[i] {
+			return fmt.Sprintf("line %%d: got %%q, want %%q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+	return "binary differs from text"
+}
+`
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("generate: unable to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("generate: unable to write %s: %s", path, err)
+	}
+}
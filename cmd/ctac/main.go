@@ -1,4 +1,4 @@
-// ctac converts ADE ContainerText into binary.
+// ctac converts ADE ContainerText or containerxml into binary.
 package main
 
 import (
@@ -17,6 +17,7 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: ctac <filename> <outfilename>\n")
 	fmt.Fprintf(os.Stderr, "       cat <filename> | ctac <outfilename>\n")
 	fmt.Fprintf(os.Stderr, "Purpose: Read atoms from ADE Container Text format, write them as binary containers.\n")
+	fmt.Fprintf(os.Stderr, "         Input starting with \"<?xml\" is read as containerxml instead, as written by ccat -x.\n")
 	fmt.Fprintf(os.Stderr, "         <outfilename> may be \"-\" to print binary chars as output.\n")
 	//	fmt.Fprintln(os.Stderr, "Options:")
 	//	flag.PrintDefaults()
@@ -46,29 +47,42 @@ func main() {
 	var bb bytes.Buffer
 	bytesRead, err := bb.ReadFrom(input)
 	if err != nil {
-		log.Fatalf("unable to read input", err.Error())
+		log.Fatalf("unable to read input: %s", err)
 	}
 	if bytesRead == 0 {
 		log.Fatalf("empty input")
 	}
 
 	// Convert text to atom
-	var a ade.Atom
-	if err = a.UnmarshalText(bb.Bytes()); err != nil {
+	a, err := readAtom(bb.Bytes())
+	if err != nil {
 		log.Fatalf("invalid input container: %s", err)
 	}
 
 	// Convert atom to binary
 	buf, err := a.MarshalBinary()
 	if err != nil {
-		log.Fatalf("unable to convert container to binary: ", err)
+		log.Fatalf("unable to convert container to binary: %s", err)
 	}
 
 	// Write binary to file
 	_, err = output.Write(buf)
 	if err != nil {
-		log.Fatalf("unable to write to file: ", err)
+		log.Fatalf("unable to write to file: %s", err)
+	}
+}
+
+// readAtom converts input text to an atom. Input is read as containerxml if
+// it starts with an XML declaration, and as ADE ContainerText otherwise.
+func readAtom(input []byte) (*ade.Atom, error) {
+	if bytes.HasPrefix(bytes.TrimLeft(input, " \t\r\n"), []byte("<?xml")) {
+		return ade.XMLDocumentToAtom(input)
+	}
+	var a = new(ade.Atom)
+	if err := a.UnmarshalText(input); err != nil {
+		return nil, err
 	}
+	return a, nil
 }
 
 // stdinIsEmpty returns true if there is nothing to read on STDIN
@@ -80,16 +94,18 @@ func stdinIsEmpty() bool {
 // Define how to get input text, based on command line arguments
 func setInput(argv []string) (input io.Reader, args []string) {
 	var err error
-	if len(argv) == 0 {
+	if len(argv) < 2 {
+		// a single argument is the output file, input must be piped in
 		if stdinIsEmpty() {
 			fmt.Fprintln(os.Stderr, "please provide input filename, or pipe in some text.")
 			usage()
 		} else {
 			input = os.Stdin
 		}
+		args = argv
 	} else {
 		if input, err = os.Open(argv[0]); err != nil {
-			log.Fatalf(err.Error())
+			log.Fatal(err)
 		}
 		args = argv[1:]
 	}
@@ -107,7 +123,7 @@ func setOutput(argv []string) (output io.Writer, args []string) {
 		} else {
 			output, err = os.OpenFile(argv[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 			if err != nil {
-				log.Fatalf(err.Error())
+				log.Fatal(err)
 			}
 		}
 		args = argv[1:]
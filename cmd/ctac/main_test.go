@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func findTestFiles(pattern string) []string {
+	_, dir, _, _ := runtime.Caller(0)
+	files, _ := filepath.Glob(filepath.Join(dir, "../../../encoding/ade/testdata/from_grid/", pattern))
+	return files
+}
+
+func TestReadAtom(t *testing.T) {
+	files := findTestFiles("*.bin")
+	if len(files) == 0 {
+		t.Fatal("TestReadAtom: no test files found")
+	}
+	for _, binFile := range files {
+		name := filepath.Base(binFile)
+		want, err := ioutil.ReadFile(binFile)
+		if err != nil {
+			t.Fatalf("readAtom(%s): unable to read test file: %s", name, err)
+		}
+		for _, ext := range []string{".txt", ".xml"} {
+			input, err := ioutil.ReadFile(strings.TrimSuffix(binFile, ".bin") + ext)
+			if err != nil {
+				t.Fatalf("readAtom(%s): unable to read test file: %s", name, err)
+			}
+			a, err := readAtom(input)
+			if err != nil {
+				t.Errorf("readAtom(%s%s): expect no error, got %s", name, ext, err)
+				continue
+			}
+			if got, _ := a.MarshalBinary(); !bytes.Equal(got, want) {
+				t.Errorf("readAtom(%s%s): result differs from binary test data", name, ext)
+			}
+		}
+	}
+}
@@ -4,12 +4,12 @@
 package ade
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 
 	"github.com/gongfarmer/ntap/encoding/ade/codec"
 )
@@ -26,6 +26,7 @@ type Atom struct {
 	typ      codec.ADEType
 	data     []byte
 	children []*Atom
+	lazy     []byte // binary encoding of children which have not been decoded yet
 	Value    *codec.Codec
 }
 
@@ -63,6 +64,9 @@ func (a *Atom) Type() string {
 
 // Children returns a slice of this Atom's child atoms
 func (a *Atom) Children() []*Atom {
+	if a.lazy != nil {
+		a.expand()
+	}
 	return a.children
 }
 
@@ -86,6 +90,7 @@ func (a *Atom) Zero() {
 	a.name = []byte{0, 0, 0, 0}
 	a.SetType(codec.NULL)
 	a.children = []*Atom{}
+	a.lazy = nil
 }
 
 // SetType sets the type of an Atom object, and updates the Codec and
@@ -113,7 +118,7 @@ func (a *Atom) AddChild(child *Atom) bool {
 	if a.typ != codec.CONT {
 		return false
 	}
-	a.children = append(a.children, child)
+	a.children = append(a.Children(), child)
 	return true
 }
 
@@ -123,7 +128,7 @@ func (a *Atom) NumChildren() int {
 	if a.typ != codec.CONT {
 		return -1
 	}
-	return len(a.children)
+	return len(a.Children())
 }
 
 // Descendants returns a list of pointers to every Atom in hierarchical order.
@@ -135,35 +140,49 @@ func (a *Atom) Descendants() []*Atom {
 
 func (a *Atom) getDescendants(list *([]*Atom)) []*Atom {
 	*list = append(*list, a)
-	for _, child := range a.children {
+	for _, child := range a.Children() {
 		child.getDescendants(list)
 	}
 	return *list
 }
 
 // FromFile reads a binary AtomContainer from the named file path.
-func FromFile(path string) (a Atom, err error) {
-	fstat, err := os.Stat(path)
+// Where supported, the file is memory mapped rather than copied into a buffer
+// before decoding.
+//
+// If DecodeOptions are given, their limits are enforced on the file contents.
+// Otherwise DefaultDecodeOptions are used.
+func FromFile(path string, opts ...DecodeOptions) (a Atom, err error) {
+	var o = getDecodeOptions(opts)
+	buf, err := readMappedFile(path, o)
 	if err != nil {
 		return
 	}
+	defer unmapFile(buf)
 
-	buf, err := ioutil.ReadFile(path)
-	if err != nil {
+	if len(buf) < headerSize {
+		err = errFile(ErrTruncated,
+			"invalid AtomContainer file, file size %d is less than atom header size %d",
+			len(buf), headerSize)
 		return
 	}
 	var encodedSize = int64(binary.BigEndian.Uint32(buf[0:4]))
-	if encodedSize != fstat.Size() {
-		err = fmt.Errorf(
+	if encodedSize != int64(len(buf)) {
+		err = errFile(ErrSizeMismatch,
 			"invalid AtomContainer file, encoded size %d does not match file size %d",
-			encodedSize, fstat.Size())
+			encodedSize, len(buf))
 		return
 	}
 
-	err = a.UnmarshalBinary(buf)
+	err = a.UnmarshalFromReader(bytes.NewReader(buf), o)
 	return
 }
 
+// errFile returns a DecodeError for a problem with an entire AtomContainer file.
+func errFile(cause error, format string, args ...interface{}) error {
+	return &DecodeError{Err: cause, Msg: fmt.Sprintf(format, args...), Path: "/"}
+}
+
 // ValueString returns the atom data as an unescaped string, without delimiters.
 func (a *Atom) ValueString() string {
 	output, _ := a.Value.String()
@@ -124,7 +124,7 @@ func (t Test) Name() string {
 // Create slice of Test objects from testdata dir contents
 func init() {
 	// Find all test files under the test root
-	_, path, _, _ := runtime.Caller(1)
+	_, path, _, _ := runtime.Caller(0)
 	testroot := filepath.Join(filepath.Dir(path), "testdata")
 	testFileExt := map[string]bool{
 		".in":  true,
@@ -264,3 +264,15 @@ func TestFromFile(t *testing.T) {
 		t.Errorf(`TestFromFile(): Bundle from file "BID0" does not match expected got (%s), want(%s)`, string(buf), Bid0Text)
 	}
 }
+
+func TestFromFileLimits(t *testing.T) {
+	tst := findTest(Tests, "BID0")
+	_, err := FromFile(tst.binPath, DecodeOptions{MaxTotalBytes: 16})
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit of 16 bytes") {
+		t.Errorf(`TestFromFileLimits(): got err %v, want error for file size limit`, err)
+	}
+	_, err = FromFile(tst.binPath, DecodeOptions{MaxAtoms: 2})
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit of 2 atoms") {
+		t.Errorf(`TestFromFileLimits(): got err %v, want error for atom count limit`, err)
+	}
+}
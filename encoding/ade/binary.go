@@ -15,6 +15,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,13 +23,13 @@ import (
 	"github.com/gongfarmer/ntap/encoding/ade/codec"
 )
 
-// AtomContainer object, paired with its end byte position in the stream
+// AtomContainer start token, paired with its end byte position in the stream
 type cont struct {
-	atomPtr *Atom
-	end     uint32
+	start Token
+	end   int64
 }
 
-// Stack of Atoms of type container.
+// Stack of containers which have been opened but not yet fully read.
 type containerStack []cont
 
 // Peek returns a pointer to the last (top) element of the stack, without
@@ -54,15 +55,15 @@ func (s *containerStack) Pop() cont {
 
 // Pop fully-read containers off the container stack based on the given byte
 // position.
-func (s *containerStack) PopCompleted(pos uint32) (closedConts []*Atom, e error) {
+func (s *containerStack) PopCompleted(pos int64) (closedConts []cont, e error) {
 	// Pop until the given byte offset precedes the top object's end position.
 	for p, ok := s.Peek(); ok; p, ok = s.Peek() {
 		if pos == p.end {
-			closedConts = append(closedConts, s.Pop().atomPtr)
+			closedConts = append(closedConts, s.Pop())
 			continue // next CONT might end too
 		}
 		if pos > p.end {
-			e = fmt.Errorf("%s:CONT wanted to end at byte %d, but read position is now %d", p.atomPtr.Name(), p.end, pos)
+			e = fmt.Errorf("%s:CONT wanted to end at byte %d, but read position is now %d", p.start.Name, p.end, pos)
 		}
 		break
 	}
@@ -97,6 +98,7 @@ func (h atomHeader) printableName() (name string) {
 /**********************************************************/
 
 // UnmarshalBinary reads an Atom from a byte slice.
+// Input limits are set by DefaultDecodeOptions.
 //
 // It implements the encoding.BinaryMarshaler interface.
 func (a *Atom) UnmarshalBinary(data []byte) error {
@@ -107,11 +109,15 @@ func (a *Atom) UnmarshalBinary(data []byte) error {
 // a valid ADE Binary Container, the container is reconstructed with the Atom
 // receiver as the root container.
 // Returns an error if the byte stream is not a valid binary AtomContainer, or
-// there is more than one container in the stream.
-func (a *Atom) UnmarshalFromReader(r io.Reader) error {
-	atoms, err := ReadAtomsFromBinary(r)
+// there is more than one container in the stream. To read one container from
+// a stream of several, use ReadAtom.
+//
+// If DecodeOptions are given, their limits are enforced on the input.
+// Otherwise DefaultDecodeOptions are used.
+func (a *Atom) UnmarshalFromReader(r io.Reader, opts ...DecodeOptions) error {
+	atoms, err := ReadAtomsFromBinary(r, opts...)
 	if err != nil {
-		return fmt.Errorf("failed to parse binary stream: %s", err.Error())
+		return fmt.Errorf("failed to parse binary stream: %w", err)
 	}
 
 	// Set receiver to the sole top-level AtomContainer
@@ -131,83 +137,88 @@ func (a *Atom) UnmarshalFromReader(r io.Reader) error {
 // describe 0 or more ADE binary AtomContainers.
 // It reconstructs all the AtomContainers found and returns them in an array of Atom objects.
 // Returns an error if the byte stream contains invalid binary container data.
-func ReadAtomsFromBinary(r io.Reader) (atoms []*Atom, err error) {
-	defer func() {
-		if p := recover(); p != nil {
-			err = p.(error)
-		}
-	}()
-
-	var (
-		bytesRead  uint32
-		containers containerStack
-	)
-	for err == nil {
-		// read next atom header
-		var h atomHeader
-		h, err = readAtomHeader(r, &bytesRead)
-		if err == io.EOF {
-			err = nil
-			break
-		}
-
-		// print header including size, for debugging structure problems
-		Log.Printf("atom header %6d  %10s:%s \n", h.Size, h.printableName(), h.Type)
+//
+// If DecodeOptions are given, their limits are enforced on the input.
+// Otherwise DefaultDecodeOptions are used.
+//
+// To process the stream without building Atom objects, use a Decoder.
+func ReadAtomsFromBinary(r io.Reader, opts ...DecodeOptions) (atoms []*Atom, err error) {
+	return readAtomsFromDecoder(NewDecoder(r, opts...))
+}
 
-		// construct Atom object, read data
-		var data []byte
-		if !h.isContainer() {
-			data, err = readAtomData(r, h.Size-headerSize, &bytesRead)
-			if err != nil {
-				return nil, fmt.Errorf("Input is invalid for conversion to Atom")
-			}
+// readAtomsFromDecoder returns the AtomContainers read from the token stream
+// of the Decoder.
+func readAtomsFromDecoder(d *Decoder) (atoms []*Atom, err error) {
+	var containers atomStack
+	for {
+		var tk Token
+		tk, err = d.Token()
+		if err == io.EOF {
+			return atoms, nil
 		}
-		adeType := codec.ADEType(h.Type[:])
 		if err != nil {
-			break
+			return nil, err
 		}
-		data = truncateDataToSize(adeType, data)
-		var a = Atom{
-			name: h.Name[:],
-			typ:  adeType,
-			data: data,
+		if tk.Kind == EndContainer {
+			containers.pop()
+			continue
 		}
-		a.Value = codec.NewCodec(&a.data, a.typ)
 
 		// add atom to parent.Children, or to atoms list if no parent
-		if parent, ok := containers.Peek(); ok {
-			parent.atomPtr.AddChild(&a)
+		a := tk.atom()
+		if parent := containers.top(); parent != nil {
+			parent.AddChild(a)
 		} else {
-			atoms = append(atoms, &a)
+			atoms = append(atoms, a)
 		}
 
-		// push container onto stack
-		if a.typ == codec.CONT {
-			endPos := bytesRead + h.Size - headerSize
-			containers.Push(cont{&a, endPos})
+		// open containers receive the atoms that follow
+		if tk.Kind == StartContainer {
+			containers.push(a)
 		}
-
-		// pop fully read containers off stack
-		_, err = containers.PopCompleted(bytesRead)
-	}
-	if err != nil {
-		return nil, err
 	}
-	return
 }
 
-func readAtomHeader(r io.Reader, bytesRead *uint32) (h atomHeader, err error) {
-	h = atomHeader{}
-	err = binary.Read(r, binary.BigEndian, &h)
+// readAtomHeader reads an atom header from r, using buf to hold its bytes.
+// Decoding the header directly avoids the reflection done by binary.Read.
+func readAtomHeader(r io.Reader, buf *[headerSize]byte, bytesRead *int64) (h atomHeader, err error) {
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
 	*bytesRead += headerSize
-	return
+	return parseAtomHeader(buf[:]), nil
 }
 
-func readAtomData(r io.Reader, length uint32, bytesRead *uint32) (data []byte, err error) {
-	data = make([]byte, length)
-	err = binary.Read(r, binary.BigEndian, &data)
-	*bytesRead += length
-	return
+// parseAtomHeader decodes the atom header at the start of a byte slice, which
+// must hold at least headerSize bytes.
+func parseAtomHeader(b []byte) (h atomHeader) {
+	h.Size = binary.BigEndian.Uint32(b[0:4])
+	copy(h.Name[:], b[4:8])
+	copy(h.Type[:], b[8:12])
+	return h
+}
+
+// Atom data up to this size is read into a buffer allocated in advance.
+// Larger data is read into a buffer that grows as data arrives, so that the
+// size in a corrupt atom header cannot force a large allocation.
+const maxPreallocDataSize = 64 * 1024
+
+func readAtomData(r io.Reader, length uint32, bytesRead *int64) (data []byte, err error) {
+	if length <= maxPreallocDataSize {
+		data = make([]byte, length)
+		n, err := io.ReadFull(r, data)
+		*bytesRead += int64(n)
+		return data, err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, int64(length))
+	*bytesRead += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	data = buf.Bytes()
+	return data[:n:n], err
 }
 
 // Check that data size section does not exceed the expected size for
@@ -230,6 +241,14 @@ func truncateDataToSize(adeType codec.ADEType, data []byte) []byte {
 	return data[0:sizeExpected]
 }
 
+// isShortData returns true if the data size of an atom is less than the fixed
+// size of its type.  Unlike extra data, missing data can't be ignored, so such
+// an atom is invalid.
+func isShortData(adeType codec.ADEType, size uint32) bool {
+	var sizeExpected = codec.DataSize(adeType)
+	return sizeExpected > 0 && size < uint32(sizeExpected)
+}
+
 func errOddLength(len int, name string) error {
 	if name == "" {
 		return fmt.Errorf("odd length hex string (length %d)", len)
@@ -240,8 +259,12 @@ func errOddLength(len int, name string) error {
 // ReadAtomsFromHex reads a stream of hex characters that represent the binary
 // encodings of a series of Atoms.  It returns a slice of Atom pointers.
 // If no atoms are found on input, an empty slice is returned and the error code is nil.
-// If invalid input is encountered, a non-nil error is returned.
-func ReadAtomsFromHex(r io.Reader) (atoms []*Atom, err error) {
+// If invalid input is encountered, a non-nil error is returned. Input holding
+// no hex characters at all is invalid.
+//
+// If DecodeOptions are given, their limits are enforced on the binary data
+// that the hex characters decode to. Otherwise DefaultDecodeOptions are used.
+func ReadAtomsFromHex(r io.Reader, opts ...DecodeOptions) (atoms []*Atom, err error) {
 	var buffer []byte
 
 	buffer, err = ioutil.ReadAll(r)
@@ -257,9 +280,17 @@ func ReadAtomsFromHex(r io.Reader) (atoms []*Atom, err error) {
 		}
 	}
 
+	if len(clean) == 0 {
+		return nil, errors.New("no hex characters found on input")
+	}
+
 	// Don't attempt hex conversion without even length at this point
 	if 0 != len(clean)%2 {
-		return nil, errOddLength(len(clean), string(buffer[4:8]))
+		var name string
+		if len(buffer) >= 8 {
+			name = string(buffer[4:8])
+		}
+		return nil, errOddLength(len(clean), name)
 	}
 
 	// Strip leading 0x
@@ -276,17 +307,21 @@ func ReadAtomsFromHex(r io.Reader) (atoms []*Atom, err error) {
 	}
 
 	// Attempt conversion of the bytes buffer
-	atoms, err = ReadAtomsFromBinary(bytes.NewReader(buffer))
+	atoms, err = ReadAtomsFromBinary(bytes.NewReader(buffer), opts...)
 	if err == nil {
 		return // success!
 	}
 
-	// Conversion failed. Reverse endianness and try one more time.
+	// Conversion failed. Reverse endianness and try one more time, unless
+	// there is an odd byte out which can't be swapped.
+	if 0 != len(buffer)%2 {
+		return
+	}
 	for i := 0; i < len(buffer); i += 2 {
 		buffer[i], buffer[i+1] = buffer[i+1], buffer[i]
 	}
 
-	return ReadAtomsFromBinary(bytes.NewReader(buffer))
+	return ReadAtomsFromBinary(bytes.NewReader(buffer), opts...)
 }
 
 /**********************************************************/
@@ -328,7 +363,7 @@ func (a *Atom) BinaryWrite(w io.Writer) (err error) {
 	}
 
 	// write children
-	for _, child := range a.children {
+	for _, child := range a.Children() {
 		err = child.BinaryWrite(w)
 		if err != nil {
 			return
@@ -341,7 +376,7 @@ func (a *Atom) BinaryWrite(w io.Writer) (err error) {
 // Len returns the length this atom would have when encoded as binary bytes.
 func (a *Atom) Len() (length uint32) {
 	length = uint32(headerSize + len(a.data))
-	for _, child := range a.children {
+	for _, child := range a.Children() {
 		length += child.Len()
 	}
 	return
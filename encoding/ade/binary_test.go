@@ -12,6 +12,8 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -96,6 +98,27 @@ func TestReadAtomsFromHex(t *testing.T) {
 	}
 }
 
+// Hex files in testdata/crash/ hold hostile inputs which once caused crashes
+// or huge allocations. They must be rejected with an error.
+func TestReadAtomsFromHexCrash(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "crash", "*.hex"))
+	if len(paths) == 0 {
+		t.Fatalf("TestReadAtomsFromHexCrash: no test files found")
+	}
+	opts := DecodeOptions{MaxTotalBytes: 1 << 20, MaxDataSize: 1 << 16, MaxDepth: 32, MaxAtoms: 1000}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("TestReadAtomsFromHexCrash(%s): unable to open: %s", path, err)
+		}
+		_, err = ReadAtomsFromHex(f, opts)
+		f.Close()
+		if err == nil {
+			t.Errorf("TestReadAtomsFromHexCrash(%s): got err <nil>, want error", path)
+		}
+	}
+}
+
 // var BID0_HEX = []byte("0000004442494430434F4E5400000010425645525549333200000001000000144254494D55493634000546592CD6DB2C000000144E45585455493634DDDDF0000C000000")
 //
 // var BID0_TEXT = []byte(`
@@ -95,6 +95,12 @@ func DataSize(adeType ADEType) (bytes int) {
 	return bytes
 }
 
+// IsValidType returns true if the given type is a known ADE type.
+func IsValidType(adeType ADEType) bool {
+	_, ok := decoderByType[adeType]
+	return ok
+}
+
 //**********************************************************
 // Codec / Encoder / Decoder data structure definitions
 
@@ -2061,8 +2067,8 @@ func SliceOfInt64ToSR32Bytes(buf *[]byte, v []int64) (e error) {
 	if den == 0 {
 		return errZeroDenominator("SR32", "")
 	}
-	value := (int32(num) << 16) + int32(den)
-	binary.BigEndian.PutUint32(*buf, uint32(value))
+	binary.BigEndian.PutUint16((*buf)[0:], uint16(num))
+	binary.BigEndian.PutUint16((*buf)[2:], uint16(den))
 	return
 }
 
@@ -2099,8 +2105,8 @@ func SliceOfInt64ToSR64Bytes(buf *[]byte, v []int64) (e error) {
 		return errZeroDenominator("SR64", "")
 	}
 
-	value := (num << 32) + den
-	binary.BigEndian.PutUint64(*buf, uint64(value))
+	binary.BigEndian.PutUint32((*buf)[0:], uint32(num))
+	binary.BigEndian.PutUint32((*buf)[4:], uint32(den))
 	return
 }
 
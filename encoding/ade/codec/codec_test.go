@@ -2576,22 +2576,22 @@ func TestStringToSR32(t *testing.T) {
 		encoderTest{"1/0", zero, errZeroDenominator(typ, "1/0")},
 		encoderTest{"+0/+1", []byte("\x00\x00\x00\x01"), nil},
 		encoderTest{"+1/+1", []byte("\x00\x01\x00\x01"), nil},
-		encoderTest{"+0/-1", []byte("\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{"+0/-1", []byte("\x00\x00\xFF\xFF"), nil},
 		encoderTest{"-1/+1", []byte("\xFF\xFF\x00\x01"), nil},
-		encoderTest{"-1/-1", []byte("\xFF\xFE\xFF\xFF"), nil},
+		encoderTest{"-1/-1", []byte("\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{" 1/1", []byte("\x00\x01\x00\x01"), nil},
-		encoderTest{"1/-1", []byte("\x00\x00\xFF\xFF"), nil},
+		encoderTest{"1/-1", []byte("\x00\x01\xFF\xFF"), nil},
 		encoderTest{"-1/1", []byte("\xFF\xFF\x00\x01"), nil},
 		encoderTest{"1/1 ", []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{"1/ 1", []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{"0/1", []byte("\x00\x00\x00\x01"), nil},
 		encoderTest{"1/1", []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{"32767/32767", []byte("\x7F\xFF\x7F\xFF"), nil},
-		encoderTest{"32767/-32768", []byte("\x7F\xFE\x80\x00"), nil},
+		encoderTest{"32767/-32768", []byte("\x7F\xFF\x80\x00"), nil},
 		encoderTest{"-32768/32767", []byte("\x80\x00\x7F\xFF"), nil},
 		encoderTest{"+32767/+32767", []byte("\x7F\xFF\x7F\xFF"), nil},
-		encoderTest{"+32767/-32768", []byte("\x7F\xFE\x80\x00"), nil},
-		encoderTest{"-32768/-32768", []byte("\x7F\xFF\x80\x00"), nil},
+		encoderTest{"+32767/-32768", []byte("\x7F\xFF\x80\x00"), nil},
+		encoderTest{"-32768/-32768", []byte("\x80\x00\x80\x00"), nil},
 		encoderTest{"32768/32767", zero, errRange(typ, "[32768 32767]")},
 		encoderTest{"32767/32768", zero, errRange(typ, "[32767 32768]")},
 		encoderTest{"32768/32768", zero, errRange(typ, "[32768 32768]")},
@@ -2616,19 +2616,19 @@ func TestSliceOfIntToSR32(t *testing.T) {
 		encoderTest{[]int64{-0, 0}, zero, errZeroDenominator(typ, "")},
 		encoderTest{[]int64{0, 0}, zero, errZeroDenominator(typ, "")},
 		encoderTest{[]int64{1, 0}, zero, errZeroDenominator(typ, "")},
-		encoderTest{[]int64{-1, -1}, []byte("\xFF\xFE\xFF\xFF"), nil},
+		encoderTest{[]int64{-1, -1}, []byte("\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x01\x00\x01"), nil},
-		encoderTest{[]int64{1, -1}, []byte("\x00\x00\xFF\xFF"), nil},
+		encoderTest{[]int64{1, -1}, []byte("\x00\x01\xFF\xFF"), nil},
 		encoderTest{[]int64{-1, 1}, []byte("\xFF\xFF\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x01\x00\x01"), nil},
 		encoderTest{[]int64{-32768, 32767}, []byte("\x80\x00\x7F\xFF"), nil},
-		encoderTest{[]int64{-32768, -32768}, []byte("\x7F\xFF\x80\x00"), nil},
+		encoderTest{[]int64{-32768, -32768}, []byte("\x80\x00\x80\x00"), nil},
 		encoderTest{[]int64{-32769, 32767}, zero, errRange(typ, "[-32769 32767]")},
 		encoderTest{[]int64{-32769, -32769}, zero, errRange(typ, "[-32769 -32769]")},
 		encoderTest{[]int64{32767, 32767}, []byte("\x7F\xFF\x7F\xFF"), nil},
-		encoderTest{[]int64{32767, -32768}, []byte("\x7F\xFE\x80\x00"), nil},
+		encoderTest{[]int64{32767, -32768}, []byte("\x7F\xFF\x80\x00"), nil},
 		encoderTest{[]int64{32768, 32767}, zero, errRange(typ, "[32768 32767]")},
 		encoderTest{[]int64{32767, 32768}, zero, errRange(typ, "[32767 32768]")},
 		encoderTest{[]int64{32768, 32768}, zero, errRange(typ, "[32768 32768]")},
@@ -2654,22 +2654,22 @@ func TestStringToSR64(t *testing.T) {
 		encoderTest{"1/0", zero, errZeroDenominator(typ, "1/0")},
 		encoderTest{"+0/+1", []byte("\x00\x00\x00\x00\x00\x00\x00\x01"), nil},
 		encoderTest{"+1/+1", []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
-		encoderTest{"+0/-1", []byte("\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{"+0/-1", []byte("\x00\x00\x00\x00\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{"-1/+1", []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x01"), nil},
-		encoderTest{"-1/-1", []byte("\xFF\xFF\xFF\xFE\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{"-1/-1", []byte("\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{" 1/1", []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
-		encoderTest{"1/-1", []byte("\x00\x00\x00\x00\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{"1/-1", []byte("\x00\x00\x00\x01\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{"-1/1", []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x01"), nil},
 		encoderTest{"1/1 ", []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{"1/ 1", []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{"0/1", []byte("\x00\x00\x00\x00\x00\x00\x00\x01"), nil},
 		encoderTest{"1/1", []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{"2147483647/2147483647", []byte("\x7F\xFF\xFF\xFF\x7F\xFF\xFF\xFF"), nil},
-		encoderTest{"2147483647/-2147483648", []byte("\x7F\xFF\xFF\xFE\x80\x00\x00\x00"), nil},
+		encoderTest{"2147483647/-2147483648", []byte("\x7F\xFF\xFF\xFF\x80\x00\x00\x00"), nil},
 		encoderTest{"-2147483648/2147483647", []byte("\x80\x00\x00\x00\x7F\xFF\xFF\xFF"), nil},
 		encoderTest{"+2147483647/+2147483647", []byte("\x7F\xFF\xFF\xFF\x7F\xFF\xFF\xFF"), nil},
-		encoderTest{"+2147483647/-2147483648", []byte("\x7F\xFF\xFF\xFE\x80\x00\x00\x00"), nil},
-		encoderTest{"-2147483648/-2147483648", []byte("\x7F\xFF\xFF\xFF\x80\x00\x00\x00"), nil},
+		encoderTest{"+2147483647/-2147483648", []byte("\x7F\xFF\xFF\xFF\x80\x00\x00\x00"), nil},
+		encoderTest{"-2147483648/-2147483648", []byte("\x80\x00\x00\x00\x80\x00\x00\x00"), nil},
 		encoderTest{"2147483648/2147483647", zero, errRange(typ, "[2147483648 2147483647]")},
 		encoderTest{"2147483647/2147483648", zero, errRange(typ, "[2147483647 2147483648]")},
 		encoderTest{"2147483648/2147483648", zero, errRange(typ, "[2147483648 2147483648]")},
@@ -2694,18 +2694,18 @@ func TestSliceOfIntToSR64(t *testing.T) {
 		encoderTest{[]int64{-0, 0}, zero, errZeroDenominator(typ, "")},
 		encoderTest{[]int64{0, 0}, zero, errZeroDenominator(typ, "")},
 		encoderTest{[]int64{1, 0}, zero, errZeroDenominator(typ, "")},
-		encoderTest{[]int64{-1, -1}, []byte("\xFF\xFF\xFF\xFE\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{[]int64{-1, -1}, []byte("\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
-		encoderTest{[]int64{1, -1}, []byte("\x00\x00\x00\x00\xFF\xFF\xFF\xFF"), nil},
+		encoderTest{[]int64{1, -1}, []byte("\x00\x00\x00\x01\xFF\xFF\xFF\xFF"), nil},
 		encoderTest{[]int64{-1, 1}, []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{[]int64{0, 1}, []byte("\x00\x00\x00\x00\x00\x00\x00\x01"), nil},
 		encoderTest{[]int64{1, 1}, []byte("\x00\x00\x00\x01\x00\x00\x00\x01"), nil},
 		encoderTest{[]int64{2147483647, 2147483647}, []byte("\x7F\xFF\xFF\xFF\x7F\xFF\xFF\xFF"), nil},
-		encoderTest{[]int64{2147483647, -2147483648}, []byte("\x7F\xFF\xFF\xFE\x80\x00\x00\x00"), nil},
+		encoderTest{[]int64{2147483647, -2147483648}, []byte("\x7F\xFF\xFF\xFF\x80\x00\x00\x00"), nil},
 		encoderTest{[]int64{-2147483648, 2147483647}, []byte("\x80\x00\x00\x00\x7F\xFF\xFF\xFF"), nil},
-		encoderTest{[]int64{-2147483648, -2147483648}, []byte("\x7F\xFF\xFF\xFF\x80\x00\x00\x00"), nil},
+		encoderTest{[]int64{-2147483648, -2147483648}, []byte("\x80\x00\x00\x00\x80\x00\x00\x00"), nil},
 		encoderTest{[]int64{2147483648, 2147483647}, zero, errRange(typ, "[2147483648 2147483647]")},
 		encoderTest{[]int64{2147483647, 2147483648}, zero, errRange(typ, "[2147483647 2147483648]")},
 		encoderTest{[]int64{2147483648, 2147483648}, zero, errRange(typ, "[2147483648 2147483648]")},
@@ -3694,3 +3694,16 @@ func TestStringToNULL(t *testing.T) {
 		return NewCodec(dataPtr, NULL).SetString(input.(string))
 	})
 }
+
+func TestIsValidType(t *testing.T) {
+	for _, typ := range []ADEType{UI01, SI64, UR32, FC32, USTR, Cnct, NULL, CONT} {
+		if !IsValidType(typ) {
+			t.Errorf("IsValidType(%s): got false, want true", typ)
+		}
+	}
+	for _, typ := range []ADEType{"", "JUNK", "ui32", "CONT "} {
+		if IsValidType(typ) {
+			t.Errorf("IsValidType(%q): got true, want false", typ)
+		}
+	}
+}
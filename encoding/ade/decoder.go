@@ -0,0 +1,324 @@
+package ade
+
+// Streaming decoder for binary AtomContainers.
+//
+// The Decoder reads one atom header at a time from an io.Reader and reports it
+// as a Token, without building an Atom tree. This allows very large containers
+// to be processed in constant memory: only the data of the current atom and
+// the list of currently open containers are held.
+//
+// A binary AtomContainer is a depth-first serialization of the atom tree, so
+// the token stream looks like this for a container with 2 leaf atoms:
+//
+//     StartContainer ROOT
+//     LeafAtom       BVER
+//     LeafAtom       BTIM
+//     EndContainer   ROOT
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// TokenKind identifies the kind of event returned by Decoder.Token.
+type TokenKind int
+
+const (
+	// StartContainer is returned for the header of a CONT atom. Tokens for its
+	// children follow, up to the matching EndContainer.
+	StartContainer TokenKind = iota + 1
+
+	// LeafAtom is returned for every non-container atom. Its data is
+	// available from the token Value.
+	LeafAtom
+
+	// EndContainer is returned after the last child of a container has been
+	// read. Its fields describe the container that is ending.
+	EndContainer
+)
+
+// String returns the name of the token kind.
+func (k TokenKind) String() string {
+	switch k {
+	case StartContainer:
+		return "StartContainer"
+	case LeafAtom:
+		return "LeafAtom"
+	case EndContainer:
+		return "EndContainer"
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// Token describes a single event in a binary AtomContainer stream.
+type Token struct {
+	Kind   TokenKind
+	Name   string        // printable atom name, same as Atom.Name()
+	Type   codec.ADEType // ADE type of the atom
+	Size   uint32        // encoded size of the atom including its header and children
+	Offset int64         // byte offset of the atom header within the stream
+	Depth  int           // number of enclosing containers
+
+	// Value provides access to the atom data of LeafAtom tokens.
+	// It is nil for StartContainer and EndContainer tokens.
+	Value *codec.Codec
+
+	name [4]byte // raw atom name
+	data []byte  // raw atom data
+}
+
+// atom returns a new Atom object with the name, type and data of the token.
+// Children are not included, the caller must add them.
+func (tk Token) atom() *Atom {
+	a := &Atom{
+		name: append([]byte(nil), tk.name[:]...),
+		typ:  tk.Type,
+		data: tk.data,
+	}
+	a.Value = codec.NewCodec(&a.data, a.typ)
+	return a
+}
+
+// DecodeOptions sets limits on the binary input accepted by a Decoder, for
+// reading containers from untrusted sources. A limit with value 0 is not
+// enforced.
+//
+// Limits are checked against the sizes declared in atom headers, before the
+// data they describe is read, so that input which violates a limit fails
+// immediately.
+type DecodeOptions struct {
+	MaxTotalBytes int64  // maximum number of bytes in the input stream
+	MaxDataSize   uint32 // maximum size of the data of a single atom, excluding header
+	MaxDepth      int    // maximum nesting of containers. A top-level container has depth 1.
+	MaxAtoms      int    // maximum number of atoms in the input stream
+}
+
+// DefaultDecodeOptions are the limits used when binary input is read without
+// specifying DecodeOptions, including by Atom.UnmarshalBinary.
+//
+// By default no limits are enforced. Regardless of limits, memory for atom
+// data is allocated only as the data is read, so a corrupt size in an atom
+// header cannot cause a large allocation.
+var DefaultDecodeOptions = DecodeOptions{}
+
+// getDecodeOptions returns the first of the given options, or
+// DefaultDecodeOptions if none are given.
+func getDecodeOptions(opts []DecodeOptions) DecodeOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return DefaultDecodeOptions
+}
+
+// A Decoder reads and decodes binary AtomContainers from an input stream.
+type Decoder struct {
+	r          io.Reader
+	opts       DecodeOptions    // limits on input
+	offset     int64            // count of bytes read from r
+	atoms      int              // count of atom headers read from r
+	containers containerStack   // containers which have not ended yet
+	header     [headerSize]byte // buffer for reading atom headers
+	pending    []Token          // EndContainer tokens waiting to be returned
+	err        error            // sticky error, returned from every later call
+
+	// In header-only mode, atom data is skipped by seeking instead of being
+	// read, and LeafAtom tokens have no Value.
+	seeker    io.Seeker // seeks past atom data in header-only mode, otherwise nil
+	inputSize int64     // size of the input in header-only mode
+}
+
+// NewDecoder returns a new Decoder that reads binary AtomContainers from r.
+// If DecodeOptions are given, the Decoder enforces their limits on the input.
+// Otherwise DefaultDecodeOptions are used.
+//
+// The Decoder does its own buffering only for atom data, so wrapping r in a
+// bufio.Reader is recommended if r performs a system call for every read.
+func NewDecoder(r io.Reader, opts ...DecodeOptions) *Decoder {
+	return &Decoder{r: r, opts: getDecodeOptions(opts)}
+}
+
+// newHeaderDecoder returns a Decoder that reads only the atom headers from r,
+// seeking past the data of leaf atoms. The size of the input must be given, so
+// that truncated input is detected without reading the data.
+func newHeaderDecoder(r io.ReadSeeker, size int64, opts ...DecodeOptions) *Decoder {
+	d := NewDecoder(r, opts...)
+	d.seeker, d.inputSize = r, size
+	return d
+}
+
+// InputOffset returns the number of bytes read from the input stream so far.
+func (d *Decoder) InputOffset() int64 {
+	return d.offset
+}
+
+// Token returns the next token in the input stream.
+//
+// At the end of the input stream, Token returns a zero Token and io.EOF. If
+// the stream ends while a container is still open, or the stream does not
+// contain valid binary atom data, a different non-nil error is returned.
+// Once an error has been returned, every following call returns it again.
+func (d *Decoder) Token() (tk Token, err error) {
+	if len(d.pending) > 0 {
+		tk, d.pending = d.pending[0], d.pending[1:]
+		return tk, nil
+	}
+	if d.err != nil {
+		return tk, d.err
+	}
+	tk, err = d.readToken()
+	if err != nil {
+		d.err = err
+		return Token{}, err
+	}
+	return tk, nil
+}
+
+// readToken reads the next atom header and data from the input stream, and
+// queues EndContainer tokens for any containers that end with this atom.
+func (d *Decoder) readToken() (tk Token, err error) {
+	// read next atom header
+	tk.Offset = d.offset
+	h, err := readAtomHeader(d.r, &d.header, &d.offset)
+	switch {
+	case err == io.EOF && len(d.containers) == 0:
+		return tk, io.EOF
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		return tk, d.errTruncated(tk)
+	case err != nil:
+		return tk, err
+	}
+
+	// print header including size, for debugging structure problems
+	Log.Printf("atom header %6d  %10s:%s \n", h.Size, h.printableName(), h.Type)
+
+	tk.Name = h.printableName()
+	tk.Type = codec.ADEType(h.Type[:])
+	tk.Size = h.Size
+	tk.Depth = len(d.containers)
+	tk.name = h.Name
+	if !codec.IsValidType(tk.Type) {
+		return tk, d.errorf(ErrUnknownType, tk, "atom %s at byte %d has unknown ADE type %q",
+			tk.Name, tk.Offset, tk.Type)
+	}
+	if h.Size < headerSize {
+		return tk, d.errorf(ErrSizeMismatch, tk, "atom %s:%s at byte %d has invalid size %d, less than header size %d",
+			tk.Name, tk.Type, tk.Offset, h.Size, headerSize)
+	}
+	if isShortData(tk.Type, h.Size-headerSize) {
+		return tk, d.errorf(ErrSizeMismatch, tk, "atom %s:%s at byte %d has data size %d, less than the size %d of its type",
+			tk.Name, tk.Type, tk.Offset, h.Size-headerSize, codec.DataSize(tk.Type))
+	}
+
+	// atom must fit within its parent container
+	end := tk.Offset + int64(h.Size)
+	if parent, ok := d.containers.Peek(); ok && end > parent.end {
+		return tk, d.errorf(ErrContainerOverrun, tk, "atom %s:%s at byte %d with size %d overruns container %s, which ends at byte %d",
+			tk.Name, tk.Type, tk.Offset, h.Size, parent.start.Name, parent.end)
+	}
+	if err = d.checkLimits(tk, h, end); err != nil {
+		return tk, err
+	}
+
+	if h.isContainer() {
+		tk.Kind = StartContainer
+		d.containers.Push(cont{tk, end})
+	} else {
+		tk.Kind = LeafAtom
+		if d.seeker != nil {
+			err = d.skipAtomData(h.Size - headerSize)
+		} else {
+			tk.data, err = readAtomData(d.r, h.Size-headerSize, &d.offset)
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = d.errTruncated(tk)
+			}
+			return tk, err
+		}
+		if d.seeker == nil {
+			tk.data = truncateDataToSize(tk.Type, tk.data)
+			tk.Value = codec.NewCodec(&tk.data, tk.Type)
+		}
+	}
+
+	// queue end tokens for fully read containers
+	closed, err := d.containers.PopCompleted(d.offset)
+	for _, c := range closed {
+		end := c.start
+		end.Kind = EndContainer
+		d.pending = append(d.pending, end)
+	}
+	if err != nil {
+		return tk, d.errorf(ErrSizeMismatch, tk, "%s", err)
+	}
+	return tk, nil
+}
+
+// skipAtomData seeks past the given number of bytes of atom data, in
+// header-only mode.
+func (d *Decoder) skipAtomData(length uint32) error {
+	if d.offset+int64(length) > d.inputSize {
+		d.offset = d.inputSize
+		return io.ErrUnexpectedEOF
+	}
+	if _, err := d.seeker.Seek(int64(length), io.SeekCurrent); err != nil {
+		return err
+	}
+	d.offset += int64(length)
+	return nil
+}
+
+// checkLimits returns an error if the atom described by the header exceeds any
+// of the limits set in the decoder options.
+func (d *Decoder) checkLimits(tk Token, h atomHeader, end int64) error {
+	d.atoms++
+	var o = d.opts
+	switch {
+	case o.MaxAtoms > 0 && d.atoms > o.MaxAtoms:
+		return d.errorf(ErrLimitExceeded, tk, "atom %s:%s at byte %d exceeds limit of %d atoms",
+			tk.Name, tk.Type, tk.Offset, o.MaxAtoms)
+	case o.MaxTotalBytes > 0 && end > o.MaxTotalBytes:
+		return d.errorf(ErrLimitExceeded, tk, "atom %s:%s at byte %d with size %d exceeds limit of %d bytes of input",
+			tk.Name, tk.Type, tk.Offset, h.Size, o.MaxTotalBytes)
+	case o.MaxDepth > 0 && h.isContainer() && len(d.containers) >= o.MaxDepth:
+		return d.errorf(ErrLimitExceeded, tk, "container %s at byte %d exceeds limit of %d nested containers",
+			tk.Name, tk.Offset, o.MaxDepth)
+	case o.MaxDataSize > 0 && !h.isContainer() && h.Size-headerSize > o.MaxDataSize:
+		return d.errorf(ErrLimitExceeded, tk, "atom %s:%s at byte %d has data size %d, exceeding limit of %d bytes",
+			tk.Name, tk.Type, tk.Offset, h.Size-headerSize, o.MaxDataSize)
+	}
+	return nil
+}
+
+// errTruncated describes an input stream that ends before the atom described
+// by the token is complete.
+func (d *Decoder) errTruncated(tk Token) error {
+	if c, ok := d.containers.Peek(); ok {
+		return d.errorf(ErrTruncated, tk, "unexpected end of input at byte %d, container %s should end at byte %d",
+			d.offset, c.start.Name, c.end)
+	}
+	return d.errorf(ErrTruncated, tk, "unexpected end of input at byte %d, in atom starting at byte %d",
+		d.offset, tk.Offset)
+}
+
+// errorf returns a DecodeError for the atom described by the token, located
+// within the containers which are currently open.
+func (d *Decoder) errorf(cause error, tk Token, format string, args ...interface{}) error {
+	names := make([]string, 0, len(d.containers))
+	for _, c := range d.containers {
+		if c.start.Offset != tk.Offset { // exclude the offending atom itself
+			names = append(names, c.start.Name)
+		}
+	}
+	return &DecodeError{
+		Err:    cause,
+		Msg:    fmt.Sprintf(format, args...),
+		Path:   containerPath(names),
+		Offset: tk.Offset,
+		Name:   tk.Name,
+		Type:   tk.Type,
+		Size:   tk.Size,
+	}
+}
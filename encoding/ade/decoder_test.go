@@ -0,0 +1,245 @@
+package ade
+
+//
+// Verify that the Decoder token stream describes the binary test files, and
+// that truncated or malformed input is reported as an error.
+//
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Expected token stream for TestAtom1Text from path_test.go.
+var decoderWantTokens = []Token{
+	{Kind: StartContainer, Name: "ROOT", Type: "CONT", Size: 192, Offset: 0, Depth: 0},
+	{Kind: StartContainer, Name: "0001", Type: "CONT", Size: 60, Offset: 12, Depth: 1},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 24, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 40, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 56, Depth: 2},
+	{Kind: EndContainer, Name: "0001", Type: "CONT", Size: 60, Offset: 12, Depth: 1},
+	{Kind: StartContainer, Name: "0002", Type: "CONT", Size: 60, Offset: 72, Depth: 1},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 84, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 100, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 116, Depth: 2},
+	{Kind: EndContainer, Name: "0002", Type: "CONT", Size: 60, Offset: 72, Depth: 1},
+	{Kind: StartContainer, Name: "0003", Type: "CONT", Size: 60, Offset: 132, Depth: 1},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 144, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 160, Depth: 2},
+	{Kind: LeafAtom, Name: "LEAF", Type: "UI32", Size: 16, Offset: 176, Depth: 2},
+	{Kind: EndContainer, Name: "0003", Type: "CONT", Size: 60, Offset: 132, Depth: 1},
+	{Kind: EndContainer, Name: "ROOT", Type: "CONT", Size: 192, Offset: 0, Depth: 0},
+}
+
+func TestDecoderToken(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestDecoderToken: unable to create test input: %s", err)
+	}
+
+	d := NewDecoder(bytes.NewReader(buf))
+	var leafValues []uint64
+	for i, want := range decoderWantTokens {
+		got, err := d.Token()
+		if err != nil {
+			t.Fatalf("TestDecoderToken: token %d: expect no error, got %s", i, err)
+		}
+		if got.Kind != want.Kind || got.Name != want.Name || got.Type != want.Type ||
+			got.Size != want.Size || got.Offset != want.Offset || got.Depth != want.Depth {
+			t.Errorf("TestDecoderToken: token %d: got {%s %s %s %d %d %d}, want {%s %s %s %d %d %d}", i,
+				got.Kind, got.Name, got.Type, got.Size, got.Offset, got.Depth,
+				want.Kind, want.Name, want.Type, want.Size, want.Offset, want.Depth)
+		}
+		if got.Kind == LeafAtom {
+			v, err := got.Value.Uint()
+			if err != nil {
+				t.Errorf("TestDecoderToken: token %d: unable to read value: %s", i, err)
+			}
+			leafValues = append(leafValues, v)
+		} else if got.Value != nil {
+			t.Errorf("TestDecoderToken: token %d: %s token should have nil Value", i, got.Kind)
+		}
+	}
+	for i, v := range leafValues {
+		if v != uint64(i+1) {
+			t.Errorf("TestDecoderToken: leaf %d: got value %d, want %d", i, v, i+1)
+		}
+	}
+
+	// stream is complete
+	if _, err := d.Token(); err != io.EOF {
+		t.Errorf("TestDecoderToken: at end of stream, got err %v, want io.EOF", err)
+	}
+	if d.InputOffset() != int64(len(buf)) {
+		t.Errorf("TestDecoderToken: got InputOffset %d, want %d", d.InputOffset(), len(buf))
+	}
+}
+
+// Verify that the decoder reads every binary test file, and that the token
+// stream is balanced.
+func TestDecoderTestFiles(t *testing.T) {
+	for _, test := range Tests {
+		d := NewDecoder(bytes.NewReader(test.binBytes))
+		var depth, count int
+		for {
+			tk, err := d.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("Decoder(%s): expect no error, got %s", test.Name(), err)
+				break
+			}
+			if tk.Depth != depth && tk.Kind != EndContainer || tk.Depth != depth-1 && tk.Kind == EndContainer {
+				t.Errorf("Decoder(%s): token %s:%s has depth %d, want %d", test.Name(), tk.Name, tk.Type, tk.Depth, depth)
+			}
+			switch tk.Kind {
+			case StartContainer:
+				depth++
+			case EndContainer:
+				depth--
+			}
+			if tk.Kind != EndContainer {
+				count++
+			}
+		}
+		if depth != 0 {
+			t.Errorf("Decoder(%s): unbalanced token stream, depth %d at end", test.Name(), depth)
+		}
+		if want := len(test.atom.Descendants()); count != want {
+			t.Errorf("Decoder(%s): got %d atoms, want %d", test.Name(), count, want)
+		}
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestDecoderInvalid: unable to create test input: %s", err)
+	}
+	undersized := append([]byte{}, buf...)
+	undersized[15] = 4 // size of container 0001 is less than header size
+	overrun := append([]byte{}, buf...)
+	overrun[15] = 250 // container 0001 extends past the end of ROOT
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr string
+	}{
+		{"truncated header", buf[:30], "unexpected end of input at byte 24"},
+		{"truncated data", buf[:38], "unexpected end of input at byte 38"},
+		{"missing children", buf[:12], "unexpected end of input at byte 12, container ROOT should end at byte 192"},
+		{"undersized atom", undersized, "has invalid size 4"},
+		{"container overrun", overrun, "overruns container ROOT"},
+		{"unknown type", []byte("\x00\x00\x00\x0cNAMEJUNK"), "unknown ADE type"},
+		{"short fixed size data", shortDataInput, "BVER:UI32 at byte 12 has data size 2, less than the size 4 of its type"},
+	}
+	for _, test := range tests {
+		_, err := ReadAtomsFromBinary(bytes.NewReader(test.input))
+		if err == nil {
+			t.Errorf("ReadAtomsFromBinary(%s): got err <nil>, want err containing %q", test.name, test.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("ReadAtomsFromBinary(%s): got err {%s}, want err containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+// shortDataInput is ROOT:CONT holding BVER:UI32 with header size 14, so it
+// has only 2 of the 4 bytes of data that its type needs.
+var shortDataInput = []byte("\x00\x00\x00\x1aROOTCONT\x00\x00\x00\x0eBVERUI32\x00\x01")
+
+// Verify that an atom with less data than its fixed size type needs is
+// rejected by each way of decoding, rather than causing a panic.
+func TestDecoderShortData(t *testing.T) {
+	if _, err := ReadAtomsFromBinary(bytes.NewReader(shortDataInput)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("ReadAtomsFromBinary: got err {%v}, want cause {%s}", err, ErrSizeMismatch)
+	}
+	if _, err := ReadAtom(bytes.NewReader(shortDataInput)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("ReadAtom: got err {%v}, want cause {%s}", err, ErrSizeMismatch)
+	}
+	d := NewDecoder(bytes.NewReader(shortDataInput))
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Decoder.Token: got err {%v}, want cause {%s}", err, ErrSizeMismatch)
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestDecodeOptions: unable to create test input: %s", err)
+	}
+
+	tests := []struct {
+		opts    DecodeOptions
+		wantErr string
+	}{
+		{DecodeOptions{}, ""},
+		{DecodeOptions{MaxTotalBytes: 192, MaxDataSize: 4, MaxDepth: 2, MaxAtoms: 13}, ""},
+		{DecodeOptions{MaxTotalBytes: 191}, "ROOT:CONT at byte 0 with size 192 exceeds limit of 191 bytes"},
+		{DecodeOptions{MaxDataSize: 3}, "LEAF:UI32 at byte 24 has data size 4, exceeding limit of 3 bytes"},
+		{DecodeOptions{MaxDepth: 1}, "container 0001 at byte 12 exceeds limit of 1 nested containers"},
+		{DecodeOptions{MaxAtoms: 12}, "LEAF:UI32 at byte 176 exceeds limit of 12 atoms"},
+	}
+	for _, test := range tests {
+		_, err := ReadAtomsFromBinary(bytes.NewReader(buf), test.opts)
+		switch {
+		case err == nil && test.wantErr != "":
+			t.Errorf("ReadAtomsFromBinary(%+v): got err <nil>, want err containing %q", test.opts, test.wantErr)
+		case err != nil && test.wantErr == "":
+			t.Errorf("ReadAtomsFromBinary(%+v): expect no error, got %s", test.opts, err)
+		case err != nil && !strings.Contains(err.Error(), test.wantErr):
+			t.Errorf("ReadAtomsFromBinary(%+v): got err {%s}, want err containing %q", test.opts, err, test.wantErr)
+		}
+	}
+}
+
+// Verify that a huge size in an atom header does not cause a huge allocation
+// when the input is short.
+func TestDecoderHugeSize(t *testing.T) {
+	input := []byte("\x7f\xff\xff\xffDATACSTRhello\x00")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadAtomsFromBinary(bytes.NewReader(input))
+	runtime.ReadMemStats(&after)
+
+	if err == nil || !strings.Contains(err.Error(), "unexpected end of input at byte 18") {
+		t.Errorf("ReadAtomsFromBinary: got err %v, want unexpected end of input at byte 18", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("ReadAtomsFromBinary: allocated %d bytes for %d bytes of input", allocated, len(input))
+	}
+
+	// limits fail before any data is read
+	_, err = ReadAtomsFromBinary(bytes.NewReader(input), DecodeOptions{MaxDataSize: 1 << 20})
+	if err == nil || !strings.Contains(err.Error(), "has data size 2147483635, exceeding limit of 1048576 bytes") {
+		t.Errorf("ReadAtomsFromBinary: got err %v, want data size limit error", err)
+	}
+}
+
+func BenchmarkDecoderToken(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		for _, t := range Tests {
+			d := NewDecoder(bytes.NewReader(t.binBytes))
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					panic(err)
+				}
+			}
+		}
+	}
+	b.ReportAllocs()
+}
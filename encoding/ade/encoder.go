@@ -0,0 +1,227 @@
+package ade
+
+// Streaming encoder for binary AtomContainers.
+//
+// Atom.BinaryWrite needs the complete atom tree before it can write anything,
+// because the header of every CONT atom holds the encoded size of all of its
+// descendants. The Encoder instead writes atoms as they are produced, and
+// fills in the size of each container when it ends:
+//
+//   * If the output is seekable (an io.WriteSeeker such as *os.File), the
+//     container header is written with a placeholder size, which is
+//     overwritten when the container ends.
+//   * Otherwise, output is buffered in memory from the start of the
+//     outermost open container until it ends. Atoms written outside of any
+//     container are not buffered.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// An Encoder writes binary AtomContainers to an output stream.
+type Encoder struct {
+	w      io.Writer
+	ws     io.WriteSeeker // same as w if output is seekable, else nil
+	buf    bytes.Buffer   // output of open containers, if w is not seekable
+	offset int64          // byte position of next atom, in w if seekable or else in buf
+	open   []openCont     // containers which have been started but not ended
+	err    error          // sticky error, returned from every later call
+}
+
+// A container which has been started by the Encoder but not yet ended
+type openCont struct {
+	name  string
+	start int64 // byte position of the container header
+}
+
+// NewEncoder returns a new Encoder that writes binary AtomContainers to w.
+//
+// If w is an io.WriteSeeker that supports seeking, container sizes are
+// written directly into w as each container ends. Otherwise, the contents of
+// each top-level container are buffered until the container ends.
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{w: w}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		// pipes and terminals implement Seek but fail when it is called
+		if pos, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			e.ws = ws
+			e.offset = pos
+		}
+	}
+	return e
+}
+
+// Depth returns the number of containers which have been started but not yet
+// ended.
+func (e *Encoder) Depth() int {
+	return len(e.open)
+}
+
+// StartContainer writes the header of a CONT atom with the given name.
+// Atoms written after this are children of the container, until the matching
+// call to EndContainer.
+func (e *Encoder) StartContainer(name string) error {
+	if e.err != nil {
+		return e.err
+	}
+	h, err := newAtomHeader(name, codec.CONT, headerSize)
+	if err != nil {
+		return err // invalid argument, not an output error
+	}
+	if e.ws == nil && len(e.open) == 0 {
+		e.offset = 0 // start of buffer
+	}
+	e.open = append(e.open, openCont{name, e.offset})
+	return e.write(h.bytes())
+}
+
+// WriteAtom writes a single non-container atom with the given name, type and
+// value. The value may be any type accepted by Atom.SetValue.
+func (e *Encoder) WriteAtom(name string, typ codec.ADEType, value interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+	if typ == codec.CONT {
+		return fmt.Errorf("cannot write atom %s:%s, use StartContainer for CONT atoms", name, typ)
+	}
+	a, err := NewAtom(name, typ, value)
+	if err != nil {
+		return fmt.Errorf("cannot write atom %s:%s: %s", name, typ, err)
+	}
+	return e.Encode(a)
+}
+
+// Encode writes a complete atom, including all of its children, in binary
+// format.
+func (e *Encoder) Encode(a *Atom) error {
+	if e.err != nil {
+		return e.err
+	}
+	cw := countingWriter{w: e.output()}
+	err := a.BinaryWrite(&cw)
+	e.offset += cw.n
+	return e.setErr(err)
+}
+
+// EndContainer ends the most recently started container. Its size is written
+// into the container header.
+func (e *Encoder) EndContainer() error {
+	if e.err != nil {
+		return e.err
+	}
+	if len(e.open) == 0 {
+		return fmt.Errorf("EndContainer called with no open container")
+	}
+	c := e.open[len(e.open)-1]
+	e.open = e.open[:len(e.open)-1]
+
+	size := e.offset - c.start
+	if size > math.MaxUint32 {
+		return e.setErr(fmt.Errorf("container %s has size %d, which exceeds the maximum atom size %d",
+			c.name, size, uint32(math.MaxUint32)))
+	}
+
+	// Buffered output: patch the size in the buffer, flush when the outermost
+	// container ends.
+	if e.ws == nil {
+		binary.BigEndian.PutUint32(e.buf.Bytes()[c.start:], uint32(size))
+		if len(e.open) > 0 {
+			return nil
+		}
+		_, err := e.buf.WriteTo(e.w)
+		e.buf.Reset()
+		return e.setErr(err)
+	}
+
+	// Seekable output: overwrite the size in the header, and return to the end.
+	var sizeBytes [4]byte
+	binary.BigEndian.PutUint32(sizeBytes[:], uint32(size))
+	if _, err := e.ws.Seek(c.start, io.SeekStart); err != nil {
+		return e.setErr(err)
+	}
+	if _, err := e.ws.Write(sizeBytes[:]); err != nil {
+		return e.setErr(err)
+	}
+	_, err := e.ws.Seek(e.offset, io.SeekStart)
+	return e.setErr(err)
+}
+
+// Close checks that every started container has been ended.
+// It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if len(e.open) > 0 {
+		return fmt.Errorf("cannot close encoder, container %s has not ended", e.open[len(e.open)-1].name)
+	}
+	return nil
+}
+
+// output returns the buffer if a container is open and output is not
+// seekable, or else the output stream.
+func (e *Encoder) output() io.Writer {
+	if e.ws == nil && len(e.open) > 0 {
+		return &e.buf
+	}
+	return e.w
+}
+
+// write sends bytes to the output, and advances the output offset.
+func (e *Encoder) write(p []byte) error {
+	n, err := e.output().Write(p)
+	e.offset += int64(n)
+	return e.setErr(err)
+}
+
+// setErr records the first output error, so that it is returned from every
+// later call.
+func (e *Encoder) setErr(err error) error {
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return err
+}
+
+// newAtomHeader returns the binary header of an atom with the given name,
+// type and encoded size.
+func newAtomHeader(name string, typ codec.ADEType, size uint32) (h atomHeader, err error) {
+	var buf []byte
+	if err = codec.StringToFC32Bytes(&buf, name); err != nil {
+		return
+	}
+	copy(h.Name[:], buf)
+	if err = codec.StringToFC32Bytes(&buf, string(typ)); err != nil {
+		return
+	}
+	copy(h.Type[:], buf)
+	h.Size = size
+	return
+}
+
+// bytes returns the binary encoding of the atom header.
+func (h atomHeader) bytes() []byte {
+	buf := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(buf[0:4], h.Size)
+	copy(buf[4:8], h.Name[:])
+	copy(buf[8:12], h.Type[:])
+	return buf
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
@@ -0,0 +1,158 @@
+package ade
+
+//
+// Verify that the Encoder reproduces the output of MarshalBinary when atoms are
+// written one at a time, for both seekable and non-seekable output.
+//
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// reencode copies the binary container in buf to the Encoder one token at a
+// time.
+func reencode(buf []byte, e *Encoder) error {
+	d := NewDecoder(bytes.NewReader(buf))
+	for {
+		tk, err := d.Token()
+		if err == io.EOF {
+			return e.Close()
+		}
+		if err != nil {
+			return err
+		}
+		switch tk.Kind {
+		case StartContainer:
+			err = e.StartContainer(tk.Name)
+		case EndContainer:
+			err = e.EndContainer()
+		case LeafAtom:
+			err = e.Encode(tk.atom())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestEncoderBuffered(t *testing.T) {
+	for _, test := range Tests {
+		want, err := test.atom.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Encoder(%s): unable to create test input: %s", test.Name(), err)
+		}
+		var got bytes.Buffer
+		if err := reencode(want, NewEncoder(&got)); err != nil {
+			t.Errorf("Encoder(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("Encoder(%s): output differs from MarshalBinary", test.Name())
+		}
+	}
+}
+
+func TestEncoderSeekable(t *testing.T) {
+	f, err := ioutil.TempFile("", "encoder_test")
+	if err != nil {
+		t.Fatalf("TestEncoderSeekable: unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	for _, test := range Tests {
+		want, err := test.atom.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Encoder(%s): unable to create test input: %s", test.Name(), err)
+		}
+		f.Truncate(0)
+		f.Seek(0, io.SeekStart)
+		e := NewEncoder(f)
+		if e.ws == nil {
+			t.Fatalf("Encoder(%s): expected *os.File output to be seekable", test.Name())
+		}
+		if err := reencode(want, e); err != nil {
+			t.Errorf("Encoder(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		f.Seek(0, io.SeekStart)
+		got, _ := ioutil.ReadAll(f)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Encoder(%s): output differs from MarshalBinary", test.Name())
+		}
+	}
+}
+
+func TestEncoderWriteAtom(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WriteAtom("VERS", codec.UI32, uint32(1)) // top-level, not buffered
+	e.StartContainer("ROOT")
+	e.WriteAtom("NAME", codec.CSTR, "test")
+	e.StartContainer("EMPT")
+	e.EndContainer()
+	e.WriteAtom("BVER", codec.UI32, uint32(6))
+	if buf.Len() != 16 {
+		t.Errorf("Encoder: got %d bytes written before container end, want 16", buf.Len())
+	}
+	e.EndContainer()
+	if err := e.Close(); err != nil {
+		t.Fatalf("Encoder: expect no error, got %s", err)
+	}
+
+	atoms, err := ReadAtomsFromBinary(&buf)
+	if err != nil {
+		t.Fatalf("Encoder: unable to read output: %s", err)
+	}
+	var got []string
+	for _, a := range atoms {
+		for _, d := range a.Descendants() {
+			got = append(got, d.String())
+		}
+	}
+	want := []string{`VERS:UI32:1`, `ROOT:CONT:`, `NAME:CSTR:"test"`, `EMPT:CONT:`, `BVER:UI32:6`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Encoder: got atoms\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestEncoderErrors(t *testing.T) {
+	e := NewEncoder(new(bytes.Buffer))
+	if err := e.EndContainer(); err == nil {
+		t.Errorf("Encoder.EndContainer: expect error with no open container")
+	}
+	if err := e.WriteAtom("ROOT", codec.CONT, nil); err == nil {
+		t.Errorf("Encoder.WriteAtom: expect error for CONT type")
+	}
+	if err := e.WriteAtom("BVER", codec.UI32, "text"); err == nil {
+		t.Errorf("Encoder.WriteAtom: expect error for value not matching type")
+	}
+	if err := e.StartContainer("TOO LONG"); err == nil {
+		t.Errorf("Encoder.StartContainer: expect error for invalid name")
+	}
+	e.StartContainer("ROOT")
+	if err := e.Close(); err == nil || !strings.Contains(err.Error(), "ROOT") {
+		t.Errorf("Encoder.Close: got err %v, want error for open container ROOT", err)
+	}
+
+	// output errors are sticky
+	e = NewEncoder(failWriter{})
+	if err := e.WriteAtom("BVER", codec.UI32, uint32(6)); err == nil {
+		t.Errorf("Encoder.WriteAtom: expect error from writer")
+	}
+	if err := e.StartContainer("ROOT"); err == nil {
+		t.Errorf("Encoder.StartContainer: expect earlier write error to be returned")
+	}
+}
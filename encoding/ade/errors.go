@@ -0,0 +1,92 @@
+package ade
+
+// Errors returned when AtomContainer input cannot be decoded.
+//
+// Decoding failures are reported as a *DecodeError, which gives the location
+// of the problem within the input and wraps one of the Err* values below as its
+// cause. Use errors.Is to test for a cause, and errors.As to get the location:
+//
+//     var de *ade.DecodeError
+//     if errors.As(err, &de) && errors.Is(err, ade.ErrTruncated) {
+//         fmt.Println("input ends early, in container", de.Path)
+//     }
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// Causes of a DecodeError.
+var (
+	// ErrTruncated means that the input ended before the end of an atom.
+	ErrTruncated = errors.New("unexpected end of input")
+
+	// ErrSizeMismatch means that the size in an atom header does not agree
+	// with the input, for example a size smaller than the atom header.
+	ErrSizeMismatch = errors.New("atom size mismatch")
+
+	// ErrUnknownType means that an atom has a type which is not an ADE type.
+	ErrUnknownType = errors.New("unknown ADE type")
+
+	// ErrContainerOverrun means that an atom extends past the end of the
+	// container that holds it.
+	ErrContainerOverrun = errors.New("atom overruns its container")
+
+	// ErrLimitExceeded means that the input exceeds a limit set in
+	// DecodeOptions.
+	ErrLimitExceeded = errors.New("decode limit exceeded")
+
+	// ErrSyntax means that text input is not valid ContainerText, or XML input
+	// is not valid containerxml.
+	ErrSyntax = errors.New("syntax error")
+)
+
+// A DecodeError describes invalid binary, text or XML AtomContainer input, and
+// where it was found.
+type DecodeError struct {
+	Err error  // cause of the error, one of the Err* values of this package
+	Msg string // description of the problem
+
+	// Path of the containers that enclose the offending atom, such as
+	// /ROOT/INTS/CUNS. It is "/" for a top-level atom.
+	Path string
+
+	// Location in binary input: byte offset of the header of the offending atom.
+	Offset int64
+
+	// Location in text or XML input: line and column numbers, starting from 1.
+	// Both are 0 for binary input.
+	Line   int
+	Column int
+
+	// Header of the offending atom, if it was read.
+	Name string
+	Type codec.ADEType
+	Size uint32 // binary input only
+}
+
+// Error returns the description of the problem along with its location.
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "parse error on line %d, column %d: ", e.Line, e.Column)
+	}
+	b.WriteString(e.Msg)
+	if len(e.Path) > 1 {
+		fmt.Fprintf(&b, " (path %s)", e.Path)
+	}
+	return b.String()
+}
+
+// Unwrap returns the cause of the error, for use by errors.Is.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// containerPath returns the path formed by the given container names.
+func containerPath(names []string) string {
+	return "/" + strings.Join(names, "/")
+}
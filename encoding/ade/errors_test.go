@@ -0,0 +1,112 @@
+package ade
+
+//
+// Verify that decoding failures are reported as *DecodeError values with the
+// correct cause and location.
+//
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+func TestDecodeErrorBinary(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestDecodeErrorBinary: unable to create test input: %s", err)
+	}
+	undersized := append([]byte{}, buf...)
+	undersized[87] = 4 // size of LEAF at byte 84 is less than header size
+	overrun := append([]byte{}, buf...)
+	overrun[15] = 250 // container 0001 extends past the end of ROOT
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  DecodeError
+	}{
+		{"truncated data", buf[:38], DecodeError{Err: ErrTruncated, Path: "/ROOT/0001", Offset: 24, Name: "LEAF", Type: codec.UI32, Size: 16}},
+		{"truncated header", buf[:12], DecodeError{Err: ErrTruncated, Path: "/ROOT", Offset: 12}},
+		{"undersized atom", undersized, DecodeError{Err: ErrSizeMismatch, Path: "/ROOT/0002", Offset: 84, Name: "LEAF", Type: codec.UI32, Size: 4}},
+		{"container overrun", overrun, DecodeError{Err: ErrContainerOverrun, Path: "/ROOT", Offset: 12, Name: "0001", Type: codec.CONT, Size: 250}},
+		{"unknown type", []byte("\x00\x00\x00\x0cNAMEJUNK"), DecodeError{Err: ErrUnknownType, Path: "/", Offset: 0, Name: "NAME", Type: "JUNK", Size: 12}},
+		{"limit", buf, DecodeError{Err: ErrLimitExceeded, Path: "/ROOT/0001", Offset: 24, Name: "LEAF", Type: codec.UI32, Size: 16}},
+	}
+	for _, test := range tests {
+		var a Atom
+		err := a.UnmarshalFromReader(bytes.NewReader(test.input), DecodeOptions{MaxDataSize: 2})
+		if test.want.Err != ErrLimitExceeded {
+			err = a.UnmarshalBinary(test.input)
+		}
+		if !errors.Is(err, test.want.Err) {
+			t.Errorf("DecodeError(%s): got err {%v}, want cause {%s}", test.name, err, test.want.Err)
+			continue
+		}
+		var got *DecodeError
+		if !errors.As(err, &got) {
+			t.Errorf("DecodeError(%s): got err type %T, want *DecodeError", test.name, err)
+			continue
+		}
+		if got.Path != test.want.Path || got.Offset != test.want.Offset || got.Name != test.want.Name ||
+			got.Type != test.want.Type || got.Size != test.want.Size || got.Line != 0 {
+			t.Errorf("DecodeError(%s): got location {%s %d %d %s:%s size %d}, want {%s %d %d %s:%s size %d}", test.name,
+				got.Path, got.Offset, got.Line, got.Name, got.Type, got.Size,
+				test.want.Path, test.want.Offset, test.want.Line, test.want.Name, test.want.Type, test.want.Size)
+		}
+	}
+}
+
+func TestDecodeErrorText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  DecodeError
+	}{
+		{"invalid number", "ROOT:CONT:\n\tINTS:CONT:\n\t\tCUNS:UI32:5\n\t\tCUNS:UI32:abc\n\tEND\nEND\n",
+			DecodeError{Err: ErrSyntax, Path: "/ROOT/INTS", Line: 4, Column: 14, Name: "CUNS", Type: codec.UI32}},
+		{"unknown type", "ROOT:CONT:\n  BVER:JUNK:6\nEND\n",
+			DecodeError{Err: ErrUnknownType, Path: "/ROOT", Line: 2, Column: 8, Name: "BVER"}},
+		{"value out of range", "ROOT:CONT:\n  BVER:UI08:256\nEND\n",
+			DecodeError{Err: ErrSyntax, Path: "/ROOT", Line: 2, Column: 13, Name: "BVER", Type: codec.UI08}},
+		{"unexpected END", "BVER:UI32:1\nEND\n",
+			DecodeError{Err: ErrSyntax, Path: "/", Line: 2, Column: 1}},
+	}
+	for _, test := range tests {
+		var a Atom
+		err := a.UnmarshalText([]byte(test.input))
+		if !errors.Is(err, test.want.Err) {
+			t.Errorf("DecodeError(%s): got err {%v}, want cause {%s}", test.name, err, test.want.Err)
+			continue
+		}
+		var got *DecodeError
+		if !errors.As(err, &got) {
+			t.Errorf("DecodeError(%s): got err type %T, want *DecodeError", test.name, err)
+			continue
+		}
+		if got.Path != test.want.Path || got.Line != test.want.Line || got.Column != test.want.Column ||
+			got.Name != test.want.Name || got.Type != test.want.Type {
+			t.Errorf("DecodeError(%s): got location {%s %d:%d %s:%s}, want {%s %d:%d %s:%s}", test.name,
+				got.Path, got.Line, got.Column, got.Name, got.Type,
+				test.want.Path, test.want.Line, test.want.Column, test.want.Name, test.want.Type)
+		}
+	}
+}
+
+func TestDecodeErrorString(t *testing.T) {
+	tests := []struct {
+		err  DecodeError
+		want string
+	}{
+		{DecodeError{Msg: "bad atom", Path: "/ROOT/INTS", Offset: 24}, "bad atom (path /ROOT/INTS)"},
+		{DecodeError{Msg: "bad atom", Path: "/"}, "bad atom"},
+		{DecodeError{Msg: "bad value", Path: "/ROOT", Line: 3, Column: 7}, "parse error on line 3, column 7: bad value (path /ROOT)"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("DecodeError.Error(): got %q, want %q", got, test.want)
+		}
+	}
+}
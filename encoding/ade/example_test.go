@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 
 	"github.com/gongfarmer/ntap/encoding/ade"
@@ -226,6 +227,63 @@ func ExampleReadAtomsFromBinary() {
 	// END
 }
 
+func ExampleDecoder() {
+	var atomText = []byte(`
+		TEST:CONT:
+			BVER:UI32:6
+			NAME:CSTR:"test"
+		END
+	`)
+	var a ade.Atom
+	a.UnmarshalText(atomText)
+	bin, _ := a.MarshalBinary()
+
+	// Read tokens from the binary stream, without building an Atom tree
+	d := ade.NewDecoder(bytes.NewReader(bin))
+	for {
+		tk, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		switch tk.Kind {
+		case ade.LeafAtom:
+			value, _ := tk.Value.String()
+			fmt.Printf("%*s%s at byte %d: %s\n", tk.Depth*2, "", tk.Name, tk.Offset, value)
+		default:
+			fmt.Printf("%*s%s %s\n", tk.Depth*2, "", tk.Kind, tk.Name)
+		}
+	}
+	// Output: StartContainer TEST
+	//   BVER at byte 12: 6
+	//   NAME at byte 28: test
+	// EndContainer TEST
+}
+
+func ExampleEncoder() {
+	var buf bytes.Buffer
+
+	// Write a container without building an Atom tree
+	e := ade.NewEncoder(&buf)
+	e.StartContainer("TEST")
+	e.WriteAtom("BVER", codec.UI32, uint32(6))
+	e.WriteAtom("NAME", codec.CSTR, "test")
+	e.EndContainer()
+	if err := e.Close(); err != nil {
+		panic(err)
+	}
+
+	var a ade.Atom
+	a.UnmarshalBinary(buf.Bytes())
+	text, _ := a.MarshalText()
+	fmt.Print(string(text))
+	// Output: TEST:CONT:
+	//	BVER:UI32:6
+	//	NAME:CSTR:"test"
+	// END
+}
+
 func ExampleAtomPath() {
 	var TEXT = `
 ROOT:CONT:
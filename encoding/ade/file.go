@@ -0,0 +1,141 @@
+package ade
+
+// Reading binary AtomContainer files, using memory mapping where supported.
+//
+// Open maps a file into memory and reads it with ReadAtomsFromBytes, so atom
+// data is neither copied nor decoded until it is used. LoadFiles reads many
+// files concurrently on a pool of worker goroutines.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// A File is an open binary AtomContainer file. On Linux the file is memory
+// mapped, so its atoms refer directly to the file contents without copying.
+//
+// The atoms of a File must not be used after it is closed.
+type File struct {
+	buf   []byte
+	atoms []*Atom
+}
+
+// Open opens the named binary AtomContainer file for reading. An error is
+// returned if the file does not hold valid binary container data.
+//
+// If DecodeOptions are given, their limits are enforced on the file contents.
+// Otherwise DefaultDecodeOptions are used.
+func Open(path string, opts ...DecodeOptions) (*File, error) {
+	buf, err := readMappedFile(path, getDecodeOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	atoms, err := ReadAtomsFromBytes(buf, opts...)
+	if err != nil {
+		unmapFile(buf)
+		return nil, err
+	}
+	return &File{buf: buf, atoms: atoms}, nil
+}
+
+// Atoms returns the top-level atoms of the file.
+//
+// Container children are decoded on first access, as described for
+// ReadAtomsFromBytes. Setting the value of an atom does not modify the file.
+func (f *File) Atoms() []*Atom {
+	return f.atoms
+}
+
+// Close releases the memory holding the file contents. Atoms of the file must
+// not be used afterwards.
+func (f *File) Close() error {
+	buf := f.buf
+	f.buf, f.atoms = nil, nil
+	return unmapFile(buf)
+}
+
+// readMappedFile returns the contents of the named file, mapped into memory
+// where supported. The caller must release the memory with unmapFile.
+func readMappedFile(path string, o DecodeOptions) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fstat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if o.MaxTotalBytes > 0 && fstat.Size() > o.MaxTotalBytes {
+		return nil, errFile(ErrLimitExceeded,
+			"invalid AtomContainer file, file size %d exceeds limit of %d bytes of input",
+			fstat.Size(), o.MaxTotalBytes)
+	}
+	buf, err := mapFile(f, fstat.Size())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	return buf, nil
+}
+
+// A FileResult holds the result of reading one file with LoadFiles.
+type FileResult struct {
+	Path  string  // path of the file
+	Atoms []*Atom // top-level atoms read from the file
+	Err   error   // error reading the file, nil on success
+}
+
+// LoadFiles reads the named files concurrently, using the given number of
+// worker goroutines, and returns one result per file in the same order as
+// the paths. If workers is less than 1, one worker per CPU is used.
+//
+// Each file may hold binary AtomContainers, or their hex representation
+// starting with "0x". A file which cannot be read has an error in its result,
+// and does not prevent the other files from being read.
+//
+// If DecodeOptions are given, their limits are enforced on each file.
+// Otherwise DefaultDecodeOptions are used.
+func LoadFiles(paths []string, workers int, opts ...DecodeOptions) []FileResult {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	var (
+		results = make([]FileResult, len(paths))
+		next    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				atoms, err := loadFile(paths[i], opts)
+				results[i] = FileResult{Path: paths[i], Atoms: atoms, Err: err}
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// loadFile reads all atoms from the named file, copying their data out of the
+// memory mapped file contents.
+func loadFile(path string, opts []DecodeOptions) (atoms []*Atom, err error) {
+	buf, err := readMappedFile(path, getDecodeOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer unmapFile(buf)
+
+	if bytes.HasPrefix(buf, []byte("0x")) {
+		return ReadAtomsFromHex(bytes.NewReader(buf), opts...)
+	}
+	return ReadAtomsFromBinary(bytes.NewReader(buf), opts...)
+}
@@ -0,0 +1,172 @@
+package ade
+
+//
+// Verify that files opened with memory mapping, and files loaded
+// concurrently, produce the same atoms as the test data.
+//
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	for _, test := range Tests {
+		f, err := Open(test.binPath)
+		if err != nil {
+			t.Errorf("Open(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if got, want := atomsText(f.Atoms()), atomsText([]*Atom{test.atom}); got != want {
+			t.Errorf("Open(%s): result differs from test atom", test.Name())
+		}
+		if err = f.Close(); err != nil {
+			t.Errorf("Open(%s): expect no error on Close, got %s", test.Name(), err)
+		}
+		if f.Atoms() != nil {
+			t.Errorf("Open(%s): expect no atoms after Close", test.Name())
+		}
+	}
+}
+
+func TestOpenModify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ade")
+	if err != nil {
+		t.Fatalf("TestOpenModify: unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	buf, _ := TestAtom1.MarshalBinary()
+	path := filepath.Join(dir, "test.bin")
+	if err = ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatalf("TestOpenModify: unable to write test file: %s", err)
+	}
+
+	// setting an atom value must not write to the file
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("TestOpenModify: expect no error, got %s", err)
+	}
+	leaf := f.Atoms()[0].Children()[1].Children()[0]
+	if err = leaf.SetValue(uint32(40)); err != nil {
+		t.Fatalf("TestOpenModify: unable to set value: %s", err)
+	}
+	f.Close()
+	got, _ := ioutil.ReadFile(path)
+	if string(got) != string(buf) {
+		t.Errorf("TestOpenModify: file contents changed after setting atom value")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ade")
+	if err != nil {
+		t.Fatalf("TestOpenInvalid: unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	buf, _ := TestAtom1.MarshalBinary()
+	truncated := filepath.Join(dir, "truncated.bin")
+	if err = ioutil.WriteFile(truncated, buf[:100], 0644); err != nil {
+		t.Fatalf("TestOpenInvalid: unable to write test file: %s", err)
+	}
+	shortData := writeCrashBinary(t, dir, "shortdata")
+
+	tests := []struct {
+		name string
+		path string
+		opts []DecodeOptions
+		want error
+	}{
+		{"truncated", truncated, nil, ErrTruncated},
+		{"short data", shortData, nil, ErrSizeMismatch},
+		{"limit", findTest(Tests, "BID0").binPath, []DecodeOptions{{MaxTotalBytes: 16}}, ErrLimitExceeded},
+		{"missing", "testdata/nonexistent.bin", nil, os.ErrNotExist},
+	}
+	for _, test := range tests {
+		f, err := Open(test.path, test.opts...)
+		if !errors.Is(err, test.want) {
+			t.Errorf("Open(%s): got err {%v}, want cause {%s}", test.name, err, test.want)
+		}
+		if f != nil {
+			t.Errorf("Open(%s): expect no File on error", test.name)
+		}
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	var paths []string
+	for _, test := range Tests {
+		paths = append(paths, test.binPath)
+	}
+	dir, err := ioutil.TempDir("", "ade")
+	if err != nil {
+		t.Fatalf("TestLoadFiles: unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	shortData := writeCrashBinary(t, dir, "shortdata")
+	paths = append(paths, "testdata/nonexistent.bin", "testdata/crash/oom1.hex", shortData)
+
+	for _, workers := range []int{0, 1, 3} {
+		results := LoadFiles(paths, workers)
+		if len(results) != len(paths) {
+			t.Fatalf("LoadFiles(%d workers): got %d results, want %d", workers, len(results), len(paths))
+		}
+		for i, test := range Tests {
+			r := results[i]
+			if r.Path != test.binPath || r.Err != nil {
+				t.Errorf("LoadFiles(%d workers): result %d is {%s %v}, want {%s <nil>}", workers, i, r.Path, r.Err, test.binPath)
+				continue
+			}
+			if atomsText(r.Atoms) != atomsText([]*Atom{test.atom}) {
+				t.Errorf("LoadFiles(%d workers): result %d differs from test atom %s", workers, i, test.Name())
+			}
+		}
+		if err := results[len(Tests)].Err; !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("LoadFiles(%d workers): got err {%v} for missing file, want cause {%s}", workers, err, os.ErrNotExist)
+		}
+		if err := results[len(Tests)+1].Err; !errors.Is(err, ErrUnknownType) {
+			t.Errorf("LoadFiles(%d workers): got err {%v} for hex file, want cause {%s}", workers, err, ErrUnknownType)
+		}
+		if err := results[len(Tests)+2].Err; !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("LoadFiles(%d workers): got err {%v} for short data binary file, want cause {%s}", workers, err, ErrSizeMismatch)
+		}
+	}
+}
+
+// writeCrashBinary decodes the named hex file from testdata/crash/ into a
+// binary file in dir, and returns its path. LoadFiles retries a hex file that
+// fails to decode with its bytes swapped, so the binary form is needed to see
+// the original error.
+func writeCrashBinary(t *testing.T, dir, name string) string {
+	buf, err := ioutil.ReadFile(filepath.Join("testdata", "crash", name+".hex"))
+	if err != nil {
+		t.Fatalf("writeCrashBinary(%s): unable to read hex file: %s", name, err)
+	}
+	buf, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(buf)), "0x"))
+	if err != nil {
+		t.Fatalf("writeCrashBinary(%s): unable to decode hex: %s", name, err)
+	}
+	path := filepath.Join(dir, name+".bin")
+	if err = ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatalf("writeCrashBinary(%s): unable to write test file: %s", name, err)
+	}
+	return path
+}
+
+func BenchmarkLoadFiles(b *testing.B) {
+	var paths []string
+	for _, test := range Tests {
+		paths = append(paths, test.binPath)
+	}
+	for n := 0; n < b.N; n++ {
+		for _, r := range LoadFiles(paths, 0) {
+			if r.Err != nil {
+				b.Fatal(r.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package ade
+
+// Random access into large binary AtomContainers.
+//
+// An Index is built by reading only the atom headers of the input, using the
+// size of each atom to seek past its data. It records where every atom is, so
+// that a single subtree can later be decoded without reading the rest of the
+// input.
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// An IndexEntry describes the location of one atom within binary input.
+type IndexEntry struct {
+	Name   string        // printable atom name
+	Type   codec.ADEType // ADE type of the atom
+	Offset int64         // byte offset of the atom header within the input
+	Size   uint32        // encoded size of the atom including its header and children
+	Depth  int           // number of enclosing containers
+	Parent int           // position of the enclosing container in Index.Entries, -1 for top-level atoms
+}
+
+// An Index records the location of every atom in binary AtomContainer input
+// accessed through an io.ReaderAt, so that individual atoms and their
+// children can be decoded without decoding the whole input.
+type Index struct {
+	// Entries describes every atom of the input, in the order the atoms are
+	// encoded. A container is followed by the entries of its descendants.
+	Entries []IndexEntry
+
+	r    io.ReaderAt
+	size int64
+	opts []DecodeOptions
+}
+
+// NewIndex reads the atom headers of the binary AtomContainers held in the
+// first size bytes of r, and returns an Index of the atoms found. Atom data
+// is not read. An error is returned if the headers do not describe valid
+// binary container data.
+//
+// If DecodeOptions are given, their limits are enforced on the input, and on
+// atoms later decoded through the Index. Otherwise DefaultDecodeOptions are
+// used.
+func NewIndex(r io.ReaderAt, size int64, opts ...DecodeOptions) (*Index, error) {
+	var (
+		ix      = &Index{r: r, size: size, opts: opts}
+		d       = newHeaderDecoder(io.NewSectionReader(r, 0, size), size, opts...)
+		parents = []int{-1} // positions of open containers, innermost last
+	)
+	for {
+		tk, err := d.Token()
+		if err == io.EOF {
+			return ix, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tk.Kind {
+		case EndContainer:
+			parents = parents[:len(parents)-1]
+			continue
+		case StartContainer, LeafAtom:
+			ix.Entries = append(ix.Entries, IndexEntry{
+				Name:   tk.Name,
+				Type:   tk.Type,
+				Offset: tk.Offset,
+				Size:   tk.Size,
+				Depth:  tk.Depth,
+				Parent: parents[len(parents)-1],
+			})
+		}
+		if tk.Kind == StartContainer {
+			parents = append(parents, len(ix.Entries)-1)
+		}
+	}
+}
+
+// Path returns the location path of the atom described by the entry, made
+// of the names of the atom and its enclosing containers, such as
+// "/ROOT/LIST/ITEM".
+func (ix *Index) Path(e IndexEntry) string {
+	names := make([]string, e.Depth+1)
+	for i := e.Depth; i >= 0; i-- {
+		names[i] = e.Name
+		if e.Parent >= 0 {
+			e = ix.Entries[e.Parent]
+		}
+	}
+	return containerPath(names)
+}
+
+// Lookup returns the entries of the atoms found at the given location path,
+// in the order they are encoded.
+//
+// The path must be absolute, made of atom names separated by "/", such as
+// "/ROOT/LIST/ITEM". A name may be "*" to match atoms of any name, or may be
+// given as 0x followed by 8 hex digits. Other path syntax is not supported;
+// see AtomsAtPath for that.
+func (ix *Index) Lookup(path string) (entries []IndexEntry) {
+	names, ok := splitNamePath(path)
+	if !ok {
+		return nil
+	}
+
+	// An atom matches if its name matches the path step at its depth, and
+	// its parent matched the previous step.
+	matched := make([]bool, len(ix.Entries))
+	for i, e := range ix.Entries {
+		if e.Depth >= len(names) || (e.Parent >= 0 && !matched[e.Parent]) {
+			continue
+		}
+		if !nameMatches(e.Name, names[e.Depth]) {
+			continue
+		}
+		matched[i] = true
+		if e.Depth == len(names)-1 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Atom reads and decodes the atom described by the entry, including all of
+// its children.
+func (ix *Index) Atom(e IndexEntry) (*Atom, error) {
+	return ReadAtom(io.NewSectionReader(ix.r, e.Offset, int64(e.Size)), ix.opts...)
+}
+
+// AtomsAtPath returns the atoms that match the path, searching each of the
+// top-level atoms of the input as AtomsAtPath does.
+//
+// If the path is a plain location path as accepted by Lookup, only the atoms
+// found are decoded. Otherwise the entries are used to decode only the atoms
+// that the path can reach, as GetAtomsFromReader does, and the path is
+// evaluated on them.
+func (ix *Index) AtomsAtPath(path string) (atoms []*Atom, err error) {
+	if _, ok := splitNamePath(path); ok {
+		for _, e := range ix.Lookup(path) {
+			a, err := ix.Atom(e)
+			if err != nil {
+				return nil, err
+			}
+			atoms = append(atoms, a)
+		}
+		return atoms, nil
+	}
+
+	ap, err := NewAtomPath(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := ix.prunedAtoms(pathPrefix(path))
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		found, err := ap.GetAtoms(root)
+		if err != nil {
+			return nil, err
+		}
+		atoms = append(atoms, found...)
+	}
+	return atoms, nil
+}
+
+// prunedAtoms returns the top-level atoms of the input, keeping only the atoms
+// within the given plain steps at the start of a path. Containers on the
+// steps are kept with just their children that the steps can reach, and the
+// atoms found at the last step are decoded in full.
+func (ix *Index) prunedAtoms(steps []string) (roots []*Atom, err error) {
+	kept := make([]*Atom, len(ix.Entries)) // containers kept on the steps
+	for i, e := range ix.Entries {
+		var parent *Atom
+		if e.Parent >= 0 {
+			if parent = kept[e.Parent]; parent == nil {
+				continue // not on the steps, or decoded with its container
+			}
+		}
+
+		var a *Atom
+		switch {
+		case e.Depth >= len(steps)-1 && (e.Depth >= len(steps) || nameMatches(e.Name, steps[e.Depth])):
+			// final step reached, keep the whole atom
+			if a, err = ix.Atom(e); err != nil {
+				return nil, err
+			}
+		case e.Type == codec.CONT && nameMatches(e.Name, steps[e.Depth]):
+			// on the path, keep the container and the children the path can reach
+			a = &Atom{name: make([]byte, 4), typ: codec.CONT}
+			a.Value = codec.NewCodec(&a.data, a.typ)
+			if _, err = ix.r.ReadAt(a.name, e.Offset+4); err != nil {
+				return nil, err
+			}
+			kept[i] = a
+		default:
+			continue
+		}
+		if parent == nil {
+			roots = append(roots, a)
+		} else {
+			parent.children = append(parent.children, a)
+		}
+	}
+	return roots, nil
+}
+
+// splitNamePath returns the atom names of a plain location path such as
+// "/ROOT/LIST/ITEM", and true. If the path uses any other syntax, it returns
+// false.
+func splitNamePath(path string) (names []string, ok bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	names = strings.Split(path[1:], "/")
+	for _, name := range names {
+		if name == "*" {
+			continue
+		}
+		if name == "" || strings.Trim(name, alphaNumericChars) != "" {
+			return nil, false
+		}
+	}
+	return names, true
+}
@@ -0,0 +1,199 @@
+package ade
+
+//
+// Verify that an Index locates every atom from headers alone, and decodes the
+// same atoms as ReadAtomsFromBinary.
+//
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// countingReaderAt counts the bytes read through it
+type countingReaderAt struct {
+	r *bytes.Reader
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestIndex(t *testing.T) {
+	for _, test := range Tests {
+		ix, err := NewIndex(bytes.NewReader(test.binBytes), int64(len(test.binBytes)))
+		if err != nil {
+			t.Errorf("NewIndex(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		want := test.atom.Descendants()
+		if len(ix.Entries) != len(want) {
+			t.Errorf("NewIndex(%s): got %d entries, want %d", test.Name(), len(ix.Entries), len(want))
+			continue
+		}
+		for i, e := range ix.Entries {
+			if e.Name != want[i].Name() || string(e.Type) != want[i].Type() {
+				t.Errorf("NewIndex(%s): entry %d is %s:%s, want %s:%s", test.Name(), i,
+					e.Name, e.Type, want[i].Name(), want[i].Type())
+				break
+			}
+		}
+
+		got, err := ix.Atom(ix.Entries[0])
+		if err != nil {
+			t.Errorf("Index.Atom(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if atomsText([]*Atom{got}) != atomsText([]*Atom{test.atom}) {
+			t.Errorf("Index.Atom(%s): result differs from test atom", test.Name())
+		}
+	}
+}
+
+func TestIndexReadsHeadersOnly(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestIndexReadsHeadersOnly: unable to create test input: %s", err)
+	}
+	r := &countingReaderAt{r: bytes.NewReader(buf)}
+	ix, err := NewIndex(r, int64(len(buf)))
+	if err != nil {
+		t.Fatalf("TestIndexReadsHeadersOnly: expect no error, got %s", err)
+	}
+	if want := int64(len(ix.Entries) * headerSize); r.n != want {
+		t.Errorf("TestIndexReadsHeadersOnly: got %d bytes read, want %d", r.n, want)
+	}
+
+	// decoding one leaf reads only that leaf
+	r.n = 0
+	leaf, err := ix.Atom(ix.Entries[6])
+	if err != nil {
+		t.Fatalf("TestIndexReadsHeadersOnly: expect no error, got %s", err)
+	}
+	if v, _ := leaf.Value.Uint(); v != 4 || r.n != 16 {
+		t.Errorf("TestIndexReadsHeadersOnly: got value %d after reading %d bytes, want value 4 after 16 bytes", v, r.n)
+	}
+
+	// a path with a predicate decodes only the atoms it can reach
+	r.n = 0
+	if _, err = ix.AtomsAtPath("/ROOT/0002/LEAF[1]"); err != nil {
+		t.Fatalf("TestIndexReadsHeadersOnly: expect no error, got %s", err)
+	}
+	if want := int64(4 + 12 + 48); r.n != want { // ROOT name, 0002 and its children
+		t.Errorf("TestIndexReadsHeadersOnly: got %d bytes read for path, want %d", r.n, want)
+	}
+}
+
+func TestIndexLookup(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestIndexLookup: unable to create test input: %s", err)
+	}
+	ix, err := NewIndex(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("TestIndexLookup: expect no error, got %s", err)
+	}
+
+	tests := []struct {
+		path    string
+		offsets []int64
+	}{
+		{"/ROOT", []int64{0}},
+		{"/ROOT/0002", []int64{72}},
+		{"/ROOT/0002/LEAF", []int64{84, 100, 116}},
+		{"/ROOT/*/LEAF", []int64{24, 40, 56, 84, 100, 116, 144, 160, 176}},
+		{"/*/0003", []int64{132}},
+		{"/ROOT/NONE", nil},
+		{"/0002", nil},
+		{"//LEAF", nil},
+		{"/ROOT/0002[1]", nil},
+	}
+	for _, test := range tests {
+		var got []int64
+		for _, e := range ix.Lookup(test.path) {
+			got = append(got, e.Offset)
+		}
+		if len(got) != len(test.offsets) {
+			t.Errorf("Index.Lookup(%s): got offsets %v, want %v", test.path, got, test.offsets)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.offsets[i] {
+				t.Errorf("Index.Lookup(%s): got offsets %v, want %v", test.path, got, test.offsets)
+				break
+			}
+		}
+	}
+
+	if got := ix.Path(ix.Lookup("/ROOT/0003")[0]); got != "/ROOT/0003" {
+		t.Errorf("Index.Path: got %q, want %q", got, "/ROOT/0003")
+	}
+	if got := ix.Path(ix.Entries[0]); got != "/ROOT" {
+		t.Errorf("Index.Path: got %q, want %q", got, "/ROOT")
+	}
+}
+
+func TestIndexAtomsAtPath(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestIndexAtomsAtPath: unable to create test input: %s", err)
+	}
+	ix, err := NewIndex(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("TestIndexAtomsAtPath: expect no error, got %s", err)
+	}
+	for _, path := range []string{
+		"/ROOT/0002",
+		"/ROOT/*/LEAF",
+		"/",
+		"//LEAF[data() > 4]",
+		"/ROOT/0002/LEAF[2]",
+		"/ROOT/*[2]/LEAF[data() > 4]",
+		"/ROOT/0003/LEAF[last()]/preceding::LEAF[1]",
+		"/0x524F4F54/0x30303031/*",
+	} {
+		want, err := TestAtom1.AtomsAtPath(path)
+		if err != nil {
+			t.Fatalf("Index.AtomsAtPath(%s): unable to evaluate path on test atom: %s", path, err)
+		}
+		got, err := ix.AtomsAtPath(path)
+		if err != nil {
+			t.Errorf("Index.AtomsAtPath(%s): expect no error, got %s", path, err)
+			continue
+		}
+		if atomsText(got) != atomsText(want) {
+			t.Errorf("Index.AtomsAtPath(%s): got\n%s\nwant\n%s", path, atomsText(got), atomsText(want))
+		}
+	}
+}
+
+func TestIndexInvalid(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestIndexInvalid: unable to create test input: %s", err)
+	}
+	badType := append([]byte{}, buf...)
+	copy(badType[152:156], "JUNK")
+
+	tests := []struct {
+		name  string
+		input []byte
+		opts  []DecodeOptions
+		want  error
+	}{
+		{"truncated header", buf[:30], nil, ErrTruncated},
+		{"truncated data", buf[:190], nil, ErrTruncated},
+		{"unknown type", badType, nil, ErrUnknownType},
+		{"too many atoms", buf, []DecodeOptions{{MaxAtoms: 5}}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		_, err := NewIndex(bytes.NewReader(test.input), int64(len(test.input)), test.opts...)
+		if !errors.Is(err, test.want) {
+			t.Errorf("NewIndex(%s): got err {%v}, want cause {%s}", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package ade
+
+// JSON encoding of Atoms, keeping the name, ADE type and value of each atom so
+// that the JSON converts back to identical binary:
+//
+//	{"name":"ROOT","type":"CONT","children":[
+//		{"name":"BVER","type":"UI32","value":6},
+//		{"name":"BTIM","type":"UI64","value":"1484723582627327"},
+//		{"name":"RATE","type":"UF32","value":"1.5000"},
+//		{"name":"NONE","type":"NULL"}
+//	]}
+//
+// Integer types up to 32 bits are JSON numbers. UI64 and SI64 values are
+// decimal strings, because many JSON consumers read every number as a float64
+// and would lose precision. FP32 and FP64 values are JSON numbers, except for
+// NaN and infinities which JSON numbers cannot express. All other types are
+// strings, in the same form as ContainerText without delimiters or escaping.
+//
+// A CSTR value which is not valid UTF-8 cannot be held in a JSON string, so its
+// bytes are written in base64 instead, and the atom is marked with an encoding:
+//
+//	{"name":"DATA","type":"CSTR","encoding":"base64","value":"/w=="}
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// jsonAtom is the JSON representation of an atom.
+type jsonAtom struct {
+	Name     string          `json:"name"`
+	Type     codec.ADEType   `json:"type"`
+	Encoding string          `json:"encoding,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Children []*Atom         `json:"children,omitempty"`
+}
+
+// jsonBase64 is the encoding of CSTR values which are not valid UTF-8.
+const jsonBase64 = "base64"
+
+// jsonContainer is the JSON representation of a container. Unlike jsonAtom,
+// the children are written even if there are none.
+type jsonContainer struct {
+	Name     string        `json:"name"`
+	Type     codec.ADEType `json:"type"`
+	Children []*Atom       `json:"children"`
+}
+
+// MarshalJSON returns the atom as a JSON object holding its name, ADE type and
+// value, along with its children if it is a container.
+//
+// It implements the json.Marshaler interface.
+func (a *Atom) MarshalJSON() ([]byte, error) {
+	if a.typ == codec.CONT {
+		children := a.Children()
+		if children == nil {
+			children = []*Atom{}
+		}
+		return json.Marshal(jsonContainer{Name: a.Name(), Type: a.typ, Children: children})
+	}
+	if isBinaryCSTR(a) {
+		value, _ := json.Marshal(base64.StdEncoding.EncodeToString(a.data[:len(a.data)-1]))
+		return json.Marshal(jsonAtom{Name: a.Name(), Type: a.typ, Encoding: jsonBase64, Value: value})
+	}
+	value, err := jsonValue(a)
+	if err != nil {
+		return nil, fmt.Errorf("conversion of atom to json failed for atom '%s:%s': %s", a.Name(), a.Type(), err)
+	}
+	return json.Marshal(jsonAtom{Name: a.Name(), Type: a.typ, Value: value})
+}
+
+// isBinaryCSTR returns true if the atom is a CSTR holding a value which is not
+// valid UTF-8.
+func isBinaryCSTR(a *Atom) bool {
+	return a.typ == codec.CSTR && bytes.IndexByte(a.data, 0) == len(a.data)-1 && !utf8.Valid(a.data[:len(a.data)-1])
+}
+
+// jsonValue returns the JSON representation of the atom value, or nil for
+// types that have no value.
+func jsonValue(a *Atom) (json.RawMessage, error) {
+	switch a.typ {
+	case codec.NULL:
+		return nil, nil
+	case codec.UI01, codec.UI08, codec.UI16, codec.UI32, codec.SI08, codec.SI16, codec.SI32, codec.ENUM:
+		s, err := a.Value.String()
+		return json.RawMessage(s), err
+	case codec.FP32, codec.FP64:
+		f, err := a.Value.Float()
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		var bitSize = 64
+		if a.typ == codec.FP32 {
+			bitSize = 32
+		}
+		return json.RawMessage(strconv.FormatFloat(f, 'g', -1, bitSize)), nil
+	default:
+		s, err := a.Value.String()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(s)
+	}
+}
+
+// UnmarshalJSON sets the Atom receiver to the atom described by a JSON object,
+// in the form written by MarshalJSON.
+//
+// Values may be given as JSON numbers or strings for any type. They are
+// converted in the same way as ContainerText values, so an error is returned
+// if a value is not valid for its ADE type.
+//
+// It implements the json.Unmarshaler interface.
+func (a *Atom) UnmarshalJSON(data []byte) error {
+	var j jsonAtom
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	var atom = new(Atom)
+	if err := codec.StringToFC32Bytes(&atom.name, j.Name); err != nil {
+		return fmt.Errorf("invalid atom name %q", j.Name)
+	}
+	if !codec.IsValidType(j.Type) {
+		return fmt.Errorf("atom %s has unknown ADE type %q: %w", j.Name, j.Type, ErrUnknownType)
+	}
+	atom.SetType(j.Type)
+
+	if j.Type == codec.CONT {
+		if j.Value != nil || j.Encoding != "" {
+			return fmt.Errorf("container %s may not have a value", j.Name)
+		}
+		for _, child := range j.Children {
+			if child == nil {
+				return fmt.Errorf("container %s has a null child", j.Name)
+			}
+		}
+		atom.children = j.Children
+	} else {
+		if j.Children != nil {
+			return fmt.Errorf("atom %s:%s may not have children", j.Name, j.Type)
+		}
+		if j.Encoding != "" && (j.Encoding != jsonBase64 || j.Type != codec.CSTR) {
+			return fmt.Errorf("atom %s:%s has unknown encoding %q", j.Name, j.Type, j.Encoding)
+		}
+		if j.Encoding == jsonBase64 {
+			if err := setJSONBase64(atom, j.Value); err != nil {
+				return fmt.Errorf("invalid value for atom %s:%s: %s", j.Name, j.Type, err)
+			}
+		} else if err := setJSONValue(atom, j.Value); err != nil {
+			return fmt.Errorf("invalid value for atom %s:%s: %s", j.Name, j.Type, err)
+		}
+	}
+
+	a.Zero()
+	*a = *atom
+	return nil
+}
+
+// setJSONValue sets the atom value from a JSON number or string. A missing
+// value is allowed only for the NULL type.
+func setJSONValue(a *Atom, value json.RawMessage) error {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || string(value) == "null" {
+		if a.typ != codec.NULL {
+			return fmt.Errorf("value is missing")
+		}
+		return nil
+	}
+
+	var s string
+	switch value[0] {
+	case '"':
+		if err := json.Unmarshal(value, &s); err != nil {
+			return err
+		}
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		s = string(value)
+	default:
+		return fmt.Errorf("expecting a number or string, got %s", value)
+	}
+	if a.typ == codec.FP32 || a.typ == codec.FP64 {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			setNonFinite(a, f)
+			return nil
+		}
+	}
+	return a.Value.SetString(s)
+}
+
+// setJSONBase64 sets a CSTR atom value from a JSON string holding the base64
+// encoded bytes of the value.
+func setJSONBase64(a *Atom, value json.RawMessage) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return fmt.Errorf("expecting a base64 string, got %s", value)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Errorf("CSTR value may not contain a null byte")
+	}
+	a.data = append(b, 0)
+	return nil
+}
+
+// setNonFinite sets a floating point atom to NaN or an infinity. These can be
+// read from binary input, but the codec does not allow them to be set.
+func setNonFinite(a *Atom, f float64) {
+	if a.typ == codec.FP32 {
+		a.data = make([]byte, 4)
+		binary.BigEndian.PutUint32(a.data, math.Float32bits(float32(f)))
+	} else {
+		a.data = make([]byte, 8)
+		binary.BigEndian.PutUint64(a.data, math.Float64bits(f))
+	}
+}
@@ -0,0 +1,184 @@
+package ade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+var jsonGINF = `{"name":"GINF","type":"CONT","children":[` +
+	`{"name":"BVER","type":"UI32","value":4},` +
+	`{"name":"BTIM","type":"UI64","value":"1484723582627327"},` +
+	`{"name":"GIDV","type":"CONT","children":[` +
+	`{"name":"AVER","type":"UI32","value":2},` +
+	`{"name":"ATIM","type":"UI64","value":"1"},` +
+	`{"name":"AVTP","type":"FC32","value":"UI32"},` +
+	`{"name":"APER","type":"FC32","value":"READ"},` +
+	`{"name":"AVAL","type":"CONT","children":[` +
+	`{"name":"0x00000000","type":"UI32","value":2},` +
+	`{"name":"0x00000001","type":"UI32","value":908767}]}]},`
+
+func TestMarshalJSON(t *testing.T) {
+	got, err := json.Marshal(TestAtomGINF)
+	if err != nil {
+		t.Fatalf("MarshalJSON(GINF): expect no error, got %s", err)
+	}
+	if !strings.HasPrefix(string(got), jsonGINF) {
+		t.Errorf("MarshalJSON(GINF): got\n%s\nwant prefix\n%s", got, jsonGINF)
+	}
+
+	tests := []struct{ input, want string }{
+		{"NONE:CONT:\nEND\n", `{"name":"NONE","type":"CONT","children":[]}`},
+		{"NULL:NULL:", `{"name":"NULL","type":"NULL"}`},
+		{"BOOL:UI01:1", `{"name":"BOOL","type":"UI01","value":1}`},
+		{"ENUM:ENUM:-2", `{"name":"ENUM","type":"ENUM","value":-2}`},
+		{"BIGU:UI64:18446744073709551615", `{"name":"BIGU","type":"UI64","value":"18446744073709551615"}`},
+		{"BIGS:SI64:-9223372036854775808", `{"name":"BIGS","type":"SI64","value":"-9223372036854775808"}`},
+		{"FLT1:FP32:1.5", `{"name":"FLT1","type":"FP32","value":1.5}`},
+		{"FLT2:FP64:0.1", `{"name":"FLT2","type":"FP64","value":0.1}`},
+		{"FIXD:UF32:1.5", `{"name":"FIXD","type":"UF32","value":"1.5000"}`},
+		{"FRAC:SR32:-1/3", `{"name":"FRAC","type":"SR32","value":"-1/3"}`},
+		{"CODE:FC32:'ABCD'", `{"name":"CODE","type":"FC32","value":"ABCD"}`},
+		{"ADDR:IP32:192.168.1.1", `{"name":"ADDR","type":"IP32","value":"192.168.1.1"}`},
+		{"UUID:UUID:64881431-B6DC-478E-B7EE-ED306619C797", `{"name":"UUID","type":"UUID","value":"64881431-B6DC-478E-B7EE-ED306619C797"}`},
+		{"TEXT:CSTR:\"tab\\x09\\\"quoted\\\"\"", `{"name":"TEXT","type":"CSTR","value":"tab\t\"quoted\""}`},
+		{"DATA:DATA:0x0102FF", `{"name":"DATA","type":"DATA","value":"0x0102FF"}`},
+	}
+	var atoms []*Atom
+	for _, test := range tests {
+		a := new(Atom)
+		if err := a.UnmarshalText([]byte(test.input)); err != nil {
+			t.Fatalf("MarshalJSON(%s): unable to create test atom: %s", test.input, err)
+		}
+		atoms = append(atoms, a)
+	}
+
+	// NaN and infinity can't be set from ContainerText, only read from binary
+	nan, _ := NewAtom("FLT3", codec.FP64, 0.0)
+	setNonFinite(nan, math.NaN())
+	inf, _ := NewAtom("FLT4", codec.FP32, 0.0)
+	setNonFinite(inf, math.Inf(-1))
+
+	// CSTR bytes which are not valid UTF-8 are written in base64
+	bin, _ := NewAtom("BINS", codec.CSTR, "")
+	bin.data = []byte("bin\xFF\x00")
+	tests = append(tests,
+		struct{ input, want string }{"FLT3:FP64:NaN", `{"name":"FLT3","type":"FP64","value":"NaN"}`},
+		struct{ input, want string }{"FLT4:FP32:-Inf", `{"name":"FLT4","type":"FP32","value":"-Inf"}`},
+		struct{ input, want string }{"BINS:CSTR:\"bin\\xFF\"", `{"name":"BINS","type":"CSTR","encoding":"base64","value":"Ymlu/w=="}`},
+	)
+	atoms = append(atoms, nan, inf, bin)
+
+	for i, test := range tests {
+		a := atoms[i]
+		got, err := json.Marshal(a)
+		if err != nil {
+			t.Errorf("MarshalJSON(%s): expect no error, got %s", test.input, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("MarshalJSON(%s): got %s, want %s", test.input, got, test.want)
+		}
+
+		// reading back must give the same binary
+		var b = new(Atom)
+		if err := json.Unmarshal(got, b); err != nil {
+			t.Errorf("UnmarshalJSON(%s): expect no error, got %s", got, err)
+			continue
+		}
+		gotBin, _ := b.MarshalBinary()
+		wantBin, _ := a.MarshalBinary()
+		if !bytes.Equal(gotBin, wantBin) {
+			t.Errorf("UnmarshalJSON(%s): result differs from original atom %s", got, test.input)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	for _, test := range Tests {
+		buf, err := json.Marshal(test.atom)
+		if err != nil {
+			t.Errorf("MarshalJSON(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		var got = new(Atom)
+		if err = json.Unmarshal(buf, got); err != nil {
+			t.Errorf("UnmarshalJSON(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if again, _ := json.Marshal(got); !bytes.Equal(again, buf) {
+			t.Errorf("UnmarshalJSON(%s): result does not convert back to the same JSON", test.Name())
+		}
+		gotBin, _ := got.MarshalBinary()
+		wantBin, _ := test.atom.MarshalBinary()
+		if !bytes.Equal(gotBin, wantBin) {
+			t.Errorf("UnmarshalJSON(%s): result differs from binary test data", test.Name())
+		}
+	}
+}
+
+func TestUnmarshalJSONNumbers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{"name":"BIGU","type":"UI64","value":18446744073709551615}`, "BIGU:UI64:18446744073709551615"},
+		{`{"name":"BIGS","type":"SI64","value":-9223372036854775808}`, "BIGS:SI64:-9223372036854775808"},
+		{`{"name":"NUMB","type":"UI32","value":"0x10"}`, "NUMB:UI32:16"},
+		{`{"name":"FLT1","type":"FP64","value":1e-3}`, "FLT1:FP64:1.00000000000000002E-03"},
+		{`{"name":"NULL","type":"NULL","value":null}`, "NULL:NULL:"},
+	}
+	for _, test := range tests {
+		var a = new(Atom)
+		if err := json.Unmarshal([]byte(test.input), a); err != nil {
+			t.Errorf("UnmarshalJSON(%s): expect no error, got %s", test.input, err)
+			continue
+		}
+		if got := a.String(); got != test.want {
+			t.Errorf("UnmarshalJSON(%s): got %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"unknown type", `{"name":"NUMB","type":"UI33","value":1}`, ErrUnknownType},
+		{"nested unknown type", `{"name":"ROOT","type":"CONT","children":[{"name":"NUMB","type":"JUNK","value":1}]}`, ErrUnknownType},
+		{"out of range", `{"name":"NUMB","type":"UI08","value":256}`, nil},
+		{"fraction", `{"name":"NUMB","type":"UI32","value":1.5}`, nil},
+		{"bad string", `{"name":"ADDR","type":"IP32","value":"1.2.3"}`, nil},
+		{"missing value", `{"name":"NUMB","type":"UI32"}`, nil},
+		{"bool value", `{"name":"BOOL","type":"UI01","value":true}`, nil},
+		{"NULL value", `{"name":"NULL","type":"NULL","value":"x"}`, nil},
+		{"bad name", `{"name":"TOOLONG","type":"NULL"}`, nil},
+		{"container value", `{"name":"ROOT","type":"CONT","value":1}`, nil},
+		{"null child", `{"name":"ROOT","type":"CONT","children":[null]}`, nil},
+		{"leaf children", `{"name":"NUMB","type":"UI32","value":1,"children":[]}`, nil},
+		{"not an object", `["ROOT"]`, nil},
+		{"unknown encoding", `{"name":"TEXT","type":"CSTR","encoding":"hex","value":"41"}`, nil},
+		{"encoding of other type", `{"name":"DATA","type":"DATA","encoding":"base64","value":"QQ=="}`, nil},
+		{"bad base64", `{"name":"TEXT","type":"CSTR","encoding":"base64","value":"QQ"}`, nil},
+		{"base64 null byte", `{"name":"TEXT","type":"CSTR","encoding":"base64","value":"QQA="}`, nil},
+		{"container encoding", `{"name":"ROOT","type":"CONT","encoding":"base64"}`, nil},
+	}
+	for _, test := range tests {
+		var a = new(Atom)
+		err := json.Unmarshal([]byte(test.input), a)
+		if err == nil {
+			t.Errorf("UnmarshalJSON(%s): expect error, got none", test.name)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("UnmarshalJSON(%s): got err {%v}, want cause {%s}", test.name, err, test.want)
+		}
+	}
+}
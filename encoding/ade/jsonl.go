@@ -0,0 +1,117 @@
+package ade
+
+// JSON Lines output of leaf atoms, for grep, awk and log pipelines. Each
+// line describes one non-container atom:
+//
+//	{"path":"/ROOT/INTS[1]/CUNS[1]/UNSA[3]","type":"UI01","value":1,"offset":68}
+//
+// The path is a location path which AtomsAtPath accepts, and which selects
+// only that atom. Each step gives the position of the atom among the children
+// of its parent which have the same name, counting from 1. Names which can't
+// be written in a path, such as names containing ':' or spaces, are written
+// as 0x followed by 8 hex digits.
+//
+// The value is in the same form as the JSON written by Atom.MarshalJSON, and
+// is left out for the NULL type. The offset is the position of the atom
+// header in the binary encoding of the root atom.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// LeafFunc is the type of the function called by WalkLeaves for each leaf
+// atom. The path and offset are described in WalkLeaves.
+//
+// If the function returns an error, the walk stops and WalkLeaves returns that
+// error.
+type LeafFunc func(path string, offset int64, a *Atom) error
+
+// jsonLine is the JSON representation of a leaf atom written by
+// WriteJSONLines.
+type jsonLine struct {
+	Path   string          `json:"path"`
+	Type   codec.ADEType   `json:"type"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Offset int64           `json:"offset"`
+}
+
+// WalkLeaves calls fn for each atom within the root atom that is not a
+// container, in depth first order. The root atom itself is included if it is
+// not a container.
+//
+// The path given to fn selects only that atom when used with
+// root.AtomsAtPath. The offset is the byte position of the atom header in the
+// binary encoding of the root atom.
+func WalkLeaves(root *Atom, fn LeafFunc) error {
+	_, err := walkLeaves(root, "/"+pathStepName(root), 0, fn)
+	return err
+}
+
+// walkLeaves walks the atom at the given path and offset, and returns the
+// offset of the atom following it.
+func walkLeaves(a *Atom, path string, offset int64, fn LeafFunc) (int64, error) {
+	if a.typ != codec.CONT {
+		if err := fn(path, offset, a); err != nil {
+			return 0, err
+		}
+		return offset + int64(a.Len()), nil
+	}
+
+	var (
+		count = make(map[string]int)
+		next  = offset + headerSize
+		err   error
+	)
+	for _, child := range a.Children() {
+		if next, err = walkLeaves(child, childPath(path, child, count), next, fn); err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// childPath returns the path of a child atom of the container at the given
+// path, which selects only that child. The count holds the number of children
+// of each name seen so far, and is updated for the child.
+func childPath(path string, child *Atom, count map[string]int) string {
+	name := pathStepName(child)
+	count[name]++
+	return path + "/" + name + "[" + strconv.Itoa(count[name]) + "]"
+}
+
+// pathStepName returns the atom name as it is written in a path step.
+func pathStepName(a *Atom) string {
+	name := a.Name()
+	if strings.Trim(name, alphaNumericChars) != "" {
+		name = fmt.Sprintf("0x%08X", a.name)
+	}
+	return name
+}
+
+// WriteJSONLines writes a JSON object on its own line for each leaf atom
+// within the root atom, giving its path, ADE type, value and offset, in the
+// order used by WalkLeaves.
+func WriteJSONLines(w io.Writer, root *Atom) error {
+	var (
+		bw  = bufio.NewWriter(w)
+		enc = json.NewEncoder(bw)
+	)
+	err := WalkLeaves(root, func(path string, offset int64, a *Atom) error {
+		value, err := jsonValue(a)
+		if err != nil {
+			return fmt.Errorf("conversion of atom to json failed for atom %s: %s", path, err)
+		}
+		return enc.Encode(jsonLine{Path: path, Type: a.typ, Value: value, Offset: offset})
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,87 @@
+package ade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestWriteJSONLines(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ROOT:CONT:\n\tINTS:CONT:\n\t\tUNSA:UI01:0\n\t\tUNSA:UI01:1\n\t\tNAME:CSTR:\"ab\"\n\tEND\n\tNONE:NULL:\nEND\n",
+			`{"path":"/ROOT/INTS[1]/UNSA[1]","type":"UI01","value":0,"offset":24}` + "\n" +
+				`{"path":"/ROOT/INTS[1]/UNSA[2]","type":"UI01","value":1,"offset":40}` + "\n" +
+				`{"path":"/ROOT/INTS[1]/NAME[1]","type":"CSTR","value":"ab","offset":56}` + "\n" +
+				`{"path":"/ROOT/NONE[1]","type":"NULL","offset":71}` + "\n"},
+		{"ROOT:CONT:\n\tNONE:CONT:\n\tEND\nEND\n", ""},
+		{"BIGU:UI64:18446744073709551615", `{"path":"/BIGU","type":"UI64","value":"18446744073709551615","offset":0}` + "\n"},
+		{"a:bc:CONT:\n\t0x00000001:FP32:0.5\nEND\n", `{"path":"/0x613A6263/0x00000001[1]","type":"FP32","value":0.5,"offset":12}` + "\n"},
+	}
+	for _, test := range tests {
+		var a = new(Atom)
+		if err := a.UnmarshalText([]byte(test.input)); err != nil {
+			t.Fatalf("WriteJSONLines(%q): unable to create test atom: %s", test.input, err)
+		}
+		var got bytes.Buffer
+		if err := WriteJSONLines(&got, a); err != nil {
+			t.Errorf("WriteJSONLines(%q): expect no error, got %s", test.input, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("WriteJSONLines(%q): got\n%s\nwant\n%s", test.input, got.String(), test.want)
+		}
+	}
+}
+
+// Verify that the path of every leaf selects only that atom, and the offset
+// locates its header in the binary encoding.
+func TestWalkLeaves(t *testing.T) {
+	for _, test := range Tests {
+		buf, err := test.atom.MarshalBinary()
+		if err != nil {
+			t.Fatalf("WalkLeaves(%s): unable to create test input: %s", test.Name(), err)
+		}
+		var count int
+		err = WalkLeaves(test.atom, func(path string, offset int64, a *Atom) error {
+			count++
+			got, err := test.atom.AtomsAtPath(path)
+			if err != nil || len(got) != 1 || got[0] != a {
+				t.Errorf("WalkLeaves(%s): path %s selects %d atoms with err %v, want only %s:%s", test.Name(), path, len(got), err, a.Name(), a.Type())
+			}
+			if offset < 0 || offset+headerSize > int64(len(buf)) {
+				t.Errorf("WalkLeaves(%s): offset %d of %s is outside binary input of %d bytes", test.Name(), offset, path, len(buf))
+				return nil
+			}
+			if size := binary.BigEndian.Uint32(buf[offset:]); size != a.Len() || !bytes.Equal(buf[offset+4:offset+8], a.name) {
+				t.Errorf("WalkLeaves(%s): offset %d of %s does not locate its header", test.Name(), offset, path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("WalkLeaves(%s): expect no error, got %s", test.Name(), err)
+		}
+		var leaves int
+		for _, a := range test.atom.Descendants() {
+			if a.Type() != "CONT" {
+				leaves++
+			}
+		}
+		if count != leaves {
+			t.Errorf("WalkLeaves(%s): visited %d atoms, want %d", test.Name(), count, leaves)
+		}
+	}
+
+	// an error from the walk function stops the walk
+	var (
+		errStop = errors.New("stop")
+		count   int
+	)
+	err := WalkLeaves(TestAtom1, func(string, int64, *Atom) error { count++; return errStop })
+	if err != errStop || count != 1 {
+		t.Errorf("WalkLeaves(TestAtom1): got err {%v} after %d atoms, want {%s} after 1", err, count, errStop)
+	}
+}
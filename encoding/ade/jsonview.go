@@ -0,0 +1,157 @@
+package ade
+
+// A natural JSON view of Atoms, for tools such as jq. Unlike the JSON written
+// by Atom.MarshalJSON, the view drops ADE types and can't be converted back.
+//
+// A container becomes a JSON object with a member for each child atom name.
+// Atoms whose name appears more than once in a container are collected into
+// an array. Leaf atoms become native JSON values:
+//
+//	ROOT:CONT:
+//		BVER:UI32:6
+//		FLAG:UI01:1
+//		NAME:CSTR:"grid"
+//		ADDR:IP32:10.0.0.1
+//		ADDR:IP32:10.0.0.2
+//	END
+//
+// becomes
+//
+//	{"ROOT":{"BVER":6,"FLAG":true,"NAME":"grid","ADDR":["10.0.0.1","10.0.0.2"]}}
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// JSONViewOptions control the output of MarshalJSONView.
+type JSONViewOptions struct {
+	// Indent is the string used to indent each level of nesting. If empty,
+	// the output is compact.
+	Indent string
+
+	// Arrays makes every atom an array element, even if its name is not
+	// repeated, so that the shape of the output does not depend on the
+	// number of atoms.
+	Arrays bool
+}
+
+// MarshalJSONView returns a JSON object with one member, named for the atom,
+// holding the atom value. Containers are objects keyed by child atom name,
+// and other atoms are JSON numbers, strings, booleans or null.
+//
+// Integer, floating point and fixed point types are numbers, and UI01 is a
+// boolean. NULL is null. Other types are strings, in the same form as
+// ContainerText without delimiters or escaping. NaN and infinities are
+// strings, because JSON numbers cannot express them.
+//
+// If JSONViewOptions are given, they control the output format. Otherwise the
+// output is compact.
+func MarshalJSONView(a *Atom, opts ...JSONViewOptions) ([]byte, error) {
+	var o JSONViewOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if err := writeJSONViewMembers(&buf, []*Atom{a}, o); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	if o.Indent == "" {
+		return buf.Bytes(), nil
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", o.Indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeJSONViewMembers writes object members for the atoms, keyed by atom
+// name in order of first appearance. Atoms with the same name share a member
+// holding an array.
+func writeJSONViewMembers(buf *bytes.Buffer, atoms []*Atom, o JSONViewOptions) error {
+	var (
+		names  []string
+		byName = make(map[string][]*Atom, len(atoms))
+	)
+	for _, a := range atoms {
+		name := a.Name()
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], a)
+	}
+
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		group := byName[name]
+		if len(group) == 1 && !o.Arrays {
+			if err := writeJSONViewValue(buf, group[0], o); err != nil {
+				return err
+			}
+			continue
+		}
+		buf.WriteByte('[')
+		for j, a := range group {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONViewValue(buf, a, o); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+// writeJSONViewValue writes the value of an atom, which is an object for a
+// container.
+func writeJSONViewValue(buf *bytes.Buffer, a *Atom, o JSONViewOptions) error {
+	if a.typ == codec.CONT {
+		buf.WriteByte('{')
+		if err := writeJSONViewMembers(buf, a.Children(), o); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+
+	value, err := jsonViewValue(a)
+	if err != nil {
+		return fmt.Errorf("conversion of atom to json failed for atom '%s:%s': %s", a.Name(), a.Type(), err)
+	}
+	buf.Write(value)
+	return nil
+}
+
+// jsonViewValue returns the atom value as a native JSON value.
+func jsonViewValue(a *Atom) (json.RawMessage, error) {
+	switch a.typ {
+	case codec.NULL:
+		return json.RawMessage("null"), nil
+	case codec.UI01:
+		if b, err := a.Value.Bool(); err == nil {
+			return json.Marshal(b)
+		}
+		return jsonValue(a) // out of range for a bool, so keep the number
+	case codec.UI64, codec.SI64, codec.UF32, codec.UF64, codec.SF32, codec.SF64:
+		s, err := a.Value.String()
+		return json.RawMessage(s), err
+	default:
+		return jsonValue(a)
+	}
+}
@@ -0,0 +1,64 @@
+package ade
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMarshalJSONView(t *testing.T) {
+	const example = "ROOT:CONT:\n\tBVER:UI32:6\n\tFLAG:UI01:1\n\tNAME:CSTR:\"grid\"\n" +
+		"\tADDR:IP32:10.0.0.1\n\tADDR:IP32:10.0.0.2\nEND\n"
+	tests := []struct {
+		input string
+		opts  JSONViewOptions
+		want  string
+	}{
+		{example, JSONViewOptions{},
+			`{"ROOT":{"BVER":6,"FLAG":true,"NAME":"grid","ADDR":["10.0.0.1","10.0.0.2"]}}`},
+		{example, JSONViewOptions{Arrays: true},
+			`{"ROOT":[{"BVER":[6],"FLAG":[true],"NAME":["grid"],"ADDR":["10.0.0.1","10.0.0.2"]}]}`},
+		{"ROOT:CONT:\n\tNONE:CONT:\n\tEND\n\tNULL:NULL:\nEND\n", JSONViewOptions{Indent: "  "},
+			"{\n  \"ROOT\": {\n    \"NONE\": {},\n    \"NULL\": null\n  }\n}"},
+		{"BIGU:UI64:18446744073709551615", JSONViewOptions{}, `{"BIGU":18446744073709551615}`},
+		{"FIXD:SF32:-1.5", JSONViewOptions{}, `{"FIXD":-1.5000}`},
+		{"FRAC:UR32:1/3", JSONViewOptions{}, `{"FRAC":"1/3"}`},
+		{"FLTA:FP32:0.1", JSONViewOptions{}, `{"FLTA":0.1}`},
+		{"TEXT:CSTR:\"a\\nb\"", JSONViewOptions{}, `{"TEXT":"a\nb"}`},
+		{"0x00000001:UI08:7", JSONViewOptions{}, `{"0x00000001":7}`},
+	}
+	for _, test := range tests {
+		var a = new(Atom)
+		if err := a.UnmarshalText([]byte(test.input)); err != nil {
+			t.Fatalf("MarshalJSONView(%q): unable to create test atom: %s", test.input, err)
+		}
+		got, err := MarshalJSONView(a, test.opts)
+		if err != nil {
+			t.Errorf("MarshalJSONView(%q): expect no error, got %s", test.input, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("MarshalJSONView(%q): got\n%s\nwant\n%s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMarshalJSONViewValid(t *testing.T) {
+	for _, test := range Tests {
+		got, err := MarshalJSONView(test.atom)
+		if err != nil {
+			t.Errorf("MarshalJSONView(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if !json.Valid(got) {
+			t.Errorf("MarshalJSONView(%s): output is not valid JSON", test.Name())
+		}
+	}
+
+	got, _ := MarshalJSONView(TestAtomGINF)
+	want := `{"GINF":{"BVER":4,"BTIM":1484723582627327,"GIDV":{"AVER":2,"ATIM":1,"AVTP":"UI32","APER":"READ",` +
+		`"AVAL":{"0x00000000":2,"0x00000001":908767}},`
+	if !strings.HasPrefix(string(got), want) {
+		t.Errorf("MarshalJSONView(GINF): got\n%s\nwant prefix\n%s", got, want)
+	}
+}
@@ -0,0 +1,499 @@
+package ade
+
+// Conversion of Go values to Atoms, using struct field tags to give the name
+// and ADE type of the atom for each field:
+//
+//	type Grid struct {
+//		ADEName struct{}          `ade:"GRID"`
+//		Version uint32            `ade:"BVER,UI32"`
+//		Name    string            `ade:"NAME"`
+//		Note    string            `ade:"NOTE,CSTR,omitempty"`
+//		Nodes   []Node            `ade:"NODE"`
+//		Values  map[string]uint32 `ade:"AVAL,UI32"`
+//	}
+//
+//	type Node struct {
+//		ID   uint32 `ade:"NDID"`
+//		Addr string `ade:"ADDR,IP32"`
+//	}
+//
+// gives atoms such as
+//
+//	GRID:CONT:
+//		BVER:UI32:6
+//		NAME:CSTR:"grid"
+//		NODE:CONT:
+//			NDID:UI32:12001
+//			ADDR:IP32:10.0.0.1
+//		END
+//		AVAL:CONT:
+//			0x00000001:UI32:908767
+//		END
+//	END
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// Marshaler is the interface implemented by types that can convert themselves
+// to an atom.
+//
+// The name of the returned atom is replaced by the name in the struct tag of
+// the field holding the value. If the tag gives an ADE type, the atom must
+// have that type.
+type Marshaler interface {
+	MarshalADE() (*Atom, error)
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// MarshalADE returns a copy of the atom, so that a struct field of type *Atom
+// may hold an atom of any type.
+//
+// It implements the Marshaler interface.
+func (a *Atom) MarshalADE() (*Atom, error) {
+	return copyAtom(a), nil
+}
+
+// copyAtom returns a copy of the atom and all of its children.
+func copyAtom(a *Atom) *Atom {
+	var c = &Atom{
+		name: append([]byte(nil), a.name...),
+		typ:  a.typ,
+		data: append([]byte(nil), a.data...),
+	}
+	c.Value = codec.NewCodec(&c.data, c.typ)
+	for _, child := range a.Children() {
+		c.children = append(c.children, copyAtom(child))
+	}
+	return c
+}
+
+// Marshal returns the atom tree for v, which must be a struct or a pointer to
+// a struct.
+//
+// The struct becomes a CONT atom. Its name comes from the ade tag of a field
+// named ADEName, which holds no data, so it is typically of type struct{}.
+//
+// Each exported struct field with an ade tag becomes a child atom. The tag
+// gives the atom name, followed by optional comma separated ADE type and
+// omitempty option:
+//
+//	Version uint32 `ade:"BVER"`
+//	Version uint32 `ade:"BVER,UI32"`
+//	Comment string `ade:"CMNT,CSTR,omitempty"`
+//	Ignored string `ade:"-"`
+//
+// Fields without an ade tag are ignored, except for embedded structs, whose
+// fields are treated as fields of the outer struct. With the omitempty option,
+// no atom is created if the field has a false, 0, empty string, nil pointer or
+// empty slice or map value.
+//
+// If no ADE type is given, it is chosen from the Go type: UI01 for bool, UI08
+// to UI64 for unsigned integers, SI08 to SI64 for signed integers, FP32 or
+// FP64 for floats, CSTR for strings, DATA for []byte and CONT for structs.
+// The int and uint types are 64 bits. A Go value may be used for any ADE type
+// that accepts it; strings are converted in the same way as ContainerText
+// values, so a string may hold the value of any ADE type.
+//
+// Slices and arrays become a repeated atom for each element, except for
+// []byte with no ADE type or one holding bytes (DATA, CNCT or Cnct), and
+// slices of numbers with an ADE type holding a fraction (UR32, UR64, SR32 or
+// SR64), which become a single atom. So a []byte tagged UI08 becomes a
+// repeated atom, and a fraction atom which is repeated needs a slice of
+// slices, such as [][]int64. Maps with string keys
+// become a CONT atom with a child atom for each element, named for its key, in
+// key order. For slices and maps, the ADE type in the tag is the type of the
+// elements. Nil pointers and interfaces give no atom; others are replaced by
+// the value they point to. Types implementing Marshaler create their own atom.
+func Marshal(v interface{}) (*Atom, error) {
+	var rv = reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && !rv.Type().Implements(marshalerType) {
+		rv = rv.Elem()
+	}
+	if m, ok := marshaler(rv); ok {
+		return m.MarshalADE()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unable to marshal %s, expecting a struct", typeName(rv))
+	}
+
+	name, err := structName(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	atoms, err := marshalValue(name, codec.CONT, rv, rv.Type().Name())
+	if err != nil {
+		return nil, err
+	}
+	return atoms[0], nil
+}
+
+// structName returns the atom name given by the ADEName field of the struct
+// type.
+func structName(t reflect.Type) (string, error) {
+	f, ok := t.FieldByName("ADEName")
+	if !ok || f.Tag.Get("ade") == "" {
+		return "", fmt.Errorf("unable to marshal %s, no atom name given by an ade tag on an ADEName field", t)
+	}
+	name, _, _, err := parseTag(f.Tag.Get("ade"))
+	if err != nil {
+		return "", fmt.Errorf("invalid ade tag on field %s.ADEName: %s", t, err)
+	}
+	return name, nil
+}
+
+// fieldInfo describes a struct field holding an atom.
+type fieldInfo struct {
+	name      string        // atom name
+	typ       codec.ADEType // ADE type, or empty to choose one from the Go type
+	omitEmpty bool
+	index     []int // field index sequence, for reflect.Value.FieldByIndex
+	goName    string
+}
+
+// structFields returns the fields of the struct type which hold atoms, in
+// order, including the fields of embedded structs.
+func structFields(t reflect.Type) (fields []fieldInfo, err error) {
+	for i := 0; i < t.NumField(); i++ {
+		var (
+			sf  = t.Field(i)
+			tag = sf.Tag.Get("ade")
+		)
+		switch {
+		case tag == "-" || sf.Name == "ADEName":
+			continue
+		case tag == "" && sf.Anonymous && sf.Type.Kind() == reflect.Struct:
+			embedded, err := structFields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		case tag == "" || sf.PkgPath != "":
+			continue // untagged or unexported
+		}
+
+		var f = fieldInfo{index: []int{i}, goName: t.Name() + "." + sf.Name}
+		if f.name, f.typ, f.omitEmpty, err = parseTag(tag); err != nil {
+			return nil, fmt.Errorf("invalid ade tag on field %s: %s", f.goName, err)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// parseTag returns the atom name, ADE type and omitempty option of an ade
+// struct tag.
+func parseTag(tag string) (name string, typ codec.ADEType, omitEmpty bool, err error) {
+	var parts = strings.Split(tag, ",")
+	name = parts[0]
+	var buf []byte
+	if err = codec.StringToFC32Bytes(&buf, name); err != nil {
+		return "", "", false, fmt.Errorf("invalid atom name %q", name)
+	}
+	name, _ = codec.FC32ToString(buf) // as given by Atom.Name
+	for i, opt := range parts[1:] {
+		switch {
+		case opt == "omitempty":
+			omitEmpty = true
+		case i == 0 && codec.IsValidType(codec.ADEType(opt)):
+			typ = codec.ADEType(opt)
+		case i == 0 && opt == "":
+		default:
+			return "", "", false, fmt.Errorf("unknown ADE type or option %q", opt)
+		}
+	}
+	return name, typ, omitEmpty, nil
+}
+
+// marshalField returns the atoms for a struct field.
+func marshalField(f fieldInfo, v reflect.Value) ([]*Atom, error) {
+	if f.omitEmpty && isEmptyValue(v) {
+		return nil, nil
+	}
+	return marshalValue(f.name, f.typ, v, f.goName)
+}
+
+// marshalValue returns the atoms for a Go value, which may be none for a nil
+// pointer, or many for a slice. The goName describes the value for error
+// messages.
+func marshalValue(name string, typ codec.ADEType, v reflect.Value, goName string) (atoms []*Atom, err error) {
+	if m, ok := marshaler(v); ok {
+		a, err := m.MarshalADE()
+		if err != nil {
+			return nil, err
+		}
+		if a == nil {
+			return nil, nil
+		}
+		if typ != "" && a.typ != typ {
+			return nil, fmt.Errorf("unable to marshal %s, MarshalADE returned type %s, expecting %s", goName, a.typ, typ)
+		}
+		if err = codec.StringToFC32Bytes(&a.name, name); err != nil {
+			return nil, fmt.Errorf("unable to marshal %s, invalid atom name %q", goName, name)
+		}
+		return []*Atom{a}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(name, typ, v.Elem(), goName)
+	case reflect.Slice, reflect.Array:
+		if isRepeated(v.Type(), typ) {
+			for i := 0; i < v.Len(); i++ {
+				more, err := marshalValue(name, typ, v.Index(i), fmt.Sprintf("%s[%d]", goName, i))
+				if err != nil {
+					return nil, err
+				}
+				atoms = append(atoms, more...)
+			}
+			return atoms, nil
+		}
+	case reflect.Map:
+		return marshalMap(name, typ, v, goName)
+	case reflect.Struct:
+		return marshalStruct(name, typ, v, goName)
+	}
+
+	a, err := newAtom(name, typ, v, goName)
+	if err != nil {
+		return nil, err
+	}
+	if err = setReflectValue(a, v); err != nil {
+		return nil, fmt.Errorf("unable to marshal %s as %s:%s: %s", goName, a.Name(), a.typ, err)
+	}
+	return []*Atom{a}, nil
+}
+
+// marshalStruct returns a container atom holding the atoms for the struct
+// fields.
+func marshalStruct(name string, typ codec.ADEType, v reflect.Value, goName string) ([]*Atom, error) {
+	if typ != "" && typ != codec.CONT {
+		return nil, fmt.Errorf("unable to marshal %s, a struct must have type CONT, not %s", goName, typ)
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAtom(name, codec.CONT, v, goName)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		children, err := marshalField(f, v.FieldByIndex(f.index))
+		if err != nil {
+			return nil, err
+		}
+		a.children = append(a.children, children...)
+	}
+	return []*Atom{a}, nil
+}
+
+// marshalMap returns a container atom with a child atom for each element of
+// the map, named for its key.
+func marshalMap(name string, typ codec.ADEType, v reflect.Value, goName string) ([]*Atom, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("unable to marshal %s, map keys must be strings, not %s", goName, v.Type().Key())
+	}
+	a, err := newAtom(name, codec.CONT, v, goName)
+	if err != nil {
+		return nil, err
+	}
+	var keys = v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, k := range keys {
+		children, err := marshalValue(k.String(), typ, v.MapIndex(k), fmt.Sprintf("%s[%q]", goName, k.String()))
+		if err != nil {
+			return nil, err
+		}
+		a.children = append(a.children, children...)
+	}
+	return []*Atom{a}, nil
+}
+
+// newAtom returns an atom with the given name and type, choosing a type from
+// the Go type of the value if none is given.
+func newAtom(name string, typ codec.ADEType, v reflect.Value, goName string) (*Atom, error) {
+	if typ == "" {
+		if typ = defaultType(v.Type()); typ == "" {
+			return nil, fmt.Errorf("unable to marshal %s, no ADE type for Go type %s", goName, v.Type())
+		}
+	}
+	var a = new(Atom)
+	if err := codec.StringToFC32Bytes(&a.name, name); err != nil {
+		return nil, fmt.Errorf("unable to marshal %s, invalid atom name %q", goName, name)
+	}
+	a.SetType(typ)
+	return a, nil
+}
+
+// defaultType returns the ADE type used for a Go type when the struct tag
+// does not give one, or an empty string if there is none.
+func defaultType(t reflect.Type) codec.ADEType {
+	switch t.Kind() {
+	case reflect.Bool:
+		return codec.UI01
+	case reflect.Uint8:
+		return codec.UI08
+	case reflect.Uint16:
+		return codec.UI16
+	case reflect.Uint32:
+		return codec.UI32
+	case reflect.Uint, reflect.Uint64:
+		return codec.UI64
+	case reflect.Int8:
+		return codec.SI08
+	case reflect.Int16:
+		return codec.SI16
+	case reflect.Int32:
+		return codec.SI32
+	case reflect.Int, reflect.Int64:
+		return codec.SI64
+	case reflect.Float32:
+		return codec.FP32
+	case reflect.Float64:
+		return codec.FP64
+	case reflect.String:
+		return codec.CSTR
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return codec.DATA
+		}
+	case reflect.Struct, reflect.Map:
+		return codec.CONT
+	}
+	return ""
+}
+
+// setReflectValue sets the atom value from a Go value of a basic kind.
+func setReflectValue(a *Atom, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		return a.Value.SetBool(v.Bool())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Value.SetUint(v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Value.SetInt(v.Int())
+	case reflect.Float32, reflect.Float64:
+		return a.Value.SetFloat(v.Float())
+	case reflect.String:
+		return a.Value.SetString(v.String())
+	case reflect.Slice, reflect.Array:
+		switch {
+		case isBytesType(a.typ):
+			if v.Type().Elem().Kind() != reflect.Uint8 {
+				break
+			}
+			a.data = make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(a.data), v)
+			return nil
+		case a.typ == codec.UR32 || a.typ == codec.UR64:
+			var list = make([]uint64, v.Len())
+			for i := range list {
+				switch v.Index(i).Kind() {
+				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+					list[i] = v.Index(i).Uint()
+				default:
+					return fmt.Errorf("unable to set %s value from Go type %s", a.typ, v.Type())
+				}
+			}
+			return a.Value.SetSliceOfUint(list)
+		case a.typ == codec.SR32 || a.typ == codec.SR64:
+			var list = make([]int64, v.Len())
+			for i := range list {
+				switch v.Index(i).Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+					list[i] = v.Index(i).Int()
+				default:
+					return fmt.Errorf("unable to set %s value from Go type %s", a.typ, v.Type())
+				}
+			}
+			return a.Value.SetSliceOfInt(list)
+		}
+	}
+	return fmt.Errorf("unable to set %s value from Go type %s", a.typ, v.Type())
+}
+
+// isListType returns true if the ADE type holds a list of numbers, which is
+// converted to or from a Go slice rather than repeated atoms.
+func isListType(typ codec.ADEType) bool {
+	switch typ {
+	case codec.UR32, codec.UR64, codec.SR32, codec.SR64:
+		return true
+	}
+	return false
+}
+
+// isBytesType returns true if the ADE type holds bytes, which are converted
+// to or from a Go []byte rather than repeated atoms.
+func isBytesType(typ codec.ADEType) bool {
+	return typ == codec.DATA || typ == codec.CNCT || typ == codec.Cnct
+}
+
+// isRepeated returns true if the slice or array type holds a repeated atom of
+// the ADE type, rather than the value of a single atom. Bytes hold a single
+// atom only if the ADE type holds bytes, or is not given.
+func isRepeated(t reflect.Type, typ codec.ADEType) bool {
+	switch t.Elem().Kind() {
+	case reflect.Uint8:
+		return typ != "" && !isBytesType(typ) && !isListType(typ)
+	case reflect.Slice, reflect.Array:
+		return true
+	}
+	return !isListType(typ)
+}
+
+// marshaler returns the value as a Marshaler, if it implements the interface.
+// Nil pointers are not Marshalers, since they have no atom.
+func marshaler(v reflect.Value) (Marshaler, bool) {
+	if !v.IsValid() {
+		return nil, false
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		v = v.Addr()
+	}
+	if !v.Type().Implements(marshalerType) || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, false
+	}
+	m, ok := v.Interface().(Marshaler)
+	return m, ok
+}
+
+// isEmptyValue returns true if the value is empty for the omitempty option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// typeName returns the Go type of the value for error messages.
+func typeName(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
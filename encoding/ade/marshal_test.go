@@ -0,0 +1,207 @@
+package ade
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+type testGrid struct {
+	ADEName  struct{}          `ade:"GRID"`
+	Version  uint32            `ade:"BVER"`
+	Time     uint64            `ade:"BTIM,UI64"`
+	Name     string            `ade:"NAME"`
+	Note     string            `ade:"NOTE,CSTR,omitempty"`
+	Ratio    float64           `ade:"RATE,UF32"`
+	Online   bool              `ade:"ONLN"`
+	Nodes    []testNode        `ade:"NODE"`
+	Primary  *testNode         `ade:"PRIM,CONT,omitempty"`
+	Values   map[string]uint32 `ade:"AVAL,UI32"`
+	Key      []byte            `ade:"KEY_"`
+	Ignored  string            `ade:"-"`
+	Untagged string
+	testVersion
+}
+
+type testNode struct {
+	ID    int32      `ade:"NDID"`
+	Addr  string     `ade:"ADDR,IP32"`
+	Perms testPerms  `ade:"PERM"`
+	Roles []string   `ade:"ROLE,FC32"`
+	Zone  *testZone  `ade:"ZONE"`
+	Extra *testPerms `ade:"XTRA"`
+}
+
+type testVersion struct {
+	Major uint8 `ade:"MAJR"`
+}
+
+// testPerms marshals itself as a comma separated list in a CSTR atom.
+type testPerms []string
+
+func (p testPerms) MarshalADE() (*Atom, error) {
+	return NewAtom("PERM", codec.CSTR, strings.Join(p, ","))
+}
+
+// testZone marshals itself as an ENUM atom, through a pointer receiver.
+type testZone int
+
+func (z *testZone) MarshalADE() (*Atom, error) {
+	return NewAtom("ZONE", codec.ENUM, int64(*z))
+}
+
+func TestMarshal(t *testing.T) {
+	var zone = testZone(3)
+	var grid = testGrid{
+		Version: 6,
+		Time:    1484723582627327,
+		Name:    "grid",
+		Ratio:   1.5,
+		Online:  true,
+		Nodes: []testNode{
+			{ID: 12001, Addr: "10.0.0.1", Perms: testPerms{"READ", "WRITE"}, Roles: []string{"ADMN", "STOR"}, Zone: &zone},
+			{ID: -1, Addr: "10.0.0.2"},
+		},
+		Values:      map[string]uint32{"0x00000001": 908767, "0x00000000": 2},
+		Key:         []byte{0xde, 0xad},
+		Ignored:     "ignored",
+		Untagged:    "ignored",
+		testVersion: testVersion{Major: 10},
+	}
+	want := `GRID:CONT:
+	BVER:UI32:6
+	BTIM:UI64:1484723582627327
+	NAME:CSTR:"grid"
+	RATE:UF32:1.5000
+	ONLN:UI01:1
+	NODE:CONT:
+		NDID:SI32:12001
+		ADDR:IP32:10.0.0.1
+		PERM:CSTR:"READ,WRITE"
+		ROLE:FC32:'ADMN'
+		ROLE:FC32:'STOR'
+		ZONE:ENUM:3
+	END
+	NODE:CONT:
+		NDID:SI32:-1
+		ADDR:IP32:10.0.0.2
+		PERM:CSTR:""
+	END
+	AVAL:CONT:
+		0x00000000:UI32:2
+		0x00000001:UI32:908767
+	END
+	KEY_:DATA:0xDEAD
+	MAJR:UI08:10
+END
+`
+	for _, v := range []interface{}{grid, &grid} {
+		a, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal(%T): expect no error, got %s", v, err)
+		}
+		got, _ := a.MarshalText()
+		if string(got) != want {
+			t.Errorf("Marshal(%T): got\n%s\nwant\n%s", v, got, want)
+		}
+	}
+
+	// omitempty fields are written if not empty
+	grid.Note = "note"
+	grid.Primary = &grid.Nodes[1]
+	a, err := Marshal(grid)
+	if err != nil {
+		t.Fatalf("Marshal(omitempty): expect no error, got %s", err)
+	}
+	var names []string
+	for _, child := range a.Children() {
+		names = append(names, child.Name())
+	}
+	if got := strings.Join(names, " "); got != "BVER BTIM NAME NOTE RATE ONLN NODE NODE PRIM AVAL KEY_ MAJR" {
+		t.Errorf("Marshal(omitempty): got child atoms %s", got)
+	}
+
+	// bytes with a numeric ADE type are repeated atoms, other bytes one atom
+	type bytesStruct struct {
+		ADEName  struct{} `ade:"ROOT"`
+		Unsigned []uint8  `ade:"UNSB,UI08"`
+		Array    [2]uint8 `ade:"ARRY,UI08"`
+		Data     [2]byte  `ade:"DATA"`
+		Fraction []uint8  `ade:"FRAC,UR32"`
+	}
+	a, err = Marshal(bytesStruct{Unsigned: []uint8{1, 2, 3}, Array: [2]uint8{4, 5}, Data: [2]byte{6, 7}, Fraction: []uint8{1, 3}})
+	if err != nil {
+		t.Fatalf("Marshal(bytes): expect no error, got %s", err)
+	}
+	want = "ROOT:CONT:\n\tUNSB:UI08:1\n\tUNSB:UI08:2\n\tUNSB:UI08:3\n\tARRY:UI08:4\n\tARRY:UI08:5\n" +
+		"\tDATA:DATA:0x0607\n\tFRAC:UR32:1/3\nEND\n"
+	if got, _ := a.MarshalText(); string(got) != want {
+		t.Errorf("Marshal(bytes): got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	type noName struct {
+		Version uint32 `ade:"BVER"`
+	}
+	type badName struct {
+		ADEName struct{} `ade:"TOOLONG"`
+	}
+	type badType struct {
+		ADEName struct{} `ade:"ROOT"`
+		Version uint32   `ade:"BVER,JUNK"`
+	}
+	type badValue struct {
+		ADEName struct{} `ade:"ROOT"`
+		Version int32    `ade:"BVER,UI08"`
+	}
+	type badConversion struct {
+		ADEName struct{} `ade:"ROOT"`
+		Version float64  `ade:"BVER,UI32"`
+	}
+	type badKind struct {
+		ADEName struct{}  `ade:"ROOT"`
+		Events  chan bool `ade:"EVNT"`
+	}
+	type badStruct struct {
+		ADEName struct{} `ade:"ROOT"`
+		Node    testNode `ade:"NODE,UI32"`
+	}
+	type badKey struct {
+		ADEName struct{}       `ade:"ROOT"`
+		Values  map[int]uint32 `ade:"AVAL"`
+	}
+	type badMapName struct {
+		ADEName struct{}          `ade:"ROOT"`
+		Values  map[string]uint32 `ade:"AVAL"`
+	}
+	type badMarshaler struct {
+		ADEName struct{}  `ade:"ROOT"`
+		Perms   testPerms `ade:"PERM,UI32"`
+	}
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "unable to marshal nil, expecting a struct"},
+		{3, "unable to marshal int, expecting a struct"},
+		{(*testGrid)(nil), "unable to marshal *ade.testGrid, expecting a struct"},
+		{noName{}, "no atom name given"},
+		{badName{}, `invalid atom name "TOOLONG"`},
+		{badType{}, `unknown ADE type or option "JUNK"`},
+		{badValue{Version: -1}, "unable to marshal badValue.Version as BVER:UI08"},
+		{badConversion{}, "unable to marshal badConversion.Version as BVER:UI32"},
+		{badKind{}, "no ADE type for Go type chan bool"},
+		{badStruct{}, "a struct must have type CONT, not UI32"},
+		{badKey{Values: map[int]uint32{}}, "map keys must be strings"},
+		{badMapName{Values: map[string]uint32{"TOOLONG": 1}}, `invalid atom name "TOOLONG"`},
+		{badMarshaler{}, "MarshalADE returned type CSTR, expecting UI32"},
+	}
+	for _, test := range tests {
+		_, err := Marshal(test.v)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Marshal(%T): got err {%v}, want {%s}", test.v, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package ade
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile returns the contents of the file, mapped into memory. The mapping
+// is private, so writes to the memory are not written to the file.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(buf []byte) error {
+	if buf == nil {
+		return nil
+	}
+	return syscall.Munmap(buf)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package ade
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile returns the contents of the file. Memory mapping is only used on
+// Linux, so the file is read into memory.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	_, err := io.ReadFull(f, buf)
+	return buf, err
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(buf []byte) error {
+	return nil
+}
@@ -23,14 +23,18 @@ package ade
 // prefix order so that during evaluation, operator tokens are followed by
 // their operands. This follows operator precedence rules.
 //
-// Evaluation is performed whenever the AtomPath method GetAtoms(a *Atom) is
-// called, which provides a root atom to evaluate against the path.
+// Evaluation is performed whenever the AtomPath method GetAtoms(a *Atom) or
+// Evaluate(a *Atom) is called, which provides a root atom to evaluate against
+// the path.
 //
 // At a low level, there are separate evaluators for the path and predicate
 // even though they share the same token stack, because the code is simpler
 // this way. Evaluation is almost 100% different within a predicate.  The
 // parser does some juggling to delimit predicate tokens with Predicate Start
 // and Predicate End tokens, so it is simple to know when to switch evaluators.
+// An expression which is not a location path, such as "count(//NODE) * 2", is
+// evaluated like a predicate of the root atom, switching to the path evaluator
+// for the location paths within it.
 //
 // === Terminology ===
 // Terms used to describe attributes of a path are 100% stolen from the XPath
@@ -46,9 +50,11 @@ package ade
 //
 // axis:
 //     An axis defines a node-set relative to the current node.
-//     AtomPath has minimal support for axes, it only supports the child axis
-//     which is the default of XPath. The leading / or // in a path expression
-//     are the axis.
+//     AtomPath supports the XPath 1.0 axes except attribute and namespace, as
+//     in "parent::*" or "following-sibling::NAME", along with the
+//     abbreviations "." and "..". The child axis is the default, and "//"
+//     selects descendants.  Atoms don't know their parent, so the parent of
+//     each atom is found from the context atom when an axis needs it.
 // node test:
 //     A node test is a test applied to the axis which filters out the atoms
 //     that don't match the test.  The node test operator "*" matches all atoms
@@ -64,10 +70,17 @@ package ade
 //     A predicate is delimited by []. 0-N predicates may be used -- if a
 //     predicate B follows predicate A, then B filters down the node set
 //     resulting from A, instead of the nodeset resulting from the node test.
+//     A predicate may contain location paths, which start from the atom being
+//     tested unless they are absolute, and which may have predicates of their
+//     own, as in "//NODE[DISK[ERRS > 0]/NAME = INFO/HOST]".  A bare name is
+//     the value of the children with that name, or a string if there are none.
 
 import (
 	"fmt"
 	"math"
+	"math/big"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -90,6 +103,8 @@ const (
 	tokenOperator           = "tknOperator"
 	tokenFunctionBool       = "tknFunctionBool"
 	tokenFunctionNumeric    = "tknFunctionNum"
+	tokenFunctionString     = "tknFunctionString"
+	tokenComma              = "tknComma" // separates function arguments
 	tokenVariable           = "tknVar"
 	tokenInteger            = "tknInt"
 	tokenFloat              = "tknFloat"
@@ -97,15 +112,36 @@ const (
 	tokenHex                = "tknHex"
 )
 
+// Axes of a location step, from XPath 1.0.  The attribute and namespace axes
+// are not supported, because atoms have no attribute or namespace nodes.
+const (
+	axisAncestor         = "ancestor"
+	axisAncestorOrSelf   = "ancestor-or-self"
+	axisChild            = "child"
+	axisDescendant       = "descendant"
+	axisDescendantOrSelf = "descendant-or-self"
+	axisFollowing        = "following"
+	axisFollowingSibling = "following-sibling"
+	axisParent           = "parent"
+	axisPreceding        = "preceding"
+	axisPrecedingSibling = "preceding-sibling"
+	axisSelf             = "self"
+)
+
 type (
 	// AtomPath implements XPath expression support for Atoms.
 	//
 	// Paths may include tests based on atom name, type and data.
-	// Arithmetic operations on atom data are supported.
+	// Arithmetic operations on atom data are supported, as are the XPath
+	// string functions such as contains() and matches(), and numeric
+	// functions such as sum() and round().  Integer arithmetic that overflows
+	// is an error.
 	// Set operations are supported, allowing requests for the union or
 	// intersection of multiple paths.
 	//
 	// Multiple predicates may be stacked. (eg. "//*[data() > 1][@type != UI64]
+	// Predicates may contain relative location paths with predicates of their
+	// own, as in "//NODE[INFO[HOST = "dc1-s1"]]".
 	AtomPath struct {
 		Path      string
 		evaluator *pathEvaluator
@@ -119,6 +155,7 @@ type (
 		opStack     tokenList    // holds operators until their operands reach output queue
 		tokens      <-chan token // tokens received from lexer
 		err         error        // indicates parsing succeeded or describes what failed
+		prev        tokenEnum    // type of the token parsed before this one
 	}
 
 	pathEvaluator struct {
@@ -127,6 +164,22 @@ type (
 		Tokens         tokenList // path criteria, consumed during each evaluation
 		Error          error     // evaluation status, nil on success
 		ContextAtomPtr *Atom
+		tree           *atomTree // tree of the context atom, built when an axis needs it
+		stepAxis       string    // axis of the last location step evaluated
+
+		// atom being tested by a predicate, which relative paths within the
+		// predicate start from.  It is nil outside of predicates.
+		PredicateAtomPtr *Atom
+	}
+
+	// atomTree records the parent and document order of the atoms in a tree,
+	// which axes other than child and descendant need because atoms don't know
+	// their parent.
+	atomTree struct {
+		atoms   []*Atom         // atoms in document order
+		index   map[*Atom]int   // position of each atom in document order
+		ends    []int           // position after the subtree of each atom
+		parents map[*Atom]*Atom // parent of each atom except the root
 	}
 
 	// predicateEvaluator determines which candidate atoms satisfy the
@@ -138,14 +191,18 @@ type (
 	//    /ROOT[@name=NONE]
 	//		/ROOT/UI_1[@data < 2]
 	predicateEvaluator struct {
-		tokens tokenList // predicate criteria, as a list of tokens
-		Error  error     // evaluation status, nil on success
+		tokens tokenList      // predicate criteria, as a list of tokens
+		Error  error          // evaluation status, nil on success
+		pe     *pathEvaluator // evaluates location paths within the predicate
+		path   bool           // evaluating a whole path expression, not a predicate
 
 		Tokens   tokenList // Copy of tokens to consume during evaluation
 		Atoms    []*Atom   // Atoms being evaluated
 		AtomPtr  *Atom     // Atom currently being evaluated from the atom list
 		Position int       // index of the atom in the atom list, starts from 1
 		Count    int       // number of atoms in the atom list
+
+		regexps map[string]*regexp.Regexp // patterns compiled for matches()
 	}
 )
 
@@ -238,6 +295,15 @@ func (s *tokenList) empty() bool {
 // AtomsAtPath returns the set of descendant atoms in which match the given
 // path.
 //
+// Atom names in the path may be given as 0x followed by 8 hex digits, as for
+// names containing characters which can't appear in a path.
+//
+// Steps may use the XPath axes, such as "../NAME" or
+// "following-sibling::NAME", to find atoms relative to those found so far.
+// Atoms are returned in document order. For reverse axes such as
+// ancestor::, positions in a predicate count from the nearest atom, so that
+// "ancestor::*[1]" is the parent.
+//
 // This is shorthand for creating an AtomPath object and calling
 // AtomPath.GetAtoms().  Do it the long way if you plan to perform the path
 // evaluation multiple times, because keeping the compiled AtomPath object
@@ -256,11 +322,37 @@ func (a *Atom) AtomsAtPath(path string) (atoms []*Atom, e error) {
 }
 
 // GetAtoms returns the atoms that match the path, using the given atom as the
-// root.
+// root.  It fails for a path expression that evaluates to a value instead,
+// such as "count(//NODE)".
 func (ap *AtomPath) GetAtoms(root *Atom) (atoms []*Atom, e error) {
+	result, e := ap.evaluator.evaluate(root)
+	if e != nil {
+		return nil, e
+	}
+	if result.Type != ResultNodeSet {
+		return nil, addPathToError(errInvalidPath(fmt.Sprintf("expression evaluates to a %s, not atoms", result.Type)), ap.Path)
+	}
+	return result.Atoms(), nil
+}
+
+// Evaluate returns the value of the path expression, using the given atom as
+// the root.  A location path evaluates to a node set of atoms, as for
+// GetAtoms.  Other expressions evaluate to a boolean, number or string, as
+// for "count(//NODE) > 2", or to a slice of the values of the atoms found by
+// a path ending in data(), name() or type(), as for "//USED/data()".
+//
+// Arithmetic on a node set or slice applies to the value of each atom, so
+// "/ROOT/BVER/data() * 2" doubles each BVER value.
+func (ap *AtomPath) Evaluate(root *Atom) (Result, error) {
 	return ap.evaluator.evaluate(root)
 }
 
+// SelectsAtoms returns true if the path evaluates to a node set of atoms,
+// which GetAtoms returns, rather than to a value.
+func (ap *AtomPath) SelectsAtoms() bool {
+	return ap.evaluator.selectsAtoms()
+}
+
 // newPathEvaluator reads a path string and returns a pathEvaluator object
 // representing the path.
 func newPathEvaluator(path string) (pe *pathEvaluator, err error) {
@@ -286,9 +378,18 @@ func newPredicateEvaluator(pe *pathEvaluator) (pre predicateEvaluator, ok bool)
 		return pre, false
 	}
 
-	// read predicate tokens
+	// read predicate tokens, including those of any predicates nested within
 	var predicateTokens tokenList
-	for pe.nextTokenType() != tokenPredicateStart && !pe.Tokens.empty() {
+	for depth := 0; !pe.Tokens.empty(); {
+		switch pe.nextTokenType() {
+		case tokenPredicateEnd:
+			depth++
+		case tokenPredicateStart:
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
 		predicateTokens.unshift(pe.Tokens.pop())
 	}
 	pe.Tokens.pop() // discard predicate start token
@@ -302,6 +403,7 @@ func newPredicateEvaluator(pe *pathEvaluator) (pre predicateEvaluator, ok bool)
 	// evaluate element set by predicate
 	return predicateEvaluator{
 		tokens: predicateTokens,
+		pe:     pe,
 	}, true
 }
 
@@ -311,13 +413,19 @@ func newPredicateEvaluator(pe *pathEvaluator) (pre predicateEvaluator, ok bool)
 // The candidate atoms must all be made available to the predicateEvaluator at
 // once, because the predicate may refer to individual child atoms by name,
 // requiring them to be evaluated against every other candidate.
+//
+// Relative location paths within the predicate start from each candidate in
+// turn.  The path evaluator's candidate is restored afterwards, since this
+// predicate may be nested in a path within another predicate.
 func (pre *predicateEvaluator) Evaluate(candidates []*Atom) (atoms []*Atom, e error) {
 	Log.Print("predicateEvaluator::Evaluate()  ", pre.tokens, candidates)
+	defer func(a *Atom) { pre.pe.PredicateAtomPtr = a }(pre.pe.PredicateAtomPtr)
 	pre.Atoms = candidates
 	pre.Count = len(candidates)
 	for i, atomPtr := range candidates {
 		pre.Position = i + 1 // XPath convention, indexing starts at 1
 		pre.AtomPtr = atomPtr
+		pre.pe.PredicateAtomPtr = atomPtr
 		pre.Tokens = pre.tokens
 
 		// eval candidate atoms against path+predicate(s)
@@ -335,16 +443,16 @@ func (pre *predicateEvaluator) Evaluate(candidates []*Atom) (atoms []*Atom, e er
 	return
 }
 
-func (pre *predicateEvaluator) getChildValue(atomName string) (v iComparer, ok bool) {
-	for _, a := range pre.AtomPtr.children {
-		if a.Name() != atomName {
-			continue
+// getChildValue returns the children of the atom being evaluated that have
+// the given name, as a node set that compares true if any of their values do.
+// It returns false if there are none, so the name can be taken as a string.
+func (pre *predicateEvaluator) getChildValue(atomName string) (v typeNodeSet, ok bool) {
+	for _, a := range pre.AtomPtr.Children() {
+		if a.Name() == atomName {
+			v = append(v, a)
 		}
-		v = atomValueToiComparerType(a)
-		ok = true
-		break
 	}
-	return
+	return v, len(v) > 0
 }
 
 func atomValueToiComparerType(a *Atom) (v iComparer) {
@@ -376,7 +484,7 @@ func newPathLexer(path string) *lexer {
 
 func lexPath(l *lexer) stateFn {
 	if l.bufferSize() != 0 {
-		l.errorf(fmt.Sprintf(`could not parse "%s"`, l.buffer()))
+		l.errorf(`could not parse "%s"`, l.buffer())
 		return nil
 	}
 	r := l.next()
@@ -386,19 +494,36 @@ func lexPath(l *lexer) stateFn {
 	case r == eof:
 		l.emit(tokenEOF)
 		break
+	case r == '*' && isOperandToken(l.prevTokenType):
+		l.emit(tokenArithmeticOperator)
 	case r == '*':
 		l.emit(tokenNodeTest)
+	case r == '.':
+		lexAbbreviatedStep(l)
+	case r == '"', r == '\'':
+		lexDelimitedString(l)
+	case r == '+', r == '-' && isOperandToken(l.prevTokenType):
+		l.emit(tokenArithmeticOperator)
+	case r == '-' && strings.ContainsRune(numericChars, rune(l.peek())):
+		lexNumberInPath(l)
+	case strings.ContainsRune("=<>!", r):
+		lexComparisonOperator(l)
+	case strings.ContainsRune(numericChars, r) && startsNumber(l):
+		lexNumberInPath(l)
 	case r == '|':
 		l.emit(tokenSetOperator)
 	case r == '/':
 		lexStepSeparatorOrAxis(l)
 	case r == '[':
 		l.emit(tokenPredicateStart)
+		l.depth++
 		return lexPredicate
 	case r == '(':
 		l.emit(tokenLeftParen)
 	case r == ')':
 		l.emit(tokenRightParen)
+	case r == ',':
+		l.emit(tokenComma)
 	case strings.ContainsRune(alphaNumericChars, r):
 		lexBareStringInPath(l)
 	default:
@@ -412,7 +537,7 @@ func lexPath(l *lexer) stateFn {
 
 func lexPredicate(l *lexer) stateFn {
 	if l.bufferSize() != 0 {
-		l.errorf(fmt.Sprintf(`could not parse "%s"`, l.buffer()))
+		l.errorf(`could not parse "%s"`, l.buffer())
 		return nil
 	}
 	r := l.next()
@@ -420,22 +545,34 @@ func lexPredicate(l *lexer) stateFn {
 	case isSpace(r):
 		l.ignore()
 	case r == eof:
-		l.emit(tokenEOF)
-		break
+		return l.errorf(`expected "]" to end predicate`)
 	case r == '@':
 		lexAtomAttribute(l)
 	case r == '"', r == '\'':
 		lexDelimitedString(l)
+	case r == '[':
+		l.emit(tokenPredicateStart)
+		l.depth++
 	case r == ']':
 		l.emit(tokenPredicateEnd)
-		return lexPath
+		if l.depth--; l.depth == 0 {
+			return lexPath
+		}
 	case r == '(':
 		l.emit(tokenLeftParen)
 	case r == ')':
 		l.emit(tokenRightParen)
+	case r == ',':
+		l.emit(tokenComma)
+	case r == '/' && (l.prevTokenType == tokenNodeTest || l.prevTokenType == tokenPredicateEnd || !isOperandToken(l.prevTokenType)):
+		lexStepSeparatorOrAxis(l)
+	case r == '*' && !isOperandToken(l.prevTokenType):
+		l.emit(tokenNodeTest)
 	case r == '+', r == '*':
 		l.emit(tokenArithmeticOperator)
-	case strings.ContainsRune(numericChars, r):
+	case r == '.' && !strings.ContainsRune(digits, l.peek()):
+		lexAbbreviatedStep(l)
+	case strings.ContainsRune(numericChars, r) && startsNumber(l):
 		lexNumberInPath(l)
 	case r == '-':
 		if strings.ContainsRune(numericChars, rune(l.peek())) && !isNumericToken(l.prevTokenType) {
@@ -458,18 +595,62 @@ func lexStepSeparatorOrAxis(l *lexer) stateFn {
 	if l.first() != '/' {
 		return l.errorf(`lexStepSeparatorOrAxis called without leading "/"`)
 	}
-	if l.accept("/") {
-		l.emit(tokenAxisOperator)
+	// "/" or "//" after a step separates it from the next step, otherwise it
+	// starts an absolute path.
+	l.accept("/")
+	if l.prevTokenType == tokenNodeTest || l.prevTokenType == tokenPredicateEnd {
+		l.emit(tokenStepSeparator)
 	} else {
-		if l.prevTokenType == tokenNodeTest || l.prevTokenType == tokenPredicateEnd {
-			l.emit(tokenStepSeparator)
-		} else {
-			l.emit(tokenAxisOperator)
-		}
+		l.emit(tokenAxisOperator)
 	}
 	return lexNodeTest
 }
 
+// lexAbbreviatedStep accepts "." or "..", the abbreviations for the self and
+// parent axes.  The first . is already read.
+func lexAbbreviatedStep(l *lexer) stateFn {
+	l.accept(".")
+	if l.peek() == '.' {
+		return l.errorf("invalid step %q", l.buffer()+".")
+	}
+	l.emit(tokenNodeTest)
+	return lexPath
+}
+
+// lexAxisStep accepts a step with an axis, such as "parent::*" or
+// "following-sibling::NAME".  The leading alphanumeric part of the axis name
+// is already read. The step is emitted as a single node test token.
+func lexAxisStep(l *lexer) stateFn {
+	l.acceptRun(alphaNumericChars + "-")
+	axis := l.buffer()
+	if !l.accept(":") || !l.accept(":") {
+		return l.errorf("expected :: after axis %q", axis)
+	}
+	switch axis {
+	case axisAncestor, axisAncestorOrSelf, axisChild, axisDescendant, axisDescendantOrSelf,
+		axisFollowing, axisFollowingSibling, axisParent, axisPreceding, axisPrecedingSibling, axisSelf:
+	case "attribute", "namespace":
+		return l.errorf("axis %q is not supported, atoms have no attributes or namespaces", axis)
+	default:
+		return l.errorf("unknown axis %q", axis)
+	}
+	if !l.accept("*") && l.acceptRun(alphaNumericChars) == 0 {
+		return l.errorf("expected node test after %q", l.buffer())
+	}
+	if strings.HasSuffix(l.buffer(), "::node") && !acceptNodeTypeTest(l) {
+		return l.errorf(`expected ")" after "node("`)
+	}
+	l.emit(tokenNodeTest)
+	return lexPath
+}
+
+// acceptNodeTypeTest accepts the parentheses of node test "node()", which
+// matches any atom like "*".  It returns false if there is an opening
+// parenthesis without a closing one.
+func acceptNodeTypeTest(l *lexer) bool {
+	return !l.accept("(") || l.accept(")")
+}
+
 func lexNodeTest(l *lexer) stateFn {
 	if l.acceptRun(alphaNumericChars) > 0 {
 		l.emit(tokenNodeTest)
@@ -509,19 +690,26 @@ func lexComparisonOperator(l *lexer) stateFn {
 
 func lexFunctionCall(l *lexer) stateFn {
 	// verify all alphanumeric up to this point
-	if strings.TrimLeft(l.buffer(), alphaNumericChars) != "" {
+	if strings.TrimLeft(l.buffer(), alphaNumericChars+"-") != "" {
 		return l.errorf("invalid function call prefix: %s", l.input)
 	}
 
 	// Warning: if any functions are added with names containing something besides lower
-	// case chars, then update lexBareString to accept those chars as well
+	// case chars and hyphens, then update acceptFunctionName to accept those chars as well
 	switch l.buffer() { // determine function return type
 	case "not":
 		l.emit(tokenFunctionBool)
 	case "true", "false":
 		l.emit(tokenFunctionBool)
-	case "count", "position", "last":
+	case "contains", "starts-with", "ends-with", "matches":
+		l.emit(tokenFunctionBool)
+	case "count", "position", "last", "string-length":
+		l.emit(tokenFunctionNumeric)
+	case "sum", "min", "max", "avg", "floor", "ceiling", "round", "abs":
 		l.emit(tokenFunctionNumeric)
+	case "concat", "substring", "substring-before", "substring-after", "normalize-space", "translate",
+		"upper-case", "lower-case":
+		l.emit(tokenFunctionString)
 	case "name", "type", "data":
 		l.emit(tokenVariable)
 	default:
@@ -572,29 +760,121 @@ func lexDelimitedString(l *lexer) stateFn {
 	return lexPredicate
 }
 
+// acceptFunctionName accepts the rest of a function name containing hyphens,
+// such as "starts-with", if the alphanumeric part already read is followed by
+// one.  A name with hyphens is only a function name if a left paren follows
+// it, otherwise the hyphen may be an axis name or a minus operator.
+func acceptFunctionName(l *lexer) bool {
+	pos := l.pos
+	for l.accept("-") && l.acceptRun("abcdefghijklmnopqrstuvwxyz") > 0 {
+	}
+	if l.pos != pos && l.peek() == '(' {
+		return true
+	}
+	l.pos = pos
+	return false
+}
+
 func lexBareStringInPath(l *lexer) stateFn {
 	l.acceptRun(alphaNumericChars)
+	if acceptFunctionName(l) {
+		return lexFunctionCall(l)
+	}
+	if l.peek() == '-' || l.peek() == ':' {
+		return lexAxisStep(l)
+	}
+	if l.buffer() == "node" && l.peek() == '(' {
+		if !acceptNodeTypeTest(l) {
+			return l.errorf(`expected ")" after "node("`)
+		}
+		l.emit(tokenNodeTest)
+		return lexPath
+	}
 	if l.peek() == '(' {
 		return lexFunctionCall(l)
 	}
-	switch l.buffer() {
-	case "union", "intersect":
+	switch word := l.buffer(); {
+	case word == "union", word == "intersect":
 		l.emit(tokenSetOperator)
+	case !isOperandToken(l.prevTokenType):
+		l.emit(tokenNodeTest)
+	case word == "eq", word == "ne":
+		l.emit(tokenEqualityOperator)
+	case word == "lt", word == "le", word == "gt", word == "ge":
+		l.emit(tokenComparisonOperator)
+	case word == "div", word == "idiv", word == "mod":
+		l.emit(tokenArithmeticOperator)
+	case word == "or", word == "and":
+		l.emit(tokenBooleanOperator)
 	default:
 		l.emit(tokenNodeTest)
 	}
 	return lexPath
 }
 
+// isOperandToken returns true if the token completes an operand, so that
+// what follows it in a path expression must be an operator.  This is how "*"
+// is told apart as multiplication or a node test, and "div" as an operator or
+// an atom name.
+func isOperandToken(tk tokenEnum) bool {
+	switch tk {
+	case tokenNodeTest, tokenPredicateEnd, tokenRightParen, tokenInteger, tokenFloat, tokenHex, tokenString, tokenBareString, tokenVariable:
+		return true
+	}
+	return false
+}
+
+// startsNumber returns true if the digit just read starts a number rather
+// than an atom name.  Names follow a step separator, and a path may start
+// with a name such as 0001, so at the start of the path, digits that have the
+// form of an atom name are taken as one.  So are such digits followed by "/",
+// as in "[0001/LEAF]" where a relative path within a predicate starts.
+func startsNumber(l *lexer) bool {
+	if l.prevTokenType == tokenStepSeparator || l.prevTokenType == tokenAxisOperator {
+		return false
+	}
+	var word = l.input[l.start:]
+	var next byte
+	if i := strings.IndexFunc(word, func(r rune) bool { return !strings.ContainsRune(alphaNumericChars, r) }); i >= 0 {
+		word, next = word[:i], word[i]
+	}
+	isName := len(word) == 4 || (len(word) == 10 && strings.HasPrefix(word, "0x"))
+	if l.prevTokenType == "" {
+		return next == '.' || !isName
+	}
+	return !isName || next != '/'
+}
+
 // lexBareString accepts a non-delimited string of alphanumeric characters.
 // This has more restrictions than a delimited string but is simple and fast to
 // parse.
 // Doesn't handle any escaping, use delimited strings for anything non-trivial.
+//
+// A name that is a step of a location path, such as INFO in "INFO/HOST", or
+// that is the whole predicate, as in "[INFO]", is a node test instead.
 func lexBareStringInPredicate(l *lexer) stateFn {
 	l.acceptRun(alphaNumericChars)
+	if acceptFunctionName(l) {
+		return lexFunctionCall(l)
+	}
+	if strings.HasPrefix(strings.TrimLeft(l.input[l.pos:], alphabetLowerCase+"-"), "::") {
+		return lexAxisStep(l)
+	}
+	if l.buffer() == "node" && l.peek() == '(' {
+		if !acceptNodeTypeTest(l) {
+			return l.errorf(`expected ")" after "node("`)
+		}
+		l.emit(tokenNodeTest)
+		return lexPredicate
+	}
 	if l.peek() == '(' {
 		return lexFunctionCall(l)
 	}
+	if l.prevTokenType == tokenStepSeparator || l.prevTokenType == tokenAxisOperator || l.peek() == '/' || l.peek() == '[' ||
+		l.prevTokenType == tokenPredicateStart && strings.HasPrefix(strings.TrimLeft(l.input[l.pos:], whitespaceChars), "]") {
+		l.emit(tokenNodeTest)
+		return lexPredicate
+	}
 	switch l.buffer() {
 	case "eq", "ne":
 		l.emit(tokenEqualityOperator)
@@ -656,6 +936,7 @@ func (pp *pathParser) receiveTokens() {
 	for {
 		tk := pp.readToken()
 		ok := pp.parseToken(tk)
+		pp.prev = tk.typ
 
 		str := fmt.Sprintf("parseToken(%s, '%v')", tk.typ, tk.value)
 		Log.Printf("    %-35s %35v | %v\n", str, fmt.Sprint(pp.opStack), pp.outputQueue)
@@ -693,7 +974,7 @@ func (pp *pathParser) parseToken(tk token) bool {
 	Log.Printf("      parseToken %q {%s} ", tk.value, tk.typ)
 	switch tk.typ {
 	case tokenError:
-		return pp.errorf(tk.value)
+		return pp.errorf("%s", tk.value)
 	case tokenInteger, tokenHex, tokenFloat, tokenBareString, tokenString, tokenVariable:
 		pp.outputQueue.push(&tk)
 	case tokenPredicateStart:
@@ -704,21 +985,42 @@ func (pp *pathParser) parseToken(tk token) bool {
 		// push to both.  Only the output queue copy will be kept.
 		pp.outputQueue.push(&tk)
 		pp.opStack.push(&tk)
-	case tokenFunctionBool, tokenFunctionNumeric:
+	case tokenFunctionBool, tokenFunctionNumeric, tokenFunctionString:
 		pp.opStack.push(&tk)
+	case tokenComma:
+		// complete the previous function argument
+		pp.moveOperatorsToOutputUntil(func(t token) bool { return t.typ == tokenLeftParen })
 	case tokenNodeTest: // act like an operator with same precedence as //, /
-		pp.moveOperatorsToOutput(token{tokenStepSeparator, "/", 0})
+		pp.moveOperatorsToOutput(token{typ: tokenStepSeparator, value: "/"})
 		pp.outputQueue.push(&tk)
 	case tokenComparisonOperator, tokenArithmeticOperator, tokenEqualityOperator, tokenBooleanOperator, tokenAxisOperator, tokenStepSeparator, tokenSetOperator:
 		pp.moveOperatorsToOutput(tk)
 		pp.opStack.push(&tk)
 	case tokenLeftParen:
+		// A function's arguments follow its left paren in the output queue, so
+		// the paren marks where they end during evaluation.
+		if isFunctionToken(pp.opStack.peek()) {
+			pp.outputQueue.push(&tk)
+		}
+		// name(), type() or data() waits for its path argument, if it has one
+		if pp.prev == tokenVariable {
+			pp.opStack.push(pp.outputQueue.pop())
+		}
 		pp.opStack.push(&tk)
 	case tokenRightParen:
 		pp.moveOperatorsToOutputUntil(func(t token) bool { return t.typ == tokenLeftParen })
 		pp.opStack.pop() // remove the matching LeftParen from the stack
 		if isFunctionToken(pp.opStack.peek()) {
 			pp.outputQueue.push(pp.opStack.pop()) // move completed function call to output
+		} else if next := pp.opStack.peek(); next != nil && next.typ == tokenVariable {
+			// name(path) is queued as the value step path/name()
+			if pp.prev == tokenBareString {
+				pp.outputQueue.peek().typ = tokenNodeTest // path of one step
+			}
+			pp.outputQueue.push(pp.opStack.pop())
+			if pp.prev != tokenLeftParen {
+				pp.outputQueue.push(&token{typ: tokenStepSeparator, value: "/"})
+			}
 		}
 	case tokenPredicateEnd:
 		pp.moveOperatorsToOutputUntil(func(t token) bool { return t.typ == tokenPredicateStart })
@@ -813,13 +1115,10 @@ func (pe *pathEvaluator) errorf(format string, args ...interface{}) bool {
 	return false
 }
 func addPathToError(err error, path string) error {
-	return fmt.Errorf(strings.Join([]string{
-		err.Error(),
-		fmt.Sprintf(" in %q", path),
-	}, ""))
+	return fmt.Errorf("%s in %q", err, path)
 }
 
-func (pe *pathEvaluator) evaluate(atom *Atom) (result []*Atom, e error) {
+func (pe *pathEvaluator) evaluate(atom *Atom) (result Result, e error) {
 	pe.ContextAtomPtr = atom
 	if pe.tokens.empty() {
 		e = errInvalidPath("<empty>")
@@ -828,14 +1127,76 @@ func (pe *pathEvaluator) evaluate(atom *Atom) (result []*Atom, e error) {
 
 	// Special case, otherwise path specifiers may not end with /
 	if len(pe.tokens) == 1 && pe.tokens[0].value == "/" {
-		return []*Atom{pe.ContextAtomPtr}, nil
+		return newResult(typeNodeSet{pe.ContextAtomPtr}), nil
 	}
 
 	pe.Tokens = pe.tokens
+	pe.tree = nil
+	pe.Error = nil
 	Log.Println("pathEvaluator::evaluate() ", pe.Tokens)
-	result = pe.evalElementSet()
-	e = pe.Error
-	return
+	if pe.selectsAtoms() {
+		atoms := pe.evalElementSet()
+		return newResult(typeNodeSet(atoms)), pe.Error
+	}
+
+	// Evaluate other expressions like a predicate of the context atom
+	pre := predicateEvaluator{
+		pe:       pe,
+		path:     true,
+		Tokens:   pe.Tokens,
+		Atoms:    []*Atom{atom},
+		AtomPtr:  atom,
+		Position: 1,
+		Count:    1,
+	}
+	v := pre.evalValue()
+	switch {
+	case pe.Error != nil:
+		return result, pe.Error
+	case pre.Error != nil:
+		return result, addPathToError(pre.Error, pe.Path)
+	case !pre.Tokens.empty():
+		pe.errorf("unexpected %q", pre.Tokens.peek().value)
+		return result, pe.Error
+	}
+	return newResult(v), nil
+}
+
+// selectsAtoms returns true if the path evaluates to a node set, because it
+// is a location path or a set operation on location paths.
+func (pe *pathEvaluator) selectsAtoms() bool {
+	switch pe.tokens.nextType() {
+	case tokenNodeTest, tokenAxisOperator, tokenPredicateEnd, tokenSetOperator:
+		return true
+	case tokenStepSeparator:
+		return !pe.tokens.isValueStep()
+	}
+	return false
+}
+
+// hasAbsolutePathInPredicate returns true if a predicate contains an absolute
+// location path, such as //HOST in "/ROOT/NODE[//HOST = 1]".
+func (s tokenList) hasAbsolutePathInPredicate() bool {
+	var depth int
+	for _, tk := range s {
+		switch tk.typ {
+		case tokenPredicateStart:
+			depth++
+		case tokenPredicateEnd:
+			depth--
+		case tokenAxisOperator:
+			if depth > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isValueStep returns true if the next tokens are a last path step of data(),
+// name() or type(), which selects the values of atoms rather than atoms.
+func (s tokenList) isValueStep() bool {
+	return s.nextType() == tokenStepSeparator && len(s) > 1 && s[len(s)-2].typ == tokenVariable
 }
 
 // Done returns true if this pathEvaluator is done processing.
@@ -861,6 +1222,7 @@ func (pe *pathEvaluator) evalSetOperator() (atoms []*Atom) {
 		return nil
 	}
 
+	defer func() { pe.stepAxis = "" }()
 	switch op.value {
 	case "union", "|":
 		atoms = append(pe.evalElementSet(), pe.evalElementSet()...)
@@ -880,31 +1242,28 @@ func (pe *pathEvaluator) evalSetOperator() (atoms []*Atom) {
 	}
 	return
 }
-func (pe *pathEvaluator) evalAxisOperator() (atoms []*Atom) {
+
+// evalAxisOperator evaluates the / or // at the start of an absolute path,
+// returning the atoms found on the axis of the first step.  The context atom
+// is treated as the only child of a document node, as XPath treats the root
+// element.
+func (pe *pathEvaluator) evalAxisOperator(axis string) (atoms []*Atom) {
 	tk := pe.Tokens.pop()
 	Log.Printf("evalAxisOperator(%q)", tk.value)
-	if tk.typ != tokenAxisOperator && tk.typ != tokenStepSeparator {
+	if tk.typ != tokenAxisOperator {
 		pe.errorf("expected axis operator, got '%v' [%[1]T]", tk.value)
 		return nil
 	}
 
-	//	if pe.nextTokenType() == tokenNodeTest {
-	//		pe.errorf("operator '%s' must be followed element name or *", tk.value)
-	//		return nil
-	//	}
-
 	if tk.value == "//" {
-		atoms = pe.ContextAtomPtr.Descendants()
-		return
-	} else if tk.value == "/" {
-		atoms = []*Atom{pe.ContextAtomPtr}
-		return
+		if axis == axisChild {
+			return pe.ContextAtomPtr.Descendants()
+		}
+		return pe.stepAtoms(axis, pe.ContextAtomPtr.Descendants(), true)
+	} else if axis == axisChild {
+		return []*Atom{pe.ContextAtomPtr}
 	}
-
-	// The empty case is the same as / for this implementation..
-	// because atoms don't know their parent, it's not possible to refer to a
-	// higher-level atom in the tree.
-	return []*Atom{pe.ContextAtomPtr}
+	return pe.stepAtoms(axis, nil, true)
 }
 
 // evaluate path expression starting with a node test.
@@ -916,39 +1275,242 @@ func (pe *pathEvaluator) evalNodeTest() (atoms []*Atom) {
 		pe.errorf("expected node test, got '%v' [%[1]T]", tkNodeTest.value)
 		return nil
 	}
+	axis, test := splitStep(tkNodeTest.value)
+	defer func() { pe.stepAxis = axis }()
 
 	// Get element set to filter
 	if pe.nextTokenType() == tokenStepSeparator {
-		// New path step, so apply nodeTest to the children of whatever atoms are
-		// returned by the path  expression following the step separator operator
-		pe.Tokens.pop()
+		// New path step, so apply the axis to whatever atoms are returned by the
+		// path expression following the step separator operator
+		sep := pe.Tokens.pop()
 		if pe.Tokens.empty() {
-			pe.errorf("expected path elements after /")
+			pe.errorf("expected path elements after %s", sep.value)
 			return nil
 		}
-		for _, a := range pe.evalElementSet() {
-			atoms = append(atoms, a.Children()...)
+		input := pe.evalElementSet()
+		switch {
+		case sep.value == "//" && axis == axisChild:
+			atoms = pe.stepAtoms(axisDescendant, input, false)
+		case sep.value == "//":
+			atoms = pe.stepAtoms(axis, pe.stepAtoms(axisDescendantOrSelf, input, false), false)
+		case axis == axisChild:
+			for _, a := range input {
+				atoms = append(atoms, a.Children()...)
+			}
+		default:
+			atoms = pe.stepAtoms(axis, input, false)
 		}
 	} else if pe.nextTokenType() == tokenAxisOperator {
-		atoms = pe.evalAxisOperator()
-	} else {
+		atoms = pe.evalAxisOperator(axis)
+	} else if pe.PredicateAtomPtr != nil {
+		// the first step of a relative path in a predicate is taken from the
+		// atom being tested
+		atoms = pe.stepAtoms(axis, []*Atom{pe.PredicateAtomPtr}, false)
+	} else if axis == axisChild {
+		// the first step of a relative path names the context atom
 		atoms = append(atoms, pe.ContextAtomPtr)
+	} else {
+		atoms = pe.stepAtoms(axis, []*Atom{pe.ContextAtomPtr}, false)
 	}
 	Log.Printf("evalNodeTest(%q) %v", tkNodeTest.value, atoms)
 
 	// Filter the ElementPtrSlice by name against the node test
-	if tkNodeTest.value == "*" {
+	if test == "*" {
 		return atoms
 	}
 	results := atoms[:0] // overwite elements list while filtering to avoid allocation
 	for _, elt := range atoms {
-		if (*elt).Name() == tkNodeTest.value {
+		if nameMatches(elt.Name(), test) {
 			results = append(results, elt)
 		}
 	}
 	return results
 }
 
+// splitStep returns the axis and node test of a location step, such as
+// "parent" and "*" for "parent::*" or "..".
+func splitStep(step string) (axis, test string) {
+	switch step {
+	case ".":
+		return axisSelf, "*"
+	case "..":
+		return axisParent, "*"
+	}
+	axis, test = axisChild, step
+	if i := strings.Index(step, "::"); i >= 0 {
+		axis, test = step[:i], step[i+2:]
+	}
+	if test == "node()" {
+		test = "*"
+	}
+	return axis, test
+}
+
+// stepAtoms returns the atoms found on the axis of any of the input atoms,
+// without duplicates, in document order.  If fromDocument is true, the atoms
+// found on the axis of the document node containing the context atom are
+// included too.
+func (pe *pathEvaluator) stepAtoms(axis string, input []*Atom, fromDocument bool) (atoms []*Atom) {
+	var tree = pe.atomTree()
+	if len(input) > 1 && (axis == axisFollowing || axis == axisPreceding) {
+		// these axes of one input atom hold those of all the others
+		input = []*Atom{tree.outermost(axis, input)}
+	}
+	var seen = make(map[*Atom]bool)
+	var add = func(found []*Atom) {
+		for _, a := range found {
+			if !seen[a] {
+				seen[a] = true
+				atoms = append(atoms, a)
+			}
+		}
+	}
+	if fromDocument {
+		switch axis {
+		case axisChild:
+			add([]*Atom{pe.ContextAtomPtr})
+		case axisDescendant, axisDescendantOrSelf:
+			add(tree.atoms)
+		}
+	}
+	for _, a := range input {
+		add(tree.axis(axis, a))
+	}
+
+	sort.Slice(atoms, func(i, j int) bool {
+		return tree.index[atoms[i]] < tree.index[atoms[j]]
+	})
+	return atoms
+}
+
+// isReverseAxis returns true for axes that select atoms before the context
+// atom in document order.
+func isReverseAxis(axis string) bool {
+	switch axis {
+	case axisAncestor, axisAncestorOrSelf, axisParent, axisPreceding, axisPrecedingSibling:
+		return true
+	}
+	return false
+}
+
+// atomTree returns the tree of the context atom, building it on first use.
+func (pe *pathEvaluator) atomTree() *atomTree {
+	if pe.tree == nil {
+		pe.tree = newAtomTree(pe.ContextAtomPtr)
+	}
+	return pe.tree
+}
+
+// newAtomTree records the parent and document order of the atoms in the tree
+// under root.
+func newAtomTree(root *Atom) *atomTree {
+	t := &atomTree{
+		atoms:   root.Descendants(),
+		parents: make(map[*Atom]*Atom),
+	}
+	t.index = make(map[*Atom]int, len(t.atoms))
+	for i, a := range t.atoms {
+		t.index[a] = i
+		for _, child := range a.Children() {
+			t.parents[child] = a
+		}
+	}
+	// a subtree ends where the subtree of its last child does
+	t.ends = make([]int, len(t.atoms))
+	for i := len(t.atoms) - 1; i >= 0; i-- {
+		t.ends[i] = i + 1
+		if children := t.atoms[i].Children(); len(children) > 0 {
+			t.ends[i] = t.ends[t.index[children[len(children)-1]]]
+		}
+	}
+	return t
+}
+
+// outermost returns the input atom whose following or preceding axis holds
+// those of all the others.  That is the one whose subtree ends first for the
+// following axis, and the last one in document order for the preceding axis.
+func (t *atomTree) outermost(axis string, input []*Atom) *Atom {
+	var best = input[0]
+	for _, a := range input[1:] {
+		if axis == axisFollowing && t.ends[t.index[a]] < t.ends[t.index[best]] ||
+			axis == axisPreceding && t.index[a] > t.index[best] {
+			best = a
+		}
+	}
+	return best
+}
+
+// axis returns the atoms on the axis of atom a, in document order.
+func (t *atomTree) axis(axis string, a *Atom) (atoms []*Atom) {
+	switch axis {
+	case axisChild:
+		return a.Children()
+	case axisDescendant:
+		return a.Descendants()[1:]
+	case axisDescendantOrSelf:
+		return a.Descendants()
+	case axisSelf:
+		return []*Atom{a}
+	case axisParent:
+		if p, ok := t.parents[a]; ok {
+			atoms = append(atoms, p)
+		}
+	case axisAncestorOrSelf:
+		atoms = append(atoms, a)
+		fallthrough
+	case axisAncestor:
+		for p, ok := t.parents[a]; ok; p, ok = t.parents[p] {
+			atoms = append(atoms, p)
+		}
+		for i, j := 0, len(atoms)-1; i < j; i, j = i+1, j-1 {
+			atoms[i], atoms[j] = atoms[j], atoms[i]
+		}
+	case axisFollowingSibling, axisPrecedingSibling:
+		p, ok := t.parents[a]
+		if !ok {
+			break
+		}
+		siblings := p.Children()
+		for i := range siblings {
+			if siblings[i] != a {
+				continue
+			}
+			if axis == axisFollowingSibling {
+				return siblings[i+1:]
+			}
+			return siblings[:i]
+		}
+	case axisFollowing:
+		// atoms after the end of the subtree of a
+		return t.atoms[t.ends[t.index[a]]:]
+	case axisPreceding:
+		// atoms before a, other than its ancestors, whose subtrees hold a
+		i := t.index[a]
+		for j, b := range t.atoms[:i] {
+			if t.ends[j] <= i {
+				atoms = append(atoms, b)
+			}
+		}
+	}
+	return atoms
+}
+
+// nameMatches returns true if the printable atom name matches the node test.
+// The node test may be "*", or may give the name as 0x followed by 8 hex
+// digits, which allows names containing characters that a path cannot.
+func nameMatches(name, test string) bool {
+	if test == "*" || test == name {
+		return true
+	}
+	if len(test) != 10 || !strings.HasPrefix(test, "0x") {
+		return false
+	}
+	if len(name) == 4 {
+		name = fmt.Sprintf("0x%08X", name)
+	}
+	return strings.EqualFold(test, name)
+}
+
 func (pe *pathEvaluator) evalElementSet() (atoms []*Atom) {
 	Log.Printf("evalElementSet() [%s]'", pe.nextTokenType())
 	if pe.Done() {
@@ -983,18 +1545,47 @@ func (pe *pathEvaluator) evalPredicate() []*Atom {
 	if ok != true {
 		return nil // error is already set by newPredicateEvaluator
 	}
-	atoms, err := pre.Evaluate(pe.evalElementSet())
-	if err != nil {
+	candidates := pe.evalElementSet()
+
+	// Positions on a reverse axis count from the nearest atom, so the
+	// candidates are tested in reverse document order.
+	axis := pe.stepAxis
+	reverse := isReverseAxis(axis)
+	if reverse {
+		candidates = reverseAtoms(candidates)
+	}
+	atoms, err := pre.Evaluate(candidates)
+	switch {
+	case pe.Error != nil:
+		return nil // error is already set by a nested predicate
+	case err != nil:
 		pe.Error = addPathToError(err, pe.Path)
 		return nil
 	}
+	if reverse {
+		atoms = reverseAtoms(atoms)
+	}
+	pe.stepAxis = axis // restore it after paths within the predicate
 	return atoms
 }
 
+// reverseAtoms returns a copy of the atoms in reverse order.
+func reverseAtoms(atoms []*Atom) []*Atom {
+	reversed := make([]*Atom, len(atoms))
+	for i, a := range atoms {
+		reversed[len(atoms)-1-i] = a
+	}
+	return reversed
+}
+
 // errorf is called to record an error state resulting from predicate evaluation.
 func (pre *predicateEvaluator) errorf(format string, args ...interface{}) error {
 	msg := fmt.Sprintf(format, args...)
-	pre.Error = errInvalidPredicate(msg)
+	if pre.path {
+		pre.Error = errInvalidPath(msg)
+	} else {
+		pre.Error = errInvalidPredicate(msg)
+	}
 	return pre.Error
 }
 
@@ -1029,6 +1620,10 @@ Loop:
 			results = append(results, pre.evalFunctionBool())
 		case tokenFunctionNumeric:
 			results = append(results, pre.evalFunctionNumeric())
+		case tokenFunctionString:
+			results = append(results, pre.evalFunctionString())
+		case tokenNodeTest, tokenAxisOperator, tokenStepSeparator, tokenPredicateEnd:
+			results = append(results, pre.evalPath())
 		default:
 			t := pre.Tokens.peek()
 			pre.errorf("unrecognized token '%v'", t.value)
@@ -1055,6 +1650,11 @@ func (pre *predicateEvaluator) evalBoolean() (result iEqualer) {
 		result = pre.evalComparisonOperator().(iEqualer)
 	case tokenFunctionBool:
 		result = pre.evalFunctionBool()
+	case tokenNodeTest, tokenAxisOperator, tokenStepSeparator, tokenPredicateEnd, tokenSetOperator:
+		// a node set or slice is true if it is not empty
+		result = typeBoolean(len(sequenceValues(pre.evalPath())) > 0)
+	case tokenBareString:
+		result = typeBoolean(len(pre.evalChildren()) > 0)
 	default:
 		t := pre.Tokens.peek()
 		pre.errorf("expect boolean, got '%s'", t.value)
@@ -1097,26 +1697,27 @@ func (pre *predicateEvaluator) evalArithmeticOperator() (result iArithmeticker)
 	if pre.Error != nil {
 		return
 	}
-	var err error
+	var fn func(lhs, rhs iArithmeticker) (iArithmeticker, error)
 	switch op.value {
 	case "+":
-		result, err = lhs.Plus(rhs)
+		fn = iArithmeticker.Plus
 	case "-":
-		result, err = lhs.Minus(rhs)
+		fn = iArithmeticker.Minus
 	case "*":
-		result, err = lhs.Multiply(rhs)
+		fn = iArithmeticker.Multiply
 	case "div":
-		result, err = lhs.Divide(rhs)
+		fn = iArithmeticker.Divide
 	case "idiv":
-		result, err = lhs.IntegerDivide(rhs)
+		fn = iArithmeticker.IntegerDivide
 	case "mod":
-		result, err = lhs.Mod(rhs)
+		fn = iArithmeticker.Mod
 	default:
 		pre.errorf("unknown arithmetic operator: %s", op.value)
 		return
 	}
+	result, err := arithmetic(lhs, rhs, fn)
 	if err != nil {
-		pre.errorf(err.Error())
+		pre.errorf("%s", err)
 	}
 	return result
 }
@@ -1138,9 +1739,9 @@ func (pre *predicateEvaluator) evalEqualityOperator() iEqualer {
 	Log.Printf("  evalEqualityOperator() %v = %v", lhs, rhs)
 	switch op.value {
 	case "=", "eq":
-		result = lhs.Equal(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return l.Equal(r) })
 	case "!=", "ne":
-		result = !lhs.Equal(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return !l.Equal(r) })
 	default:
 		pre.errorf("unknown equality operator: %s", op.value)
 		result = false
@@ -1164,13 +1765,13 @@ func (pre *predicateEvaluator) evalComparisonOperator() iEqualer {
 	}
 	switch op.value {
 	case "<", "lt":
-		result = lhs.LessThan(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return l.(iComparer).LessThan(r.(iComparer)) })
 	case ">", "gt":
-		result = lhs.GreaterThan(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return l.(iComparer).GreaterThan(r.(iComparer)) })
 	case "<=", "le":
-		result = lhs.LessThan(rhs) || lhs.Equal(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return l.(iComparer).LessThan(r.(iComparer)) || l.Equal(r) })
 	case ">=", "ge":
-		result = lhs.GreaterThan(rhs) || lhs.Equal(rhs)
+		result = anyPair(lhs, rhs, func(l, r iEqualer) bool { return l.(iComparer).GreaterThan(r.(iComparer)) || l.Equal(r) })
 	default:
 		pre.errorf("unknown comparison operator: %s", op.value)
 		result = false
@@ -1184,16 +1785,16 @@ func (pre *predicateEvaluator) evalNumber() (result iArithmeticker) {
 	ok := true
 	switch pre.nextTokenType() {
 	case tokenInteger, tokenHex:
-		v, err := strconv.ParseInt(pre.Tokens.pop().value, 0, 64)
+		v, err := parseInteger(pre.Tokens.pop().value)
 		if err != nil {
-			pre.errorf(err.Error())
+			pre.errorf("%s", err)
 			return
 		}
-		result = typeInt64(v)
+		result = v
 	case tokenFloat:
 		v, err := strconv.ParseFloat(pre.Tokens.pop().value, 64)
 		if err != nil {
-			pre.errorf(err.Error())
+			pre.errorf("%s", err)
 			return
 		}
 		result = typeFloat64(v)
@@ -1206,10 +1807,17 @@ func (pre *predicateEvaluator) evalNumber() (result iArithmeticker) {
 	case tokenBareString:
 		t := pre.Tokens.pop()
 		if v, ok := pre.getChildValue(t.value); ok {
-			result = v.(iArithmeticker)
+			result = v.values()
 		} else {
 			pre.errorf("expect number, got %s", t.value)
 		}
+	case tokenNodeTest, tokenAxisOperator, tokenStepSeparator, tokenPredicateEnd, tokenSetOperator:
+		switch v := pre.evalPath().(type) {
+		case typeNodeSet:
+			result = v.values()
+		case typeSlice:
+			result = v
+		}
 	default:
 		pre.errorf("value has invalid numeric type: %s", pre.nextTokenType())
 	}
@@ -1219,15 +1827,28 @@ func (pre *predicateEvaluator) evalNumber() (result iArithmeticker) {
 	return result
 }
 
+// parseInteger converts an integer literal to a number. Literals too large for
+// an int64, such as the largest UI64 values, are taken as unsigned.
+func parseInteger(s string) (iArithmeticker, error) {
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err == nil {
+		return typeInt64(v), nil
+	}
+	if u, e := strconv.ParseUint(s, 0, 64); e == nil {
+		return typeUint64(u), nil
+	}
+	return nil, err
+}
+
 // evalNumber evaluates a token that con be compared with =, and returns an iEqualer result.
 func (pre *predicateEvaluator) evaliEqualer() (result iEqualer) {
 	Log.Printf("    evaliEqualer(), Tokens=%v", pre.Tokens)
 	var err error
 	switch pre.nextTokenType() {
 	case tokenInteger, tokenHex:
-		v, e := strconv.ParseInt(pre.Tokens.pop().value, 0, 64)
+		v, e := parseInteger(pre.Tokens.pop().value)
 		err = e
-		result = typeInt64(v)
+		result = v
 	case tokenFloat:
 		v, e := strconv.ParseFloat(pre.Tokens.pop().value, 64)
 		err = e
@@ -1251,13 +1872,20 @@ func (pre *predicateEvaluator) evaliEqualer() (result iEqualer) {
 		result = pre.evalArithmeticOperator()
 	case tokenFunctionBool:
 		result = pre.evalFunctionBool()
+	case tokenFunctionString:
+		result = pre.evalFunctionString()
+	case tokenNodeTest, tokenAxisOperator, tokenStepSeparator, tokenPredicateEnd, tokenSetOperator:
+		result = pre.evalPath()
+	case "":
+		pre.errorf("expected value, got nothing")
+		return
 	default:
 		t := pre.Tokens.pop()
 		pre.errorf("expected iEqualer type, got %q [%s])", t.value, t.typ)
 		return
 	}
 	if err != nil {
-		pre.errorf("failed to convert '%s' to iEqualer value")
+		pre.errorf("failed to convert to iEqualer value: %s", err)
 		return
 	}
 	return result
@@ -1270,9 +1898,9 @@ func (pre *predicateEvaluator) evalComparable() (result iComparer) {
 	var err error
 	switch pre.nextTokenType() {
 	case tokenInteger, tokenHex:
-		v, e := strconv.ParseInt(pre.Tokens.pop().value, 0, 64)
+		v, e := parseInteger(pre.Tokens.pop().value)
 		err = e
-		result = typeInt64(v)
+		result = v
 	case tokenFloat:
 		v, e := strconv.ParseFloat(pre.Tokens.pop().value, 64)
 		err = e
@@ -1292,13 +1920,20 @@ func (pre *predicateEvaluator) evalComparable() (result iComparer) {
 		result = pre.evalFunctionNumeric()
 	case tokenArithmeticOperator:
 		result = pre.evalArithmeticOperator()
+	case tokenFunctionString:
+		result = pre.evalFunctionString()
+	case tokenNodeTest, tokenAxisOperator, tokenStepSeparator, tokenPredicateEnd, tokenSetOperator:
+		result = pre.evalPath()
+	case "":
+		pre.errorf("expected value, got nothing")
+		return
 	default:
 		t := pre.Tokens.pop()
 		pre.errorf("expected comparable type, got %s(%v)", t.typ, t.value)
 		return
 	}
 	if err != nil {
-		pre.errorf("failed to convert '%s' to comparable value")
+		pre.errorf("failed to convert to comparable value: %s", err)
 		return
 	}
 	return result
@@ -1308,38 +1943,115 @@ func (pre *predicateEvaluator) evalVariable() (result iComparer) {
 	if token.typ != tokenVariable {
 		pre.errorf("expected tokenVariable, received type %s", token.typ)
 	}
-	switch token.value {
+	result, ok := atomVariable(pre.AtomPtr, token.value)
+	if !ok {
+		pre.errorf("unknown variable: %s", token.value)
+	}
+	return result
+}
+
+// atomVariable returns the name, type or data of an atom, as given by a
+// variable such as "@name" or "data".  It returns false for an unknown
+// variable.
+func atomVariable(a *Atom, variable string) (result iComparer, ok bool) {
+	switch variable {
 	case "@name", "name":
-		return typeString(pre.AtomPtr.Name())
+		return typeString(a.Name()), true
 	case "@name_hex":
-		return typeString(fmt.Sprintf("0x%08X", pre.AtomPtr.NameAsUint32()))
+		return typeString(fmt.Sprintf("0x%08X", a.NameAsUint32())), true
 	case "@type", "type":
-		return typeString(pre.AtomPtr.Type())
+		return typeString(a.Type()), true
 	case "@data", "data":
 	default:
-		pre.errorf("unknown variable: %s", token.value)
-		return
+		return nil, false
 	}
 
 	// Must get Atom value. Choose concrete type to return.
 	switch {
-	case pre.AtomPtr.Value.IsFloat():
-		v, _ := pre.AtomPtr.Value.Float()
+	case a.Value.IsFloat():
+		v, _ := a.Value.Float()
 		result = typeFloat64(v)
-	case pre.AtomPtr.Value.IsInt():
-		v, _ := pre.AtomPtr.Value.Int()
+	case a.Value.IsInt():
+		v, _ := a.Value.Int()
 		result = typeInt64(v)
-	case pre.AtomPtr.Value.IsUint():
-		v, _ := pre.AtomPtr.Value.Uint()
+	case a.Value.IsUint():
+		v, _ := a.Value.Uint()
 		result = typeUint64(v)
-	case pre.AtomPtr.Value.IsBool():
-		v, _ := pre.AtomPtr.Value.Uint() // use UINT since tk's represented as 0/1
+	case a.Value.IsBool():
+		v, _ := a.Value.Uint() // use UINT since tk's represented as 0/1
 		result = typeUint64(v)
 	default:
-		v, _ := pre.AtomPtr.Value.String()
+		v, _ := a.Value.String()
 		result = typeString(v)
 	}
-	return result
+	return result, true
+}
+
+// evalValue evaluates the next expression and returns its value, of any type.
+func (pre *predicateEvaluator) evalValue() iEqualer {
+	switch pre.nextTokenType() {
+	case tokenBooleanOperator:
+		return pre.evalBooleanOperator()
+	case tokenComparisonOperator:
+		return pre.evalComparisonOperator()
+	}
+	return pre.evaliEqualer()
+}
+
+// evalChildren evaluates a bare name used as a boolean, such as NAME in
+// "//*[NAME or data() > 1]", returning the children with that name.
+func (pre *predicateEvaluator) evalChildren() typeNodeSet {
+	atoms, _ := pre.getChildValue(pre.Tokens.pop().value)
+	return atoms
+}
+
+// evalPath evaluates the location path at the top of the token stack,
+// returning its node set, or a slice of the values of its atoms if the last
+// step is data(), name() or type().
+func (pre *predicateEvaluator) evalPath() iComparer {
+	if !pre.Tokens.isValueStep() {
+		return pre.evalNodeSet()
+	}
+	pre.Tokens.pop() // step separator
+	variable := pre.Tokens.pop()
+	atoms := pre.evalNodeSet()
+	values := make(typeSlice, 0, len(atoms))
+	for _, a := range atoms {
+		v, ok := atomVariable(a, variable.value)
+		if !ok {
+			pre.errorf("unknown variable: %s", variable.value)
+			return values
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// evalNodeSet evaluates the location path at the top of the token stack with
+// the path evaluator, returning the atoms it selects.
+func (pre *predicateEvaluator) evalNodeSet() typeNodeSet {
+	pe := pre.pe
+	saved := pe.Tokens
+	pe.Tokens = pre.Tokens
+	atoms := pe.evalElementSet()
+	pre.Tokens, pe.Tokens = pe.Tokens, saved
+	if pe.Error != nil {
+		pre.Error = pe.Error
+	}
+	return typeNodeSet(atoms)
+}
+
+// endArguments discards the left paren that marks the end of the arguments of
+// a function call, which must have been consumed by now.
+func (pre *predicateEvaluator) endArguments(function string) {
+	if pre.Error != nil {
+		return
+	}
+	if pre.nextTokenType() != tokenLeftParen {
+		pre.errorf("too many arguments for %s()", function)
+		return
+	}
+	pre.Tokens.pop()
 }
 func (pre *predicateEvaluator) evalFunctionBool() iEqualer {
 	var result bool
@@ -1348,6 +2060,8 @@ func (pre *predicateEvaluator) evalFunctionBool() iEqualer {
 		pre.errorf("expected tokenFunctionBool, received type %s", token.typ)
 	}
 	switch token.value {
+	case "contains", "starts-with", "ends-with", "matches":
+		return pre.evalStringFunction(token.value)
 	case "true":
 		result = true
 	case "false":
@@ -1362,6 +2076,7 @@ func (pre *predicateEvaluator) evalFunctionBool() iEqualer {
 	default:
 		pre.errorf("unknown boolean function: %s", token.value)
 	}
+	pre.endArguments(token.value)
 	return typeBoolean(result)
 }
 
@@ -1389,6 +2104,12 @@ func (pre *predicateEvaluator) evalResultsToBool(results []iEqualer) (result boo
 		result = r.Equal(typeUint64(pre.Position))
 	case typeFloat64:
 		result = r.Equal(typeFloat64(pre.Position))
+	case typeString:
+		result = r != ""
+	case typeNodeSet:
+		result = len(r) > 0
+	case typeSlice:
+		result = len(r) > 0
 	default:
 		err = fmt.Errorf("result '%v' has unknown type %[1]T", results[0])
 		return
@@ -1401,19 +2122,362 @@ func (pre *predicateEvaluator) evalFunctionNumeric() (result iArithmeticker) {
 		pre.errorf("expected tokenFunctionNumeric, received type %s", token.typ)
 		return
 	}
-	switch token.value {
-	case "position":
+	switch {
+	case token.value == "position":
 		Log.Printf(`    evalFunctionNumeric("%s") = %d`, token.value, pre.Position)
-		return typeUint64(pre.Position)
-	case "last", "count":
+		result = typeUint64(pre.Position)
+	case token.value == "last", token.value == "count" && pre.nextTokenType() == tokenLeftParen:
 		Log.Printf(`    evalFunctionNumeric("%s") = %d`, token.value, pre.Count)
-		return typeUint64(pre.Count)
+		result = typeUint64(pre.Count)
+	case token.value == "count":
+		result = typeUint64(len(sequenceValues(pre.evalValue())))
+	case token.value == "sum", token.value == "min", token.value == "max", token.value == "avg":
+		return pre.evalAggregate(token.value)
+	case token.value == "floor", token.value == "ceiling", token.value == "round", token.value == "abs":
+		return pre.evalRounding(token.value)
+	case token.value == "string-length":
+		result, _ = pre.evalStringFunction(token.value).(iArithmeticker)
+		return
 	default:
 		pre.errorf("unknown numeric function: %s", token.value)
 	}
+	pre.endArguments(token.value)
 	return
 }
 
+// evalFunctionString evaluates a function of the string library that returns
+// a string.
+func (pre *predicateEvaluator) evalFunctionString() (result iComparer) {
+	token := pre.Tokens.pop()
+	if token.typ != tokenFunctionString {
+		pre.errorf("expected tokenFunctionString, received type %s", token.typ)
+		return
+	}
+	result, _ = pre.evalStringFunction(token.value).(iComparer)
+	return result
+}
+
+// stringFunctionArgs gives the least and greatest number of arguments taken by
+// each function of the string library, with -1 for no limit.
+var stringFunctionArgs = map[string][2]int{
+	"concat":           {2, -1},
+	"contains":         {2, 2},
+	"ends-with":        {2, 2},
+	"lower-case":       {1, 1},
+	"matches":          {2, 3},
+	"normalize-space":  {0, 1},
+	"starts-with":      {2, 2},
+	"string-length":    {0, 1},
+	"substring":        {2, 3},
+	"substring-after":  {2, 2},
+	"substring-before": {2, 2},
+	"translate":        {3, 3},
+	"upper-case":       {1, 1},
+}
+
+// evalStringFunction evaluates the arguments of a function of the string
+// library, and returns its result.  Arguments are converted to strings as by
+// XPath's string() function, so a node set gives the value of its first atom.
+// Functions that take an optional string use the data of the atom being
+// evaluated without one.
+func (pre *predicateEvaluator) evalStringFunction(function string) iEqualer {
+	var args []string
+	var values = pre.evalArguments()
+	if n := stringFunctionArgs[function]; len(values) < n[0] || (n[1] >= 0 && len(values) > n[1]) {
+		pre.errorf("%s() takes %s, got %d", function, describeArgs(n[0], n[1]), len(values))
+	}
+	if pre.Error != nil {
+		return nil
+	}
+	for _, v := range values {
+		args = append(args, stringValue(v))
+	}
+	if len(args) == 0 {
+		s, _ := pre.AtomPtr.Value.String()
+		args = append(args, s)
+	}
+
+	switch function {
+	case "concat":
+		return typeString(strings.Join(args, ""))
+	case "contains":
+		return typeBoolean(strings.Contains(args[0], args[1]))
+	case "starts-with":
+		return typeBoolean(strings.HasPrefix(args[0], args[1]))
+	case "ends-with":
+		return typeBoolean(strings.HasSuffix(args[0], args[1]))
+	case "lower-case":
+		return typeString(strings.ToLower(args[0]))
+	case "upper-case":
+		return typeString(strings.ToUpper(args[0]))
+	case "normalize-space":
+		return typeString(strings.Join(strings.Fields(args[0]), " "))
+	case "string-length":
+		return typeUint64(len([]rune(args[0])))
+	case "substring-before":
+		if i := strings.Index(args[0], args[1]); i >= 0 {
+			return typeString(args[0][:i])
+		}
+		return typeString("")
+	case "substring-after":
+		if i := strings.Index(args[0], args[1]); i >= 0 {
+			return typeString(args[0][i+len(args[1]):])
+		}
+		return typeString("")
+	case "substring":
+		return substring(args[0], values[1:])
+	case "translate":
+		return typeString(translate(args[0], []rune(args[1]), []rune(args[2])))
+	case "matches":
+		return pre.matches(args[0], args[1:])
+	}
+	pre.errorf("unknown string function: %s", function)
+	return nil
+}
+
+// evalArguments evaluates the arguments of a function call, down to the left
+// paren that marks their end, and returns them in order.
+func (pre *predicateEvaluator) evalArguments() (args []iEqualer) {
+	for pre.nextTokenType() != tokenLeftParen {
+		if pre.Tokens.empty() || pre.Error != nil {
+			return nil
+		}
+		args = append([]iEqualer{pre.evalValue()}, args...)
+	}
+	pre.Tokens.pop()
+	return args
+}
+
+// describeArgs describes a number of function arguments, for error messages.
+func describeArgs(least, most int) string {
+	switch {
+	case least == most && least == 1:
+		return "1 argument"
+	case least == most:
+		return fmt.Sprintf("%d arguments", least)
+	case most < 0 && least == 1:
+		return "at least 1 argument"
+	case most < 0:
+		return fmt.Sprintf("at least %d arguments", least)
+	}
+	return fmt.Sprintf("%d to %d arguments", least, most)
+}
+
+// stringValue returns a value as a string, as XPath's string() function does.
+// A node set or slice gives its first value, or "" if it is empty.
+func stringValue(v iEqualer) string {
+	switch s := v.(type) {
+	case typeNodeSet:
+		if len(s) == 0 {
+			return ""
+		}
+		str, _ := s[0].Value.String()
+		return str
+	case typeSlice:
+		if len(s) == 0 {
+			return ""
+		}
+		return formatValue(s[0])
+	}
+	return formatValue(v)
+}
+
+// numberValue returns a value as a number, as XPath's number() function does.
+// It is NaN for a value that is not a number.
+func numberValue(v iEqualer) float64 {
+	if values := sequenceValues(v); len(values) > 0 {
+		v = values[0]
+	}
+	switch n := v.(type) {
+	case typeFloat64:
+		return float64(n)
+	case typeInt64:
+		return float64(n)
+	case typeUint64:
+		return float64(n)
+	case typeString:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(string(n)), 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// substring returns the characters of s from a start position counting from
+// 1, up to an optional length, with both rounded as by XPath's substring().
+func substring(s string, bounds []iEqualer) typeString {
+	var first = roundHalfUp(numberValue(bounds[0]))
+	var last = math.Inf(1)
+	if len(bounds) > 1 {
+		last = first + roundHalfUp(numberValue(bounds[1]))
+	}
+	var result []rune
+	for i, r := range []rune(s) {
+		if p := float64(i + 1); p >= first && p < last {
+			result = append(result, r)
+		}
+	}
+	return typeString(result)
+}
+
+// roundHalfUp rounds to the nearest integer, and rounds halves up as XPath
+// does.
+func roundHalfUp(f float64) float64 {
+	return math.Floor(f + 0.5)
+}
+
+// translate replaces each character of s found in from with the character at
+// the same position in to, or removes it if to is shorter.
+func translate(s string, from, to []rune) string {
+	var index = make(map[rune]int) // first position of each character in from
+	for i := len(from) - 1; i >= 0; i-- {
+		index[from[i]] = i
+	}
+	var result []rune
+	for _, r := range s {
+		i, ok := index[r]
+		switch {
+		case !ok:
+			result = append(result, r)
+		case i < len(to):
+			result = append(result, to[i])
+		}
+	}
+	return string(result)
+}
+
+// matches returns true if s matches the regular expression given by args,
+// with optional flags "i", "m" and "s" as for regexp syntax.  Patterns are
+// compiled once for each predicate.
+func (pre *predicateEvaluator) matches(s string, args []string) iEqualer {
+	var pattern = args[0]
+	if len(args) > 1 && args[1] != "" {
+		if strings.Trim(args[1], "ims") != "" {
+			pre.errorf("invalid flags %q for matches()", args[1])
+			return nil
+		}
+		pattern = "(?" + args[1] + ")" + pattern
+	}
+	re, ok := pre.regexps[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			pre.errorf("invalid regular expression %q: %s", args[0], err)
+			return nil
+		}
+		if pre.regexps == nil {
+			pre.regexps = make(map[string]*regexp.Regexp)
+		}
+		pre.regexps[pattern] = re
+	}
+	return typeBoolean(re.MatchString(s))
+}
+
+// evalAggregate returns the sum, least, greatest or mean of the values of the
+// arguments of sum(), min(), max() or avg(), which may be node sets, slices or
+// numbers.  The sum of no values is 0, and their least, greatest and mean
+// are NaN.
+func (pre *predicateEvaluator) evalAggregate(function string) (result iArithmeticker) {
+	var args = pre.evalArguments()
+	if pre.Error == nil && len(args) == 0 {
+		pre.errorf("%s() takes %s, got 0", function, describeArgs(1, -1))
+	}
+	if pre.Error != nil {
+		return nil
+	}
+	var values []iArithmeticker
+	for _, arg := range args {
+		for _, v := range sequenceValues(arg) {
+			n, ok := v.(iArithmeticker)
+			if !ok {
+				pre.errorf("%s() expects numbers, got %q", function, formatValue(v))
+				return nil
+			}
+			values = append(values, n)
+		}
+	}
+	if len(values) == 0 && function != "sum" {
+		return typeFloat64(math.NaN())
+	}
+
+	var err error
+	switch function {
+	case "min", "max":
+		result = values[0]
+		for _, v := range values[1:] {
+			if function == "min" && v.LessThan(result) || function == "max" && v.GreaterThan(result) {
+				result = v
+			}
+		}
+		return result
+	case "avg":
+		result = typeFloat64(0) // a float sum of integers does not overflow
+	default:
+		result = typeUint64(0)
+	}
+	for _, v := range values {
+		if result, err = result.Plus(v); err != nil {
+			pre.errorf("%s", err)
+			return nil
+		}
+	}
+	if function == "avg" {
+		result, _ = result.Divide(typeUint64(len(values)))
+	}
+	return result
+}
+
+// evalRounding returns the result of floor(), ceiling(), round() or abs() for
+// a number, or for each value of a node set or slice.  Integers are already
+// rounded, and abs() of a negative integer is unsigned.
+func (pre *predicateEvaluator) evalRounding(function string) (result iArithmeticker) {
+	var args = pre.evalArguments()
+	if pre.Error == nil && len(args) != 1 {
+		pre.errorf("%s() takes %s, got %d", function, describeArgs(1, 1), len(args))
+	}
+	if pre.Error != nil {
+		return nil
+	}
+	var fn = func(v iArithmeticker) (iArithmeticker, error) { return round(function, v), nil }
+	var err error
+	switch arg := args[0].(type) {
+	case typeNodeSet:
+		result, err = arg.values().apply(fn)
+	case typeSlice:
+		result, err = arg.apply(fn)
+	case iArithmeticker:
+		result = round(function, arg)
+	default:
+		err = fmt.Errorf("%s() expects a number, got %q", function, formatValue(arg))
+	}
+	if err != nil {
+		pre.errorf("%s", err)
+	}
+	return result
+}
+
+// round applies floor(), ceiling(), round() or abs() to a number.
+func round(function string, v iArithmeticker) iArithmeticker {
+	switch n := v.(type) {
+	case typeFloat64:
+		switch function {
+		case "floor":
+			return typeFloat64(math.Floor(float64(n)))
+		case "ceiling":
+			return typeFloat64(math.Ceil(float64(n)))
+		case "round":
+			return typeFloat64(roundHalfUp(float64(n)))
+		case "abs":
+			return typeFloat64(math.Abs(float64(n)))
+		}
+	case typeInt64:
+		if function == "abs" && n < 0 {
+			return typeUint64(-uint64(n))
+		}
+	}
+	return v
+}
+
 // Implement a small type system with type coercion for operators
 type (
 	typeInt64   int64
@@ -1421,6 +2485,8 @@ type (
 	typeFloat64 float64
 	typeString  string
 	typeBoolean bool
+	typeNodeSet []*Atom     // atoms selected by a location path
+	typeSlice   []iComparer // values, such as those of the atoms selected by a path
 
 	iEqualer interface {
 		Equal(other iEqualer) bool
@@ -1710,11 +2776,11 @@ func (v typeFloat64) Divide(other iArithmeticker) (result iArithmeticker, err er
 func (v typeFloat64) IntegerDivide(other iArithmeticker) (result iArithmeticker, err error) {
 	switch other := other.(type) {
 	case typeFloat64:
-		result = typeInt64(int64(v) / int64(other))
+		result, err = floatIntegerDivide(float64(v), float64(other))
 	case typeInt64:
-		result = typeInt64(int64(v) / int64(other))
+		result, err = floatIntegerDivide(float64(v), float64(other))
 	case typeUint64:
-		result = typeInt64(int64(v) / int64(other))
+		result, err = floatIntegerDivide(float64(v), float64(other))
 	default:
 		err = fmt.Errorf("integer division not supported for type %T, value '%[1]v'", other)
 	}
@@ -1734,13 +2800,13 @@ func (v typeFloat64) Mod(other iArithmeticker) (result iArithmeticker, err error
 	return
 }
 func (v typeUint64) Plus(other iArithmeticker) (result iArithmeticker, err error) {
-	switch other := other.(type) {
+	switch o := other.(type) {
 	case typeFloat64:
-		result = typeFloat64(v) + other
-	case typeInt64:
-		result = typeInt64(v) + other
+		result = typeFloat64(v) + o
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, o, (*big.Int).Add)
 	default:
-		result = v + other.(typeUint64)
+		err = fmt.Errorf("addition not supported for type %T value '%[1]v'", other)
 	}
 	return
 }
@@ -1748,10 +2814,10 @@ func (v typeUint64) Minus(other iArithmeticker) (result iArithmeticker, err erro
 	switch o := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(v) - o
-	case typeInt64:
-		result = typeInt64(v) - o
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, o, (*big.Int).Sub)
 	default:
-		result = v - o.(typeUint64)
+		err = fmt.Errorf("subtraction not supported for type %T value '%[1]v'", other)
 	}
 	return
 }
@@ -1759,10 +2825,10 @@ func (v typeUint64) Multiply(other iArithmeticker) (result iArithmeticker, err e
 	switch o := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(v) * o
-	case typeInt64:
-		result = typeInt64(v) * o
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, o, (*big.Int).Mul)
 	default:
-		result = v * o.(typeUint64)
+		err = fmt.Errorf("multiplication not supported for type %T value '%[1]v'", other)
 	}
 	return
 }
@@ -1782,11 +2848,9 @@ func (v typeUint64) Divide(other iArithmeticker) (result iArithmeticker, err err
 func (v typeUint64) IntegerDivide(other iArithmeticker) (result iArithmeticker, err error) {
 	switch o := other.(type) {
 	case typeFloat64:
-		result = typeInt64(int64(v) / int64(o))
-	case typeInt64:
-		result = typeInt64(int64(v) / int64(o))
-	case typeUint64:
-		result = typeUint64(uint64(v) / uint64(o))
+		result, err = floatIntegerDivide(float64(v), float64(o))
+	case typeInt64, typeUint64:
+		result, err = integerDivision(v, o, (*big.Int).Quo)
 	default:
 		err = fmt.Errorf("integer division not supported for type %T value'%[1]v'", other)
 	}
@@ -1796,10 +2860,8 @@ func (v typeUint64) Mod(other iArithmeticker) (result iArithmeticker, err error)
 	switch o := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(math.Mod(float64(v), float64(o)))
-	case typeInt64:
-		result = typeInt64(int64(v) % int64(o))
-	case typeUint64:
-		result = typeUint64(uint64(v) % uint64(o))
+	case typeInt64, typeUint64:
+		result, err = integerDivision(v, o, (*big.Int).Rem)
 	default:
 		err = fmt.Errorf("modulus not supported for type %T value'%[1]v'", other)
 	}
@@ -1809,14 +2871,8 @@ func (v typeInt64) Plus(other iArithmeticker) (result iArithmeticker, err error)
 	switch o := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(float64(v) + float64(o))
-	case typeInt64:
-		result = typeInt64(int64(v) + int64(o))
-	case typeUint64:
-		if int64(v) < 0 {
-			result = typeInt64(int64(v) + int64(o))
-		} else {
-			result = typeUint64(uint64(v) + uint64(o))
-		}
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, o, (*big.Int).Add)
 	default:
 		err = fmt.Errorf("integer addition not supported for type %T value '%[1]v'", other)
 	}
@@ -1826,14 +2882,8 @@ func (v typeInt64) Minus(other iArithmeticker) (result iArithmeticker, err error
 	switch other := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(float64(v) - float64(other))
-	case typeInt64:
-		result = typeInt64(int64(v) - int64(other))
-	case typeUint64:
-		if v < 0 {
-			result = typeInt64(int64(v) - int64(other))
-		} else {
-			result = typeUint64(uint64(v) - uint64(other))
-		}
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, other, (*big.Int).Sub)
 	default:
 		err = fmt.Errorf("subtraction not supported for type %T value '%[1]v'", other)
 	}
@@ -1843,27 +2893,19 @@ func (v typeInt64) Multiply(other iArithmeticker) (result iArithmeticker, err er
 	switch other := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(float64(v) * float64(other))
-	case typeInt64:
-		result = typeInt64(int64(v) * int64(other))
-	case typeUint64:
-		if v < 0 {
-			result = typeInt64(int64(v) * int64(other))
-		} else {
-			result = typeUint64(uint64(v) * uint64(other))
-		}
+	case typeInt64, typeUint64:
+		result, err = integerArithmetic(v, other, (*big.Int).Mul)
 	default:
-		err = fmt.Errorf("subtraction not supported for type %T value '%[1]v'", other)
+		err = fmt.Errorf("multiplication not supported for type %T value '%[1]v'", other)
 	}
 	return
 }
 func (v typeInt64) IntegerDivide(other iArithmeticker) (result iArithmeticker, err error) {
 	switch other := other.(type) {
 	case typeFloat64:
-		result = typeInt64(int64(v) / int64(other))
-	case typeInt64:
-		result = typeInt64(int64(v) / int64(other))
-	case typeUint64:
-		result = typeInt64(int64(v) / int64(other))
+		result, err = floatIntegerDivide(float64(v), float64(other))
+	case typeInt64, typeUint64:
+		result, err = integerDivision(v, other, (*big.Int).Quo)
 	default:
 		err = fmt.Errorf("integer division not supported for type %T value '%[1]v'", other)
 	}
@@ -1886,19 +2928,64 @@ func (v typeInt64) Mod(other iArithmeticker) (result iArithmeticker, err error)
 	switch o := other.(type) {
 	case typeFloat64:
 		result = typeFloat64(math.Mod(float64(v), float64(o)))
-	case typeInt64:
-		result = typeInt64(int64(v) % int64(o))
-	case typeUint64:
-		if v < 0 {
-			result = typeInt64(int64(v) % int64(o))
-		} else {
-			result = typeUint64(uint64(v) % uint64(o))
-		}
+	case typeInt64, typeUint64:
+		result, err = integerDivision(v, o, (*big.Int).Rem)
 	default:
 		err = fmt.Errorf("modulus not supported for type %T value '%[1]v'", other)
 	}
 	return
 }
+
+// integerArithmetic applies an operator to two integers, and returns the
+// exact result.  The result is unsigned if both integers are, and otherwise
+// signed unless it only fits in an unsigned integer.  A result that fits in
+// neither is an error, rather than wrapping around.
+func integerArithmetic(lhs, rhs iArithmeticker, op func(z, x, y *big.Int) *big.Int) (iArithmeticker, error) {
+	var z = op(new(big.Int), bigInt(lhs), bigInt(rhs))
+	_, lhsUnsigned := lhs.(typeUint64)
+	_, rhsUnsigned := rhs.(typeUint64)
+	switch {
+	case lhsUnsigned && rhsUnsigned && z.IsUint64():
+		return typeUint64(z.Uint64()), nil
+	case z.IsInt64():
+		return typeInt64(z.Int64()), nil
+	case z.IsUint64():
+		return typeUint64(z.Uint64()), nil
+	}
+	return nil, fmt.Errorf("integer overflow, result %s is out of range", z)
+}
+
+// integerDivision is integerArithmetic for idiv and mod, which truncate
+// towards zero as big.Int's Quo and Rem do.  Division by zero is an error.
+func integerDivision(lhs, rhs iArithmeticker, op func(z, x, y *big.Int) *big.Int) (iArithmeticker, error) {
+	if bigInt(rhs).Sign() == 0 {
+		return nil, fmt.Errorf("integer division by zero")
+	}
+	return integerArithmetic(lhs, rhs, op)
+}
+
+// bigInt returns the value of a typeInt64 or typeUint64.
+func bigInt(v iArithmeticker) *big.Int {
+	if u, ok := v.(typeUint64); ok {
+		return new(big.Int).SetUint64(uint64(u))
+	}
+	return big.NewInt(int64(v.(typeInt64)))
+}
+
+// floatIntegerDivide returns x idiv y where either is a float, as the integer
+// part of their quotient.
+func floatIntegerDivide(x, y float64) (iArithmeticker, error) {
+	var q = math.Trunc(x / y)
+	switch {
+	case y == 0:
+		return nil, fmt.Errorf("integer division by zero")
+	case math.IsNaN(q) || q < math.MinInt64 || q >= math.MaxInt64:
+		return nil, fmt.Errorf("integer overflow, result of %s idiv %s is out of range",
+			formatValue(typeFloat64(x)), formatValue(typeFloat64(y)))
+	}
+	return typeInt64(q), nil
+}
+
 func (v typeBoolean) Equal(other iEqualer) bool {
 	switch o := other.(type) {
 	case typeBoolean:
@@ -1917,6 +3004,149 @@ func (v typeBoolean) Equal(other iEqualer) bool {
 		return false
 	}
 }
+
+// Node sets and slices are sequences of values.  As in XPath, comparing a
+// sequence is true if comparing any of its values is true, and arithmetic on a
+// slice applies to each of its values.
+
+// sequenceValues returns the values of a node set or slice, or the value
+// itself if it is not a sequence.
+func sequenceValues(v iEqualer) (values []iEqualer) {
+	switch s := v.(type) {
+	case typeNodeSet:
+		for _, x := range s.values() {
+			values = append(values, x)
+		}
+	case typeSlice:
+		for _, x := range s {
+			values = append(values, x)
+		}
+	default:
+		values = append(values, v)
+	}
+	return values
+}
+
+// values returns the values of the atoms of a node set.
+func (v typeNodeSet) values() typeSlice {
+	var values = make(typeSlice, 0, len(v))
+	for _, a := range v {
+		values = append(values, atomValueToiComparerType(a))
+	}
+	return values
+}
+
+// isSequence returns true for node sets and slices.
+func isSequence(v iEqualer) bool {
+	switch v.(type) {
+	case typeNodeSet, typeSlice:
+		return true
+	}
+	return false
+}
+
+// anyPair returns true if the test is true for any pair of values from lhs
+// and rhs.  A sequence compared with a boolean is true if it is not empty.
+func anyPair(lhs, rhs iEqualer, test func(l, r iEqualer) bool) bool {
+	if _, ok := rhs.(typeBoolean); ok && isSequence(lhs) {
+		lhs = typeBoolean(len(sequenceValues(lhs)) > 0)
+	}
+	if _, ok := lhs.(typeBoolean); ok && isSequence(rhs) {
+		rhs = typeBoolean(len(sequenceValues(rhs)) > 0)
+	}
+	for _, l := range sequenceValues(lhs) {
+		for _, r := range sequenceValues(rhs) {
+			if test(l, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// arithmetic applies an operator to a pair of numbers, or to each value of a
+// slice.  A slice of a single value is taken as that value when both operands
+// are slices, so that "USED div TOTL" divides the values of single atoms.
+func arithmetic(lhs, rhs iArithmeticker, op func(lhs, rhs iArithmeticker) (iArithmeticker, error)) (iArithmeticker, error) {
+	l, lok := lhs.(typeSlice)
+	r, rok := rhs.(typeSlice)
+	if n, ok := l.single(); lok && rok && ok {
+		lhs, lok = n, false
+	} else if n, ok := r.single(); lok && rok && ok {
+		rhs, rok = n, false
+	}
+	switch {
+	case lok && rok:
+		return nil, fmt.Errorf("arithmetic on two sequences is not supported")
+	case lok:
+		return l.apply(func(v iArithmeticker) (iArithmeticker, error) { return op(v, rhs) })
+	case rok:
+		return r.apply(func(v iArithmeticker) (iArithmeticker, error) { return op(lhs, v) })
+	}
+	return op(lhs, rhs)
+}
+
+// single returns the value of a slice of one number.
+func (v typeSlice) single() (n iArithmeticker, ok bool) {
+	if len(v) == 1 {
+		n, ok = v[0].(iArithmeticker)
+	}
+	return n, ok
+}
+
+// apply returns the slice of results of fn for each value of the slice.
+func (v typeSlice) apply(fn func(v iArithmeticker) (iArithmeticker, error)) (iArithmeticker, error) {
+	var results = make(typeSlice, 0, len(v))
+	for _, x := range v {
+		n, ok := x.(iArithmeticker)
+		if !ok {
+			return nil, fmt.Errorf("expected numeric value, got %q", x)
+		}
+		result, err := fn(n)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+func (v typeNodeSet) Equal(other iEqualer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.Equal(r) })
+}
+func (v typeNodeSet) LessThan(other iComparer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.(iComparer).LessThan(r.(iComparer)) })
+}
+func (v typeNodeSet) GreaterThan(other iComparer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.(iComparer).GreaterThan(r.(iComparer)) })
+}
+func (v typeSlice) Equal(other iEqualer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.Equal(r) })
+}
+func (v typeSlice) LessThan(other iComparer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.(iComparer).LessThan(r.(iComparer)) })
+}
+func (v typeSlice) GreaterThan(other iComparer) bool {
+	return anyPair(v, other, func(l, r iEqualer) bool { return l.(iComparer).GreaterThan(r.(iComparer)) })
+}
+func (v typeSlice) Plus(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.Plus)
+}
+func (v typeSlice) Minus(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.Minus)
+}
+func (v typeSlice) Multiply(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.Multiply)
+}
+func (v typeSlice) Divide(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.Divide)
+}
+func (v typeSlice) IntegerDivide(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.IntegerDivide)
+}
+func (v typeSlice) Mod(other iArithmeticker) (iArithmeticker, error) {
+	return arithmetic(v, other, iArithmeticker.Mod)
+}
+
 func isNumericToken(tk tokenEnum) bool {
 	return tk == tokenInteger || tk == tokenFloat
 }
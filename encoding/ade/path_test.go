@@ -197,6 +197,10 @@ func TestAtomsAtPath(t *testing.T) {
 		PathTest{TestAtom1, "/ROOT/0003", []string{"0003:CONT:"}, nil},
 		PathTest{TestAtom1, "0001", zero, nil},
 
+		// Names may also be given as hex, for names which can't be written in a path
+		PathTest{TestAtom1, "/0x524F4F54/0x30303032", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0x30303032/0x4c454146[3]", []string{"LEAF:UI32:6"}, nil},
+
 		// Multiple atoms can be found from same branch
 		PathTest{TestAtom1, "ROOT/0001/LEAF", []string{
 			"LEAF:UI32:1", "LEAF:UI32:2", "LEAF:UI32:3"}, nil},
@@ -310,6 +314,55 @@ func TestAtomsAtPath(t *testing.T) {
 		}, nil},
 		PathTest{TestAtom1, "/ROOT[@name=NONE]", []string{}, nil},
 
+		// "//" within a path selects descendants of the preceding step
+		PathTest{TestAtom1, "/ROOT/0002//LEAF", []string{"LEAF:UI32:4", "LEAF:UI32:5", "LEAF:UI32:6"}, nil},
+		PathTest{TestAtom1, "/ROOT//LEAF[@data > 7]", []string{"LEAF:UI32:8", "LEAF:UI32:9"}, nil},
+		PathTest{TestAtom1, "/ROOT/*[3]//*", []string{"LEAF:UI32:7", "LEAF:UI32:8", "LEAF:UI32:9"}, nil},
+
+		// === Axis testing.
+		// Reverse axes return atoms in document order, but positions in their
+		// predicates count from the nearest atom.
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/..", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF/..", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*/LEAF[@data = 5]/../../0001", []string{"0001:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*/LEAF/parent::node()", []string{"0001:CONT:", "0002:CONT:", "0003:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/parent::0001", zero, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/ancestor::*", []string{"ROOT:CONT:", "0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/ancestor::*[last()]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/ancestor::*[1]", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/ancestor-or-self::*", []string{"ROOT:CONT:", "0002:CONT:", "LEAF:UI32:5"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/following-sibling::*", []string{"0003:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/preceding-sibling::*", []string{"0001:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/GIDV/preceding-sibling::*", []string{"BVER:UI32:4", "BTIM:UI64:1484723582627327"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[3]/preceding-sibling::LEAF", []string{"LEAF:UI32:4", "LEAF:UI32:5"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[3]/preceding-sibling::LEAF[1]", []string{"LEAF:UI32:5"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[3]/preceding-sibling::LEAF[@data > 0][2]", []string{"LEAF:UI32:4"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/following::LEAF", []string{"LEAF:UI32:6", "LEAF:UI32:7", "LEAF:UI32:8", "LEAF:UI32:9"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/preceding::*", []string{"0001:CONT:", "LEAF:UI32:1", "LEAF:UI32:2", "LEAF:UI32:3", "LEAF:UI32:4"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/LEAF[2]/preceding::*[2]", []string{"LEAF:UI32:3"}, nil},
+		PathTest{TestAtom1, "/ROOT/*/LEAF[@data > 1]/following::LEAF", []string{"LEAF:UI32:3", "LEAF:UI32:4", "LEAF:UI32:5", "LEAF:UI32:6", "LEAF:UI32:7", "LEAF:UI32:8", "LEAF:UI32:9"}, nil},
+		PathTest{TestAtom1, "/ROOT/*/LEAF[@data < 8]/preceding::LEAF", []string{"LEAF:UI32:1", "LEAF:UI32:2", "LEAF:UI32:3", "LEAF:UI32:4", "LEAF:UI32:5", "LEAF:UI32:6"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/self::0002", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/0002/self::0001", zero, nil},
+		PathTest{TestAtom1, "/ROOT/0002/.", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/child::0002/descendant::*", []string{"LEAF:UI32:4", "LEAF:UI32:5", "LEAF:UI32:6"}, nil},
+		PathTest{TestAtom1, "/ROOT/0003/descendant-or-self::*", []string{"0003:CONT:", "LEAF:UI32:7", "LEAF:UI32:8", "LEAF:UI32:9"}, nil},
+		PathTest{TestAtom1, "/descendant::0002", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "//LEAF[@data = 5]/ancestor::0002", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/parent::*", zero, nil},
+		PathTest{TestAtom1, "./0001", []string{"0001:CONT:"}, nil},
+		PathTest{TestAtom1, "descendant::LEAF[1]", []string{"LEAF:UI32:1"}, nil},
+		PathTest{TestAtom1, "..", zero, nil},
+		PathTest{TestAtomGINF, "/GINF/*/AVTP[@data = CSTR]/following-sibling::AVAL/0x00000001", []string{
+			`0x00000001:CSTR:"{OID='2.16.124.113590.3.1.3.3.1'}"`,
+			`0x00000001:CSTR:"10.4.0"`,
+		}, nil},
+		PathTest{TestAtom1, "/ROOT/junk::LEAF", zero, errInvalidPath(`unknown axis "junk" in "/ROOT/junk::LEAF"`)},
+		PathTest{TestAtom1, "/ROOT/attribute::LEAF", zero, errInvalidPath(`axis "attribute" is not supported, atoms have no attributes or namespaces in "/ROOT/attribute::LEAF"`)},
+		PathTest{TestAtom1, "/ROOT/parent:LEAF", zero, errInvalidPath(`expected :: after axis "parent" in "/ROOT/parent:LEAF"`)},
+		PathTest{TestAtom1, "/ROOT/parent::", zero, errInvalidPath(`expected node test after "parent::" in "/ROOT/parent::"`)},
+		PathTest{TestAtom1, "/ROOT/...", zero, errInvalidPath(`invalid step "..." in "/ROOT/..."`)},
+
 		// === Equality Operator testing.
 		PathTest{TestAtom2, "/ROOT/UI_1[2 = @data]", zero, nil},
 		PathTest{TestAtom2, "/ROOT[2 = UI_1]", zero, nil},
@@ -544,6 +597,82 @@ func TestAtomsAtPath(t *testing.T) {
 		PathTest{TestAtom2, "/ROOT[ position() = 1 or]", []string{}, errInvalidPredicate(`expect boolean value, got nothing in "/ROOT[ position() = 1 or]"`)},
 		PathTest{TestAtom2, "/ROOT[ position() = 1 or ()]", []string{}, errInvalidPredicate(`expect boolean value, got nothing in "/ROOT[ position() = 1 or ()]"`)},
 
+		// === Numeric function testing.
+		PathTest{TestAtom2, "/ROOT[abs(SI_N) = 10]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[abs(FP_N) = FP_P]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[floor(FP_N) = -16]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[ceiling(FP_N) = -15]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[round(FP_P) = 16 and round(FP_N) = -15]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[floor(SI_P div 2) = 7]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[min(UI_1, SI_N, FP_N) = FP_N]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[max(UI_1, SI_N, FP_N) = 1]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[avg(SI_N, SI_P) = 2.5]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[sum(SI_N, SI_P, UI_1) = 6]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT/UINT[data() * 2 > max(8, 15)]", []string{"UINT:UI32:8", "UINT:UI32:9", "UINT:UI32:10"}, nil},
+		PathTest{TestAtom2, "/ROOT[UIMX * UIMX > UIMX]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[UIMX * UIMX * UIMX > 0]", zero, errInvalidPredicate(`integer overflow, result 79228162458924105385300197375 is out of range in "/ROOT[UIMX * UIMX * UIMX > 0]"`)},
+		PathTest{TestAtom2, "/ROOT[UI_1 - UIMX < 0]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[UIMX < 18446744073709551615]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "//UIMX[data() * 4294967297 = 18446744073709551615]", []string{"UIMX:UI64:4294967295"}, nil},
+		PathTest{TestAtom2, "//UIMX[data() * 4294967297 > 0xFFFFFFFFFFFFFFFE]", []string{"UIMX:UI64:4294967295"}, nil},
+		PathTest{TestAtom2, "/ROOT[SI_P idiv 0 = 1]", zero, errInvalidPredicate(`integer division by zero in "/ROOT[SI_P idiv 0 = 1]"`)},
+		PathTest{TestAtom2, "/ROOT[SI_P mod 0 = 1]", zero, errInvalidPredicate(`integer division by zero in "/ROOT[SI_P mod 0 = 1]"`)},
+		PathTest{TestAtom2, "/ROOT[SI_P idiv 0.5 = 30]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom2, "/ROOT[abs(SI_N, SI_P) = 1]", zero, errInvalidPredicate(`abs() takes 1 argument, got 2 in "/ROOT[abs(SI_N, SI_P) = 1]"`)},
+		PathTest{TestAtom2, "/ROOT[max() = 1]", zero, errInvalidPredicate(`max() takes at least 1 argument, got 0 in "/ROOT[max() = 1]"`)},
+
+		// === String function testing.
+		PathTest{TestAtomGINF, `//*[starts-with(data(), "10.")]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[contains(data(), "OID")]`, []string{`0x00000001:CSTR:"{OID='2.16.124.113590.3.1.3.3.1'}"`}, nil},
+		PathTest{TestAtomGINF, `//*[ends-with(name(), "VND")]`, []string{"GVND:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//*[string-length(data()) = 6]`, []string{"0x00000001:UI32:908767", `0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[string-length() = 6]`, []string{"0x00000001:UI32:908767", `0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[substring(name(), 1, 2) = "GS"]`, []string{"GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//*[substring(name(), 1.5, 1.6) = "SI"]`, []string{"GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//*[substring(data(), 4) = "4.0"]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[substring-before(data(), ".") = "10"]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[substring-after(data(), "10.") = "4.0"]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `/GINF/*[normalize-space(concat("  ", name(), " ", type(), " ")) = "BVER UI32"]`, []string{"BVER:UI32:4"}, nil},
+		PathTest{TestAtomGINF, `//*[translate(data(), ".", "") = "1040"]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[translate(name(), "GIDV", "gi") = "gi"]`, []string{"GIDV:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//AVTP[lower-case(data()) = "cstr"]`, []string{"AVTP:FC32:'CSTR'", "AVTP:FC32:'CSTR'"}, nil},
+		PathTest{TestAtomGINF, `//*[upper-case(substring-before(data(), "=")) = "{OID"]`, []string{`0x00000001:CSTR:"{OID='2.16.124.113590.3.1.3.3.1'}"`}, nil},
+		PathTest{TestAtomGINF, `//*[matches(data(), "^[0-9]+[.][0-9]+[.]")]`, []string{`0x00000001:CSTR:"10.4.0"`}, nil},
+		PathTest{TestAtomGINF, `//*[matches(name(), "^g..v$", "i")]`, []string{"GIDV:CONT:", "GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, `/GINF[contains(name(/GINF/BVER), "B")]`, []string{"GINF:CONT:"}, nil},
+		PathTest{TestAtomGINF, `/GINF[string-length(data(/GINF/BTIM)) = 16]`, []string{"GINF:CONT:"}, nil},
+		PathTest{TestAtomGINF, `/GINF/*[starts-with(type(.), "UI")]`, []string{"BVER:UI32:4", "BTIM:UI64:1484723582627327"}, nil},
+		PathTest{TestAtomGINF, `/GINF/*[concat(name(), name(AVAL/..)) = "GIDVGIDV"]`, []string{"GIDV:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*[sum(data(LEAF)) = 15]", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, `/ROOT/*[contains(name(LEAF), "EA")][1]`, []string{"0001:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//*[contains(data())]`, zero, errInvalidPredicate(`contains() takes 2 arguments, got 1 in "//*[contains(data())]"`)},
+		PathTest{TestAtomGINF, `//*[concat(name())]`, zero, errInvalidPredicate(`concat() takes at least 2 arguments, got 1 in "//*[concat(name())]"`)},
+		PathTest{TestAtomGINF, `//*[matches(data(), "x", "q")]`, zero, errInvalidPredicate(`invalid flags "q" for matches() in "//*[matches(data(), \"x\", \"q\")]"`)},
+		PathTest{TestAtomGINF, `//*[find-any(data())]`, zero, errInvalidPath(`unrecognized function "find-any" in "//*[find-any(data())]"`)},
+
+		// === Relative location paths in predicates.
+		PathTest{TestAtom1, "/ROOT/*[LEAF = 5]", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*[LEAF > 8]", []string{"0003:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*[sum(LEAF) = 15]", []string{"0002:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT/*[LEAF[. > 2][1] = 3]", []string{"0001:CONT:"}, nil},
+		PathTest{TestAtom1, "/ROOT[0003/LEAF = 9]", []string{"ROOT:CONT:"}, nil},
+		PathTest{TestAtom1, "//LEAF[../LEAF = 1]", []string{"LEAF:UI32:1", "LEAF:UI32:2", "LEAF:UI32:3"}, nil},
+		PathTest{TestAtomGINF, `//*[AVAL/0x00000001 = "10.4.0"]`, []string{"GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[.//0x00000001 > 1000000]", []string{"GPVD:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[AVAL[*[2] = 908767]]", []string{"GIDV:CONT:"}, nil},
+		PathTest{TestAtomGINF, `/GINF/*[AVAL/*[1] = 2 and AVTP = "UI64"]`, []string{"GPVD:CONT:"}, nil},
+		PathTest{TestAtomGINF, `//AVAL[../AVTP = "CSTR"]/0x00000001`, []string{
+			`0x00000001:CSTR:"{OID='2.16.124.113590.3.1.3.3.1'}"`,
+			`0x00000001:CSTR:"10.4.0"`,
+		}, nil},
+		PathTest{TestAtomGINF, `//AVAL[ancestor::GINF/BVER = 4][../AVTP = "UI64"]`, []string{"AVAL:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[count(AVAL/*) = 2]", []string{"GIDV:CONT:", "GPVD:CONT:", "GVND:CONT:", "GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[AVAL]", []string{"GIDV:CONT:", "GPVD:CONT:", "GVND:CONT:", "GSIV:CONT:"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[not(AVAL)]", []string{"BVER:UI32:4", "BTIM:UI64:1484723582627327"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[//BVER = 4][1]", []string{"BVER:UI32:4"}, nil},
+		PathTest{TestAtomGINF, "/GINF/*[AVAL[*[2] = 908767]", zero, errInvalidPath(`expected "]" to end predicate in "/GINF/*[AVAL[*[2] = 908767]"`)},
+		PathTest{TestAtomGINF, "/GINF/*[AVAL[* = ]]", zero, errInvalidPredicate(`expected value, got nothing in "/GINF/*[AVAL[* = ]]"`)},
+
 		// test union operator
 		PathTest{TestAtomGINF, `//*[@name="0x00000000"] | //*[@name="0x00000001"]`, []string{
 			"0x00000001:UI32:908767",
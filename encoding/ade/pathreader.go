@@ -0,0 +1,247 @@
+package ade
+
+// Path evaluation against binary input, decoding only the atoms that the path
+// can reach.
+//
+// Most paths start with a series of plain child steps, such as /GINF/GIDV/AVAL
+// in "/GINF/GIDV/AVAL/*[@name > 0]". An atom can only match such a path if it
+// is inside an atom matching each of those steps, so atoms whose names do not
+// match are skipped by seeking past them, using the size in their header. The
+// atoms that do match the final plain step are decoded in full. The path is
+// then evaluated against the pruned atom tree, which holds every atom the path
+// can reach.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// GetAtomsFromReader returns the atoms that match the path, using each of the
+// binary AtomContainers read from r as the root.
+//
+// Atoms which the path cannot reach are skipped without being read, and are
+// not checked for errors. If DecodeOptions are given, their limits are
+// enforced on the atoms that are read, which together must be within the
+// limits of MaxAtoms and MaxTotalBytes. Otherwise DefaultDecodeOptions are
+// used.
+func (ap *AtomPath) GetAtomsFromReader(r io.ReadSeeker, opts ...DecodeOptions) (atoms []*Atom, err error) {
+	p := pruner{r: r, opts: getDecodeOptions(opts), steps: pathPrefix(ap.Path)}
+	if p.size, err = r.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if p.offset, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if o := p.opts; o.MaxTotalBytes > 0 && p.size > o.MaxTotalBytes {
+		return nil, &DecodeError{Err: ErrLimitExceeded, Path: "/",
+			Msg: fmt.Sprintf("input size %d exceeds limit of %d bytes of input", p.size, o.MaxTotalBytes)}
+	}
+
+	roots, err := p.readAtoms(p.size)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		found, err := ap.GetAtoms(root)
+		if err != nil {
+			return nil, err
+		}
+		atoms = append(atoms, found...)
+	}
+	return atoms, nil
+}
+
+// pathPrefix returns the names of the plain child steps at the start of the
+// path, which every matching atom must be within. A name may be the "*"
+// wildcard. It returns nil if the path has no such steps, or uses syntax that
+// may refer to atoms outside of them.
+func pathPrefix(path string) (names []string) {
+	// Axes which lead out of an atom can reach atoms outside of the steps.
+	if strings.Contains(path, "..") {
+		return nil
+	}
+	for _, axis := range []string{axisAncestor, axisAncestorOrSelf, axisFollowing, axisFollowingSibling,
+		axisParent, axisPreceding, axisPrecedingSibling} {
+		if strings.Contains(path, axis+"::") {
+			return nil
+		}
+	}
+
+	var rest = path
+	for strings.HasPrefix(rest, "/") {
+		step := rest[1:]
+		if i := strings.IndexByte(step, '/'); i >= 0 {
+			step = step[:i]
+		}
+		if step != "*" && (step == "" || strings.Trim(step, alphaNumericChars) != "") {
+			break
+		}
+		names = append(names, step)
+		rest = rest[1+len(step):]
+	}
+
+	// Set operators combine paths which may start anywhere.
+	for _, op := range []string{"|", "union", "intersect"} {
+		if strings.Contains(rest, op) {
+			return nil
+		}
+	}
+
+	// So do absolute paths within predicates.
+	if pe, err := newPathEvaluator(path); err == nil && pe.tokens.hasAbsolutePathInPredicate() {
+		return nil
+	}
+	return names
+}
+
+// pruner reads binary input, keeping only the atoms within the plain steps
+// at the start of a path.
+type pruner struct {
+	r      io.ReadSeeker
+	opts   DecodeOptions
+	steps  []string         // names of the plain steps at the start of the path
+	size   int64            // size of the input
+	offset int64            // position in the input
+	atoms  int              // count of atom headers read
+	path   []string         // names of the containers being read
+	header [headerSize]byte // buffer for reading atom headers
+}
+
+// readAtoms reads the atoms found from the current position up to the given
+// end position, skipping those that the path cannot reach.
+func (p *pruner) readAtoms(end int64) (atoms []*Atom, err error) {
+	var depth = len(p.path)
+	for p.offset < end {
+		start := p.offset
+		h, err := readAtomHeader(p.r, &p.header, &p.offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, p.errorf(ErrTruncated, start, h, "unexpected end of input at byte %d, in atom header", p.offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.atoms++
+		if err = p.checkHeader(start, end, h); err != nil {
+			return nil, err
+		}
+
+		var (
+			atomEnd = start + int64(h.Size)
+			name    = h.printableName()
+			a       *Atom
+		)
+		switch {
+		case depth >= len(p.steps)-1 && (depth >= len(p.steps) || p.match(depth, name)):
+			// final step reached, keep the whole atom
+			if a, err = p.decodeAtom(start, h); err != nil {
+				return nil, err
+			}
+		case h.isContainer() && p.match(depth, name):
+			// on the path, keep the container and the children the path can reach
+			a = &Atom{name: append([]byte(nil), h.Name[:]...), typ: codec.CONT}
+			a.Value = codec.NewCodec(&a.data, a.typ)
+			p.path = append(p.path, name)
+			a.children, err = p.readAtoms(atomEnd)
+			p.path = p.path[:depth]
+			if err != nil {
+				return nil, err
+			}
+		default:
+			// not on the path, skip it
+			if p.offset, err = p.r.Seek(atomEnd, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		if a != nil {
+			atoms = append(atoms, a)
+		}
+	}
+	return atoms, nil
+}
+
+// match returns true if the atom name matches the path step at the given depth.
+func (p *pruner) match(depth int, name string) bool {
+	return nameMatches(name, p.steps[depth])
+}
+
+// checkHeader returns an error if the atom header at the given position is
+// invalid, or the atom extends past the given end position.
+func (p *pruner) checkHeader(pos, end int64, h atomHeader) error {
+	var (
+		name = h.printableName()
+		typ  = codec.ADEType(h.Type[:])
+	)
+	switch {
+	case !codec.IsValidType(typ):
+		return p.errorf(ErrUnknownType, pos, h, "atom %s at byte %d has unknown ADE type %q", name, pos, typ)
+	case h.Size < headerSize:
+		return p.errorf(ErrSizeMismatch, pos, h, "atom %s:%s at byte %d has invalid size %d, less than header size %d",
+			name, typ, pos, h.Size, headerSize)
+	case pos+int64(h.Size) > end && len(p.path) > 0:
+		return p.errorf(ErrContainerOverrun, pos, h, "atom %s:%s at byte %d with size %d overruns container %s, which ends at byte %d",
+			name, typ, pos, h.Size, p.path[len(p.path)-1], end)
+	case pos+int64(h.Size) > p.size:
+		return p.errorf(ErrTruncated, pos, h, "unexpected end of input at byte %d, atom %s:%s at byte %d should end at byte %d",
+			p.size, name, typ, pos, pos+int64(h.Size))
+	case p.opts.MaxAtoms > 0 && p.atoms > p.opts.MaxAtoms:
+		return p.errorf(ErrLimitExceeded, pos, h, "atom %s:%s at byte %d exceeds limit of %d atoms",
+			name, typ, pos, p.opts.MaxAtoms)
+	case p.opts.MaxDepth > 0 && h.isContainer() && len(p.path) >= p.opts.MaxDepth:
+		return p.errorf(ErrLimitExceeded, pos, h, "container %s at byte %d exceeds limit of %d nested containers",
+			name, pos, p.opts.MaxDepth)
+	}
+	return nil
+}
+
+// decodeAtom reads and decodes the atom whose header was just read from the
+// given position, including all of its children.
+//
+// The Decoder starts at the position and atom count of the pruner, so that
+// limits on the atoms and bytes of input apply to the whole input, rather than
+// to each decoded atom separately.
+func (p *pruner) decodeAtom(pos int64, h atomHeader) (*Atom, error) {
+	opts := p.opts
+	if opts.MaxDepth > 0 {
+		opts.MaxDepth -= len(p.path)
+	}
+	input := io.MultiReader(bytes.NewReader(p.header[:]), io.LimitReader(p.r, int64(h.Size)-headerSize))
+	d := NewDecoder(input, opts)
+	d.offset, d.atoms = pos, p.atoms-1 // the header is read again
+	atoms, err := readAtomsFromDecoder(d)
+	p.offset, p.atoms = pos+int64(h.Size), d.atoms
+
+	// locate errors within the whole input
+	var e *DecodeError
+	if errors.As(err, &e) {
+		names := append([]string(nil), p.path...)
+		if e.Path != "/" {
+			names = append(names, strings.Split(e.Path[1:], "/")...)
+		}
+		e.Path = containerPath(names)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return atoms[0], nil
+}
+
+// errorf returns a DecodeError for the atom at the given byte position.
+func (p *pruner) errorf(cause error, pos int64, h atomHeader, format string, args ...interface{}) error {
+	e := &DecodeError{
+		Err:    cause,
+		Msg:    fmt.Sprintf(format, args...),
+		Path:   containerPath(p.path),
+		Offset: pos,
+	}
+	if h.Size != 0 {
+		e.Name = h.printableName()
+		e.Type = codec.ADEType(h.Type[:])
+		e.Size = h.Size
+	}
+	return e
+}
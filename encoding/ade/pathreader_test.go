@@ -0,0 +1,214 @@
+package ade
+
+//
+// Verify that paths evaluated against binary input find the same atoms as
+// paths evaluated against a decoded atom tree, while skipping the atoms the
+// path cannot reach.
+//
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countingReadSeeker counts the bytes read through it
+type countingReadSeeker struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestPathPrefix(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/GINF/GIDV/AVAL", []string{"GINF", "GIDV", "AVAL"}},
+		{"/GINF/GIDV/AVAL/*[@name > 0]", []string{"GINF", "GIDV", "AVAL"}},
+		{"/ROOT/*/LEAF", []string{"ROOT", "*", "LEAF"}},
+		{"/ROOT/0002[1]/LEAF", []string{"ROOT"}},
+		{"/ROOT//LEAF", []string{"ROOT"}},
+		{"/ROOT/", []string{"ROOT"}},
+		{"//LEAF", nil},
+		{"/", nil},
+		{"ROOT/0002", nil},
+		{"/ROOT/0002 | /ROOT/0003", nil},
+		{"/ROOT/0002[1] union /ROOT/0003", nil},
+		{"/ROOT/0002/..", nil},
+		{"/ROOT/0002/LEAF/ancestor::*", nil},
+		{"/ROOT/0002/following-sibling::*", nil},
+		{"/ROOT/0002/descendant::LEAF", []string{"ROOT", "0002"}},
+		{"/ROOT/0002[LEAF/data() > 4]/LEAF", []string{"ROOT"}},
+		{"/ROOT/0002[//LEAF > 8]", nil},
+		{"/ROOT/0002[count(/ROOT/*/LEAF) = 9]", nil},
+	}
+	for _, test := range tests {
+		if got := pathPrefix(test.path); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pathPrefix(%s): got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestGetAtomsFromReader(t *testing.T) {
+	paths := []string{
+		"/ROOT/0002",
+		"/ROOT/*/LEAF",
+		"/ROOT/0002/LEAF[2]",
+		"/ROOT/0002/LEAF[data() > 4]",
+		"//LEAF[data() > 4]",
+		"/ROOT/NONE",
+		"/",
+		"/*/*[1] | /ROOT/0003",
+		"/ROOT/0002/LEAF[2]/..",
+		"/ROOT/0002/following-sibling::*",
+		"/ROOT/0002/LEAF[1]/preceding::LEAF",
+		"/ROOT/*/descendant::LEAF",
+		"/ROOT/*[LEAF > 8]/LEAF",
+		"/ROOT/0002/LEAF[//LEAF > 8]",
+	}
+	var inputs = map[string]*Atom{"TestAtom1": TestAtom1, "TestAtom2": TestAtom2, "TestAtomGINF": TestAtomGINF}
+	for name, atom := range inputs {
+		buf, err := atom.MarshalBinary()
+		if err != nil {
+			t.Fatalf("GetAtomsFromReader(%s): unable to create test input: %s", name, err)
+		}
+		for _, path := range append(paths, "/GINF/GIDV/AVAL/*[@name > 0]") {
+			ap, err := NewAtomPath(path)
+			if err != nil {
+				t.Fatalf("GetAtomsFromReader(%s): invalid path %s: %s", name, path, err)
+			}
+			want, err := ap.GetAtoms(atom)
+			if err != nil {
+				t.Fatalf("GetAtomsFromReader(%s): unable to evaluate path %s on test atom: %s", name, path, err)
+			}
+			got, err := ap.GetAtomsFromReader(bytes.NewReader(buf))
+			if err != nil {
+				t.Errorf("GetAtomsFromReader(%s, %s): expect no error, got %s", name, path, err)
+				continue
+			}
+			if atomsText(got) != atomsText(want) {
+				t.Errorf("GetAtomsFromReader(%s, %s): got\n%s\nwant\n%s", name, path, atomsText(got), atomsText(want))
+			}
+		}
+	}
+}
+
+func TestGetAtomsFromReaderSkips(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestGetAtomsFromReaderSkips: unable to create test input: %s", err)
+	}
+	tests := []struct {
+		path string
+		want int64 // bytes read
+	}{
+		{"/ROOT/0002", 12 + 3*12 + 48},         // ROOT header, 3 container headers, 0002 children
+		{"/ROOT/0002/LEAF[1]", 12 + 3*12 + 48}, // same, predicate applies within 0002
+		{"/ROOT/NONE", 12 + 3*12},              // headers only
+		{"/NONE/0002", 12},                     // root header only
+		{"//LEAF", int64(len(buf))},            // everything
+		{"/ROOT/*/LEAF", int64(len(buf))},      // everything
+		{"/ROOT/0003/LEAF", 12 + 3*12 + 48},    // children of 0003 only
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.path)
+		if err != nil {
+			t.Fatalf("GetAtomsFromReader(%s): invalid path: %s", test.path, err)
+		}
+		r := &countingReadSeeker{ReadSeeker: bytes.NewReader(buf)}
+		if _, err = ap.GetAtomsFromReader(r); err != nil {
+			t.Errorf("GetAtomsFromReader(%s): expect no error, got %s", test.path, err)
+			continue
+		}
+		if r.n != test.want {
+			t.Errorf("GetAtomsFromReader(%s): got %d bytes read, want %d", test.path, r.n, test.want)
+		}
+	}
+}
+
+func TestGetAtomsFromReaderInvalid(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestGetAtomsFromReaderInvalid: unable to create test input: %s", err)
+	}
+	badType := append([]byte{}, buf...)
+	copy(badType[152:156], "JUNK") // LEAF at byte 144 in container 0003
+	overrun := append([]byte{}, buf...)
+	overrun[86] = 0xFF // LEAF at byte 84 overruns container 0002
+
+	tests := []struct {
+		name     string
+		input    []byte
+		path     string
+		want     error
+		wantPath string
+		offset   int64
+	}{
+		{"truncated", buf[:150], "/ROOT/0001", ErrTruncated, "/", 0},
+		{"unknown type in path", badType, "/ROOT/0003", ErrUnknownType, "/ROOT/0003", 144},
+		{"overrun in path", overrun, "/ROOT/0002/LEAF", ErrContainerOverrun, "/ROOT/0002", 84},
+		{"overrun in decoded subtree", overrun, "/ROOT/0002", ErrContainerOverrun, "/ROOT/0002", 84},
+		{"unknown type outside path", badType, "/ROOT/0002", nil, "", 0},
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.path)
+		if err != nil {
+			t.Fatalf("GetAtomsFromReader(%s): invalid path %s: %s", test.name, test.path, err)
+		}
+		_, err = ap.GetAtomsFromReader(bytes.NewReader(test.input))
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("GetAtomsFromReader(%s): expect no error, got %s", test.name, err)
+			}
+			continue
+		}
+		var e *DecodeError
+		if !errors.Is(err, test.want) || !errors.As(err, &e) || e.Path != test.wantPath || e.Offset != test.offset {
+			t.Errorf("GetAtomsFromReader(%s): got err {%v}, want cause {%s} at path %s byte %d", test.name, err, test.want, test.wantPath, test.offset)
+		}
+	}
+}
+
+// Limits apply to the whole input, not to each subtree that is decoded.
+func TestGetAtomsFromReaderLimits(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestGetAtomsFromReaderLimits: unable to create test input: %s", err)
+	}
+	tests := []struct {
+		path    string
+		opts    DecodeOptions
+		wantErr string
+	}{
+		{"/ROOT/*", DecodeOptions{MaxAtoms: 13}, ""},
+		{"/ROOT/*", DecodeOptions{MaxAtoms: 12}, "LEAF:UI32 at byte 176 exceeds limit of 12 atoms"},
+		{"/ROOT/*/LEAF", DecodeOptions{MaxAtoms: 12}, "LEAF:UI32 at byte 176 exceeds limit of 12 atoms"},
+		{"/ROOT/0003", DecodeOptions{MaxAtoms: 7}, ""}, // skipped containers are counted, not their children
+		{"/ROOT/0003", DecodeOptions{MaxAtoms: 6}, "LEAF:UI32 at byte 176 exceeds limit of 6 atoms"},
+		{"/ROOT/*", DecodeOptions{MaxTotalBytes: 191}, "input size 192 exceeds limit of 191 bytes"},
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.path)
+		if err != nil {
+			t.Fatalf("GetAtomsFromReader(%s): invalid path: %s", test.path, err)
+		}
+		_, err = ap.GetAtomsFromReader(bytes.NewReader(buf), test.opts)
+		switch {
+		case err == nil && test.wantErr != "":
+			t.Errorf("GetAtomsFromReader(%s, %+v): got err <nil>, want err containing %q", test.path, test.opts, test.wantErr)
+		case err != nil && test.wantErr == "":
+			t.Errorf("GetAtomsFromReader(%s, %+v): expect no error, got %s", test.path, test.opts, err)
+		case err != nil && !strings.Contains(err.Error(), test.wantErr):
+			t.Errorf("GetAtomsFromReader(%s, %+v): got err {%s}, want err containing %q", test.path, test.opts, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package ade
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ResultType identifies the kind of value that a path expression evaluates
+// to.
+type ResultType int
+
+const (
+	ResultNodeSet ResultType = iota // atoms, as selected by "//NODE"
+	ResultBoolean                   // true or false, as from "count(//NODE) > 2"
+	ResultNumber                    // a number, as from "count(//NODE)"
+	ResultString                    // a string, as from "name()"
+	ResultSlice                     // values of atoms, as from "//USED/data()"
+)
+
+func (t ResultType) String() string {
+	switch t {
+	case ResultNodeSet:
+		return "node set"
+	case ResultBoolean:
+		return "boolean"
+	case ResultNumber:
+		return "number"
+	case ResultString:
+		return "string"
+	case ResultSlice:
+		return "slice"
+	}
+	return fmt.Sprintf("ResultType(%d)", int(t))
+}
+
+// Result is the value of a path expression, as returned by
+// AtomPath.Evaluate.
+//
+// The SliceOf methods return the values of a result of any type.  A node set
+// gives the value of each atom, a slice gives each of its values, and other
+// results give a single value.
+type Result struct {
+	Type  ResultType
+	value iEqualer
+}
+
+func newResult(v iEqualer) (r Result) {
+	r.value = v
+	switch v.(type) {
+	case typeNodeSet:
+		r.Type = ResultNodeSet
+	case typeBoolean:
+		r.Type = ResultBoolean
+	case typeString:
+		r.Type = ResultString
+	case typeSlice:
+		r.Type = ResultSlice
+	default:
+		r.Type = ResultNumber
+	}
+	return r
+}
+
+// Atoms returns the atoms of a node set, or nil for other results.
+func (r Result) Atoms() []*Atom {
+	atoms, _ := r.value.(typeNodeSet)
+	return atoms
+}
+
+// SliceOfUint returns the values of the result as unsigned integers.  It
+// fails if any value is not a non-negative integer.
+func (r Result) SliceOfUint() (values []uint64, err error) {
+	for _, v := range sequenceValues(r.value) {
+		var x uint64
+		switch v := v.(type) {
+		case typeUint64:
+			x = uint64(v)
+		case typeInt64:
+			if v < 0 {
+				return nil, fmt.Errorf("value %d is not an unsigned integer", v)
+			}
+			x = uint64(v)
+		case typeFloat64:
+			if v < 0 || v != typeFloat64(math.Trunc(float64(v))) || v >= math.MaxUint64 {
+				return nil, fmt.Errorf("value %s is not an unsigned integer", formatValue(v))
+			}
+			x = uint64(v)
+		case typeBoolean:
+			if v {
+				x = 1
+			}
+		case typeString:
+			if x, err = strconv.ParseUint(string(v), 0, 64); err != nil {
+				return nil, fmt.Errorf("value %q is not an unsigned integer", v)
+			}
+		}
+		values = append(values, x)
+	}
+	return values, nil
+}
+
+// SliceOfFloat returns the values of the result as floating point numbers.  It
+// fails if any value is a string that is not a number.
+func (r Result) SliceOfFloat() (values []float64, err error) {
+	for _, v := range sequenceValues(r.value) {
+		var x float64
+		switch v := v.(type) {
+		case typeUint64:
+			x = float64(v)
+		case typeInt64:
+			x = float64(v)
+		case typeFloat64:
+			x = float64(v)
+		case typeBoolean:
+			if v {
+				x = 1
+			}
+		case typeString:
+			if x, err = strconv.ParseFloat(string(v), 64); err != nil {
+				return nil, fmt.Errorf("value %q is not a number", v)
+			}
+		}
+		values = append(values, x)
+	}
+	return values, nil
+}
+
+// SliceOfString returns the values of the result as strings.  The values of
+// the atoms of a node set are given in the same form as in the text format,
+// without delimiters.
+func (r Result) SliceOfString() (values []string, err error) {
+	if atoms, ok := r.value.(typeNodeSet); ok {
+		for _, a := range atoms {
+			s, err := a.Value.String()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	for _, v := range sequenceValues(r.value) {
+		values = append(values, formatValue(v))
+	}
+	return values, nil
+}
+
+// formatValue returns the text of a value, with numbers in the shortest form
+// that represents them exactly.
+func formatValue(v iEqualer) string {
+	switch v := v.(type) {
+	case typeUint64:
+		return strconv.FormatUint(uint64(v), 10)
+	case typeInt64:
+		return strconv.FormatInt(int64(v), 10)
+	case typeFloat64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case typeBoolean:
+		return strconv.FormatBool(bool(v))
+	case typeString:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,135 @@
+package ade
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		Atom      *Atom
+		Input     string
+		WantType  ResultType
+		WantValue []string
+	}{
+		{TestAtom1, "count(//LEAF)", ResultNumber, []string{"9"}},
+		{TestAtom1, "count(/ROOT/*) * 2 + 1", ResultNumber, []string{"7"}},
+		{TestAtom1, "2 * count(//LEAF[data() > 6])", ResultNumber, []string{"6"}},
+		{TestAtom1, "sum(//LEAF/data())", ResultNumber, []string{"45"}},
+		{TestAtom1, "sum(/ROOT/0002/LEAF) div 2", ResultNumber, []string{"7.5"}},
+		{TestAtom1, "sum(//JUNK)", ResultNumber, []string{"0"}},
+		{TestAtom1, "count(//LEAF) = 9", ResultBoolean, []string{"true"}},
+		{TestAtom1, "//LEAF > 8 and not(//JUNK)", ResultBoolean, []string{"true"}},
+		{TestAtom1, "//LEAF = 10", ResultBoolean, []string{"false"}},
+		{TestAtom1, "/ROOT/0001/LEAF/data() * 2", ResultSlice, []string{"2", "4", "6"}},
+		{TestAtom1, "/ROOT/0003/LEAF mod 2", ResultSlice, []string{"1", "0", "1"}},
+		{TestAtom1, "/ROOT/*/name()", ResultSlice, []string{"0001", "0002", "0003"}},
+		{TestAtom1, "/ROOT/0001/LEAF", ResultNodeSet, []string{"1", "2", "3"}},
+		{TestAtomGINF, "/GINF/BVER/data() * 2", ResultSlice, []string{"8"}},
+		{TestAtomGINF, "//GSIV/AVAL/0x00000001/data()", ResultSlice, []string{"10.4.0"}},
+		{TestAtomGINF, "//AVTP/type()", ResultSlice, []string{"FC32", "FC32", "FC32", "FC32"}},
+		{TestAtomGINF, "name()", ResultString, []string{"GINF"}},
+		{TestAtomGINF, `"text"`, ResultString, []string{"text"}},
+		{TestAtomGINF, "-1.5 + 1", ResultNumber, []string{"-0.5"}},
+		{TestAtomGINF, `concat(name(), "-", count(//AVAL))`, ResultString, []string{"GINF-4"}},
+		{TestAtomGINF, `string-length(//GSIV//0x00000001)`, ResultNumber, []string{"6"}},
+		{TestAtom1, "min(//LEAF)", ResultNumber, []string{"1"}},
+		{TestAtom1, "max(//LEAF/data(), 10)", ResultNumber, []string{"10"}},
+		{TestAtom1, "avg(/ROOT/0002/LEAF)", ResultNumber, []string{"5"}},
+		{TestAtom1, "max(//JUNK)", ResultNumber, []string{"NaN"}},
+		{TestAtom1, "round(/ROOT/0001/LEAF div 2)", ResultSlice, []string{"1", "1", "2"}},
+		{TestAtom1, "ceiling(sum(//LEAF) div 10)", ResultNumber, []string{"5"}},
+		{TestAtom1, "abs(1 - count(//LEAF))", ResultNumber, []string{"8"}},
+		{TestAtom1, "9223372036854775807 * 2", ResultNumber, []string{"18446744073709551614"}},
+		{TestAtom1, "18446744073709551615 - 1", ResultNumber, []string{"18446744073709551614"}},
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.Input)
+		if err != nil {
+			t.Errorf("Evaluate(%s): expect no error, got %s", test.Input, err)
+			continue
+		}
+		result, err := ap.Evaluate(test.Atom)
+		if err != nil {
+			t.Errorf("Evaluate(%s): expect no error, got %s", test.Input, err)
+			continue
+		}
+		got, err := result.SliceOfString()
+		if err != nil {
+			t.Errorf("Evaluate(%s): SliceOfString failed: %s", test.Input, err)
+			continue
+		}
+		if result.Type != test.WantType || !reflect.DeepEqual(got, test.WantValue) {
+			t.Errorf("Evaluate(%s): got %s %q, want %s %q", test.Input, result.Type, got, test.WantType, test.WantValue)
+		}
+		if ap.SelectsAtoms() != (test.WantType == ResultNodeSet) {
+			t.Errorf("Evaluate(%s): SelectsAtoms() is %t for a %s", test.Input, ap.SelectsAtoms(), result.Type)
+		}
+	}
+}
+
+func TestEvaluateInvalid(t *testing.T) {
+	tests := []struct {
+		Input string
+		Want  string
+	}{
+		{"//LEAF/name() * 2", `invalid path: expected numeric value, got "LEAF" in "//LEAF/name() * 2"`},
+		{"//LEAF/data() + //LEAF/data()", `invalid path: arithmetic on two sequences is not supported in "//LEAF/data() + //LEAF/data()"`},
+		{"sum(//LEAF/name())", `invalid path: sum() expects numbers, got "LEAF" in "sum(//LEAF/name())"`},
+		{"true(1)", `invalid path: too many arguments for true() in "true(1)"`},
+		{"count(//LEAF) 3", `invalid path: unexpected "count" in "count(//LEAF) 3"`},
+		{"floor(name())", `invalid path: floor() expects a number, got "ROOT" in "floor(name())"`},
+		{"-9223372036854775807 - 2", `invalid path: integer overflow, result -9223372036854775809 is out of range in "-9223372036854775807 - 2"`},
+		{"sum(//LEAF) idiv 0", `invalid path: integer division by zero in "sum(//LEAF) idiv 0"`},
+		{"18446744073709551616 - 1", `invalid path: strconv.ParseInt: parsing "18446744073709551616": value out of range in "18446744073709551616 - 1"`},
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.Input)
+		if err == nil {
+			_, err = ap.Evaluate(TestAtom1)
+		}
+		if err == nil || err.Error() != test.Want {
+			t.Errorf("Evaluate(%s): got err {%v}, want {%s}", test.Input, err, test.Want)
+		}
+	}
+
+	// GetAtoms only returns node sets
+	want := `invalid path: expression evaluates to a number, not atoms in "count(//LEAF)"`
+	if _, err := TestAtom1.AtomsAtPath("count(//LEAF)"); err == nil || err.Error() != want {
+		t.Errorf("AtomsAtPath(count(//LEAF)): got err {%v}, want {%s}", err, want)
+	}
+}
+
+func TestResultSliceOf(t *testing.T) {
+	tests := []struct {
+		Input     string
+		WantUint  []uint64
+		WantFloat []float64
+	}{
+		{"//LEAF[position() < 3]", []uint64{1, 2}, []float64{1, 2}},
+		{"count(//LEAF) div 2", nil, []float64{4.5}},
+		{"/ROOT/0002/LEAF - 5", nil, []float64{-1, 0, 1}},
+		{"count(//LEAF) > 2", []uint64{1}, []float64{1}},
+		{"name()", nil, nil},
+	}
+	for _, test := range tests {
+		ap, err := NewAtomPath(test.Input)
+		if err != nil {
+			t.Errorf("SliceOfUint(%s): unable to create path: %s", test.Input, err)
+			continue
+		}
+		result, err := ap.Evaluate(TestAtom1)
+		if err != nil {
+			t.Errorf("SliceOfUint(%s): unable to evaluate path: %s", test.Input, err)
+			continue
+		}
+		gotUint, err := result.SliceOfUint()
+		if (err != nil) != (test.WantUint == nil) || !reflect.DeepEqual(gotUint, test.WantUint) {
+			t.Errorf("SliceOfUint(%s): got %v with err {%v}, want %v", test.Input, gotUint, err, test.WantUint)
+		}
+		gotFloat, err := result.SliceOfFloat()
+		if (err != nil) != (test.WantFloat == nil) || !reflect.DeepEqual(gotFloat, test.WantFloat) {
+			t.Errorf("SliceOfFloat(%s): got %v with err {%v}, want %v", test.Input, gotFloat, err, test.WantFloat)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ade
+
+// Recovery of atoms from damaged binary AtomContainers.
+//
+// ReadAtomsFromBinary fails on the first problem it finds, so a container
+// which is truncated or has one bad size deep inside yields nothing at all.
+// SalvageAtomsFromBinary instead keeps every atom it can decode. When it
+// reaches bytes that do not form a valid atom, it records them as a damaged
+// region and resumes at the next byte position that holds a plausible atom
+// header.
+//
+// A container whose size extends past the available input is kept, and its
+// children are read from whatever input remains.
+
+import (
+	"fmt"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// Containers nested deeper than this are treated as damage, so that hostile
+// input cannot exhaust the stack.
+const maxSalvageDepth = 1024
+
+// A DamagedRegion describes a section of binary input that could not be
+// decoded into atoms.
+type DamagedRegion struct {
+	Offset int64 // byte offset of the start of the region
+	Length int64 // length of the region in bytes, 0 if the region is only a bad container size
+
+	// Reason describes the damage found at the start of the region.
+	// It is always a *DecodeError.
+	Reason error
+
+	// Container is the salvaged container which held the region, or nil if
+	// the region is at the top level. Index is the number of atoms salvaged
+	// from that level before the region, so the damage can be shown at the
+	// point where it was found.
+	Container *Atom
+	Index     int
+}
+
+// String returns a one-line description of the damaged region.
+func (r DamagedRegion) String() string {
+	return fmt.Sprintf("damaged region at byte %d, length %d: %s", r.Offset, r.Length, r.Reason)
+}
+
+// SalvageAtomsFromBinary reads as many atoms as possible from a byte slice
+// holding binary AtomContainers, which may be damaged.
+//
+// It returns the atoms that could be decoded, arranged in their original
+// hierarchy, and a list of the damaged regions that were skipped. If the input
+// is valid, the result is the same as from ReadAtomsFromBinary and no damaged
+// regions are returned.
+func SalvageAtomsFromBinary(buf []byte) (atoms []*Atom, damaged []DamagedRegion) {
+	s := salvager{buf: buf}
+	atoms = s.readAtoms(nil, 0, int64(len(buf)))
+	return atoms, s.damaged
+}
+
+// salvager holds the state of a salvage operation.
+type salvager struct {
+	buf     []byte
+	path    []string // names of the containers being read
+	damaged []DamagedRegion
+}
+
+// readAtoms decodes the series of atoms found between the given start and end
+// byte positions, which are held by the given container.
+func (s *salvager) readAtoms(container *Atom, start, end int64) (atoms []*Atom) {
+	for pos := start; pos < end; {
+		a, next, err := s.readAtom(pos, end)
+		if err == nil {
+			atoms = append(atoms, a)
+			pos = next
+			continue
+		}
+
+		// skip to next plausible atom header
+		next = s.resync(pos+1, end)
+		s.damaged = append(s.damaged, DamagedRegion{pos, next - pos, err, container, len(atoms)})
+		pos = next
+	}
+	return atoms
+}
+
+// readAtom decodes the atom at the given byte position, including its
+// children. It returns the atom and the position that follows it.
+func (s *salvager) readAtom(pos, end int64) (a *Atom, next int64, err error) {
+	if end-pos < headerSize {
+		return nil, end, s.errorf(ErrTruncated, pos, atomHeader{},
+			"unexpected end of input at byte %d, %d bytes remain but atom header size is %d",
+			end, end-pos, headerSize)
+	}
+	h := s.header(pos)
+	if err = s.checkHeader(pos, end, h); err != nil {
+		return nil, end, err
+	}
+
+	a = &Atom{
+		name: append([]byte(nil), h.Name[:]...),
+		typ:  codec.ADEType(h.Type[:]),
+	}
+	a.Value = codec.NewCodec(&a.data, a.typ)
+	next = pos + int64(h.Size)
+
+	if !h.isContainer() {
+		data := append([]byte(nil), s.buf[pos+headerSize:next]...)
+		a.data = truncateDataToSize(a.typ, data)
+		return a, next, nil
+	}
+
+	// A container which overruns the input is kept, with the children
+	// available before the input ends. The damage is after those children.
+	overrun := -1
+	if next > end {
+		overrun = len(s.damaged)
+		s.damaged = append(s.damaged, DamagedRegion{pos, 0, s.errorf(s.overrunCause(end), pos, h,
+			"container %s at byte %d with size %d should end at byte %d, but available input ends at byte %d",
+			h.printableName(), pos, h.Size, next, end), a, 0})
+		next = end
+	}
+	s.path = append(s.path, h.printableName())
+	a.children = s.readAtoms(a, pos+headerSize, next)
+	s.path = s.path[:len(s.path)-1]
+	if overrun >= 0 {
+		s.damaged[overrun].Index = len(a.children)
+	}
+	return a, next, nil
+}
+
+// checkHeader returns an error if the atom header at the given position
+// cannot be valid. Containers may extend past the given end position, but
+// other atoms may not.
+func (s *salvager) checkHeader(pos, end int64, h atomHeader) error {
+	var (
+		name = h.printableName()
+		typ  = codec.ADEType(h.Type[:])
+	)
+	switch {
+	case !codec.IsValidType(typ):
+		return s.errorf(ErrUnknownType, pos, h, "atom %s at byte %d has unknown ADE type %q", name, pos, typ)
+	case h.Size < headerSize:
+		return s.errorf(ErrSizeMismatch, pos, h, "atom %s:%s at byte %d has invalid size %d, less than header size %d",
+			name, typ, pos, h.Size, headerSize)
+	case h.isContainer() && len(s.path) >= maxSalvageDepth:
+		return s.errorf(ErrLimitExceeded, pos, h, "container %s at byte %d exceeds limit of %d nested containers",
+			name, pos, maxSalvageDepth)
+	case !h.isContainer() && pos+int64(h.Size) > end:
+		return s.errorf(s.overrunCause(end), pos, h, "atom %s:%s at byte %d with size %d should end at byte %d, but available input ends at byte %d",
+			name, typ, pos, h.Size, pos+int64(h.Size), end)
+	case isShortData(typ, h.Size-headerSize):
+		return s.errorf(ErrSizeMismatch, pos, h, "atom %s:%s at byte %d has data size %d, less than the size %d of its type",
+			name, typ, pos, h.Size-headerSize, codec.DataSize(typ))
+	}
+	return nil
+}
+
+// resync returns the position of the first plausible atom header found
+// between the given start and end positions, or the end position if none is
+// found.
+//
+// A plausible header has a known ADE type and a size that fits within the
+// given end position. For fixed-size types, the size must also be large
+// enough to hold the data.
+func (s *salvager) resync(start, end int64) int64 {
+	for pos := start; pos+headerSize <= end; pos++ {
+		h := s.header(pos)
+		if s.checkHeader(pos, end, h) != nil || pos+int64(h.Size) > end {
+			continue
+		}
+		return pos
+	}
+	return end
+}
+
+// overrunCause returns the cause of an atom extending past the given end
+// position: the input is truncated if that is the end of the input, otherwise
+// the atom overruns its container.
+func (s *salvager) overrunCause(end int64) error {
+	if end == int64(len(s.buf)) {
+		return ErrTruncated
+	}
+	return ErrContainerOverrun
+}
+
+// header returns the atom header found at the given byte position.
+func (s *salvager) header(pos int64) atomHeader {
+	return parseAtomHeader(s.buf[pos : pos+headerSize])
+}
+
+// errorf returns a DecodeError for the atom at the given byte position.
+func (s *salvager) errorf(cause error, pos int64, h atomHeader, format string, args ...interface{}) error {
+	e := &DecodeError{
+		Err:    cause,
+		Msg:    fmt.Sprintf(format, args...),
+		Path:   containerPath(s.path),
+		Offset: pos,
+	}
+	if h.Size != 0 {
+		e.Name = h.printableName()
+		e.Type = codec.ADEType(h.Type[:])
+		e.Size = h.Size
+	}
+	return e
+}
@@ -0,0 +1,129 @@
+package ade
+
+//
+// Verify that SalvageAtomsFromBinary reads valid input the same way as
+// ReadAtomsFromBinary, and recovers the undamaged atoms of damaged input.
+//
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Return text of all atoms, for comparison
+func atomsText(atoms []*Atom) string {
+	var b strings.Builder
+	for _, a := range atoms {
+		text, err := a.MarshalText()
+		if err != nil {
+			panic(err)
+		}
+		b.Write(text)
+	}
+	return b.String()
+}
+
+func TestSalvageValidInput(t *testing.T) {
+	for _, test := range Tests {
+		want, err := ReadAtomsFromBinary(bytes.NewReader(test.binBytes))
+		if err != nil {
+			t.Fatalf("SalvageAtomsFromBinary(%s): unable to create test input: %s", test.Name(), err)
+		}
+		got, damaged := SalvageAtomsFromBinary(test.binBytes)
+		if len(damaged) != 0 {
+			t.Errorf("SalvageAtomsFromBinary(%s): valid input should have no damage, got %v", test.Name(), damaged)
+		}
+		if atomsText(got) != atomsText(want) {
+			t.Errorf("SalvageAtomsFromBinary(%s): result differs from ReadAtomsFromBinary", test.Name())
+		}
+	}
+}
+
+func TestSalvageDamagedInput(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestSalvageDamagedInput: unable to create test input: %s", err)
+	}
+	badSize := append([]byte{}, buf...)
+	badSize[86] = 0xFF // LEAF at byte 84 overruns container 0002
+	badType := append([]byte{}, buf...)
+	copy(badType[152:156], "JUNK") // LEAF at byte 144 has unknown type
+	shortData := append([]byte{}, buf...)
+	shortData[103] = 14 // LEAF at byte 100 has 2 bytes of data, too few for UI32
+	garbage := append(append([]byte{}, buf...), "garbage!"...)
+	garbage = append(garbage, buf...)
+
+	tests := []struct {
+		name        string
+		input       []byte
+		wantLeaves  string // values of LEAF atoms recovered, by container
+		wantDamaged []DamagedRegion
+	}{
+		{"truncated", buf[:150], "0001[1 2 3] 0002[4 5 6] 0003[]", []DamagedRegion{
+			{0, 0, &DecodeError{Err: ErrTruncated, Path: "/"}, &Atom{name: []byte("ROOT")}, 3},
+			{132, 0, &DecodeError{Err: ErrTruncated, Path: "/ROOT"}, &Atom{name: []byte("0003")}, 0},
+			{144, 6, &DecodeError{Err: ErrTruncated, Path: "/ROOT/0003"}, &Atom{name: []byte("0003")}, 0},
+		}},
+		{"bad size", badSize, "0001[1 2 3] 0002[5 6] 0003[7 8 9]", []DamagedRegion{
+			{84, 16, &DecodeError{Err: ErrContainerOverrun, Path: "/ROOT/0002"}, &Atom{name: []byte("0002")}, 0},
+		}},
+		{"bad type", badType, "0001[1 2 3] 0002[4 5 6] 0003[8 9]", []DamagedRegion{
+			{144, 16, &DecodeError{Err: ErrUnknownType, Path: "/ROOT/0003"}, &Atom{name: []byte("0003")}, 0},
+		}},
+		{"short data", shortData, "0001[1 2 3] 0002[4 6] 0003[7 8 9]", []DamagedRegion{
+			{100, 16, &DecodeError{Err: ErrSizeMismatch, Path: "/ROOT/0002"}, &Atom{name: []byte("0002")}, 1},
+		}},
+		{"garbage between containers", garbage, "0001[1 2 3] 0002[4 5 6] 0003[7 8 9] 0001[1 2 3] 0002[4 5 6] 0003[7 8 9]", []DamagedRegion{
+			{192, 8, &DecodeError{Err: ErrUnknownType, Path: "/"}, nil, 1},
+		}},
+	}
+	for _, test := range tests {
+		atoms, damaged := SalvageAtomsFromBinary(test.input)
+
+		// summarize recovered atoms
+		var got []string
+		for _, root := range atoms {
+			for _, c := range root.Children() {
+				var values []uint64
+				for _, leaf := range c.Children() {
+					v, _ := leaf.Value.Uint()
+					values = append(values, v)
+				}
+				got = append(got, fmt.Sprintf("%s%v", c.Name(), values))
+			}
+		}
+		if strings.Join(got, " ") != test.wantLeaves {
+			t.Errorf("SalvageAtomsFromBinary(%s): got atoms %q, want %q", test.name, strings.Join(got, " "), test.wantLeaves)
+		}
+
+		if len(damaged) != len(test.wantDamaged) {
+			t.Errorf("SalvageAtomsFromBinary(%s): got %d damaged regions, want %d: %v", test.name, len(damaged), len(test.wantDamaged), damaged)
+			continue
+		}
+		for i, want := range test.wantDamaged {
+			got := damaged[i]
+			wantErr := want.Reason.(*DecodeError)
+			var gotErr *DecodeError
+			if got.Offset != want.Offset || got.Length != want.Length ||
+				!errors.As(got.Reason, &gotErr) || gotErr.Err != wantErr.Err || gotErr.Path != wantErr.Path {
+				t.Errorf("SalvageAtomsFromBinary(%s): region %d: got {%d %d %v}, want {%d %d cause %q path %s}", test.name, i,
+					got.Offset, got.Length, got.Reason, want.Offset, want.Length, wantErr.Err, wantErr.Path)
+			}
+			if containerName(got.Container) != containerName(want.Container) || got.Index != want.Index {
+				t.Errorf("SalvageAtomsFromBinary(%s): region %d: got index %d in container %q, want index %d in %q", test.name, i,
+					got.Index, containerName(got.Container), want.Index, containerName(want.Container))
+			}
+		}
+	}
+}
+
+// Return the name of a container, or "" for the top level
+func containerName(a *Atom) string {
+	if a == nil {
+		return ""
+	}
+	return a.Name()
+}
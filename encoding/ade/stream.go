@@ -0,0 +1,101 @@
+package ade
+
+// Reading binary AtomContainers one at a time from a long-lived stream, such
+// as a socket or pipe carrying back-to-back containers.
+//
+// The size in the header of each top-level atom tells exactly how many bytes
+// belong to it, so a container can be read without reading past its end. The
+// stream is left positioned at the start of the next container.
+
+import (
+	"bytes"
+	"io"
+)
+
+// ReadAtom reads exactly one binary AtomContainer from r, and returns it.
+// No bytes beyond the end of the container are read from r.
+//
+// If r has no more input, ReadAtom returns io.EOF. If r ends within the
+// container, the error is a *DecodeError with cause ErrTruncated. Byte offsets
+// in errors are relative to the start of the container.
+//
+// If DecodeOptions are given, their limits are enforced on the container.
+// Otherwise DefaultDecodeOptions are used.
+func ReadAtom(r io.Reader, opts ...DecodeOptions) (*Atom, error) {
+	var (
+		buf    [headerSize]byte
+		offset int64
+	)
+	h, err := readAtomHeader(r, &buf, &offset)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		return nil, &DecodeError{Err: ErrTruncated, Path: "/",
+			Msg: "unexpected end of input, in atom header at byte 0"}
+	case err != nil:
+		return nil, err
+	}
+
+	// Decode the header again along with the rest of the container, so the
+	// Decoder can check it. An invalid size is reported by the Decoder.
+	var size = int64(h.Size)
+	if size < headerSize {
+		size = headerSize
+	}
+	input := io.MultiReader(bytes.NewReader(buf[:]), io.LimitReader(r, size-headerSize))
+	atoms, err := ReadAtomsFromBinary(input, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return atoms[0], nil
+}
+
+// A ContainerScanner reads a series of binary AtomContainers from a stream,
+// returning them one at a time.
+//
+// Call Scan to read each container, then Atom to get it. Scan returns false
+// at the end of the stream or on error; Err then reports any error.
+//
+//	s := ade.NewContainerScanner(conn)
+//	for s.Scan() {
+//	    handle(s.Atom())
+//	}
+//	if err := s.Err(); err != nil {
+//	    log.Fatal(err)
+//	}
+type ContainerScanner struct {
+	r    io.Reader
+	opts []DecodeOptions
+	atom *Atom
+	err  error
+}
+
+// NewContainerScanner returns a new ContainerScanner that reads binary
+// AtomContainers from r. If DecodeOptions are given, their limits are enforced
+// on each container. Otherwise DefaultDecodeOptions are used.
+func NewContainerScanner(r io.Reader, opts ...DecodeOptions) *ContainerScanner {
+	return &ContainerScanner{r: r, opts: opts}
+}
+
+// Scan reads the next container from the stream, which is then available
+// from Atom. It returns false when the stream ends or an error occurs.
+func (s *ContainerScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.atom, s.err = ReadAtom(s.r, s.opts...)
+	return s.err == nil
+}
+
+// Atom returns the container read by the most recent call to Scan.
+func (s *ContainerScanner) Atom() *Atom {
+	return s.atom
+}
+
+// Err returns the first error that occurred while reading the stream. It
+// returns nil if the stream ended cleanly between containers.
+func (s *ContainerScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
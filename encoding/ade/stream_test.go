@@ -0,0 +1,91 @@
+package ade
+
+//
+// Verify that containers can be read one at a time from a stream, without
+// reading past the end of each container.
+//
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadAtom(t *testing.T) {
+	first, _ := TestAtom1.MarshalBinary()
+	second, _ := TestAtom2.MarshalBinary()
+	stream := bytes.NewReader(append(append([]byte{}, first...), second...))
+
+	a, err := ReadAtom(iotest.OneByteReader(stream))
+	if err != nil {
+		t.Fatalf("ReadAtom: expect no error, got %s", err)
+	}
+	if got, want := atomsText([]*Atom{a}), atomsText([]*Atom{TestAtom1}); got != want {
+		t.Errorf("ReadAtom: got atom\n%s\nwant\n%s", got, want)
+	}
+	if stream.Len() != len(second) {
+		t.Errorf("ReadAtom: got %d bytes left in stream, want %d", stream.Len(), len(second))
+	}
+
+	if _, err = ReadAtom(stream); err != nil {
+		t.Errorf("ReadAtom: expect no error on second atom, got %s", err)
+	}
+	if _, err = ReadAtom(stream); err != io.EOF {
+		t.Errorf("ReadAtom: at end of stream, got err %v, want io.EOF", err)
+	}
+}
+
+func TestReadAtomInvalid(t *testing.T) {
+	buf, _ := TestAtom1.MarshalBinary()
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"truncated header", buf[:8], ErrTruncated},
+		{"truncated data", buf[:100], ErrTruncated},
+		{"invalid size", []byte("\x00\x00\x00\x04ROOTCONT"), ErrSizeMismatch},
+	}
+	for _, test := range tests {
+		_, err := ReadAtom(bytes.NewReader(test.input))
+		if !errors.Is(err, test.want) {
+			t.Errorf("ReadAtom(%s): got err {%v}, want cause {%s}", test.name, err, test.want)
+		}
+	}
+}
+
+func TestContainerScanner(t *testing.T) {
+	var stream bytes.Buffer
+	for _, test := range Tests {
+		stream.Write(test.binBytes)
+	}
+
+	s := NewContainerScanner(&stream)
+	var i int
+	for ; s.Scan(); i++ {
+		if i >= len(Tests) {
+			break
+		}
+		if got, want := atomsText([]*Atom{s.Atom()}), atomsText([]*Atom{Tests[i].atom}); got != want {
+			t.Errorf("ContainerScanner(%s): result differs from test atom", Tests[i].Name())
+		}
+	}
+	if i != len(Tests) {
+		t.Errorf("ContainerScanner: got %d containers, want %d", i, len(Tests))
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("ContainerScanner: expect no error, got %s", err)
+	}
+
+	// stream ends within a container
+	buf, _ := TestAtom1.MarshalBinary()
+	s = NewContainerScanner(bytes.NewReader(append(buf, buf[:50]...)))
+	if !s.Scan() || s.Scan() {
+		t.Errorf("ContainerScanner: expect 1 container before truncated one")
+	}
+	if err := s.Err(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("ContainerScanner: got err {%v}, want cause {%s}", err, ErrTruncated)
+	}
+}
@@ -0,0 +1,96 @@
+package ade
+
+// Tables of atom values, for handling AtomContainers as rows. A container
+// often holds many child containers of the same name, such as one per node:
+//
+//	NODS:CONT:
+//		NODE:CONT:
+//			NAME:CSTR:"dc1-s1"
+//			CAPA:UI64:1000
+//		END
+//		NODE:CONT:
+//			NAME:CSTR:"dc1-s2"
+//			CAPA:UI64:2000
+//		END
+//	END
+//
+// Table(root, "//NODE", "NAME", "CAPA") returns a row for each NODE:
+//
+//	["dc1-s1", uint64(1000)]
+//	["dc1-s2", uint64(2000)]
+
+import (
+	"fmt"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// Table returns a row of values for each atom matching rowPath, with a column
+// for each of the given paths. Column paths are relative to the row atom, so
+// "NAME" selects children of the row named NAME, and "INFO/NAME" selects
+// children named NAME of the row's INFO children.
+//
+// A cell holds the value of the first atom the column path selects, as a Go
+// type matching its ADE type:
+//
+//	bool     UI01
+//	uint64   UI08, UI16, UI32, UI64
+//	int64    SI08, SI16, SI32, SI64, ENUM
+//	float64  FP32, FP64, UF32, UF64, SF32, SF64
+//	string   all other types, in the same form as ContainerText without
+//	         delimiters or escaping
+//
+// A cell is nil if the column path selects no atoms, or the atom has no value
+// because it is a container or has type NULL.
+//
+// An error is returned if any path is invalid, or if a value can't be
+// decoded.
+func Table(root *Atom, rowPath string, columns ...string) (rows [][]interface{}, err error) {
+	rowAtoms, err := root.AtomsAtPath(rowPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths = make([]*AtomPath, len(columns))
+	for i, col := range columns {
+		if paths[i], err = NewAtomPath("/*/" + col); err != nil {
+			return nil, fmt.Errorf("invalid column %q: %s", col, err)
+		}
+	}
+
+	for _, row := range rowAtoms {
+		var cells = make([]interface{}, len(columns))
+		for i, ap := range paths {
+			atoms, err := ap.GetAtoms(row)
+			if err != nil {
+				return nil, fmt.Errorf("invalid column %q: %s", columns[i], err)
+			}
+			if len(atoms) == 0 {
+				continue
+			}
+			if cells[i], err = tableValue(atoms[0]); err != nil {
+				return nil, fmt.Errorf("unable to read column %q from atom %s:%s: %s", columns[i], atoms[0].Name(), atoms[0].Type(), err)
+			}
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+// tableValue returns the atom value as the Go type used by Table.
+func tableValue(a *Atom) (interface{}, error) {
+	switch {
+	case a.typ == codec.CONT, a.typ == codec.NULL:
+		return nil, nil
+	case a.Value.IsBool():
+		return a.Value.Bool()
+	case a.Value.IsUint():
+		return a.Value.Uint()
+	case a.Value.IsInt(), a.typ == codec.ENUM:
+		return a.Value.Int()
+	case a.Value.IsFloat():
+		return a.Value.Float()
+	default:
+		return a.Value.String()
+	}
+}
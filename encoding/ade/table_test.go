@@ -0,0 +1,52 @@
+package ade
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	const nodes = "NODS:CONT:\n" +
+		"\tNODE:CONT:\n\t\tNAME:CSTR:\"dc1-s1\"\n\t\tCAPA:UI64:1000\n\t\tUSED:FP64:0.5\n" +
+		"\t\tINFO:CONT:\n\t\t\tZONE:SI32:-3\n\t\t\tSTAT:ENUM:2\n\t\tEND\n\tEND\n" +
+		"\tNODE:CONT:\n\t\tNAME:CSTR:\"dc1-s2\"\n\t\tCAPA:UI64:2000\n\t\tONLN:UI01:1\n\t\tNONE:NULL:\n\tEND\n" +
+		"\tDISK:CONT:\n\t\tNAME:CSTR:\"sda\"\n\tEND\n" +
+		"END\n"
+	tests := []struct {
+		rowPath string
+		columns []string
+		want    [][]interface{}
+		wantErr bool
+	}{
+		{"//NODE", []string{"NAME", "CAPA"}, [][]interface{}{
+			{"dc1-s1", uint64(1000)},
+			{"dc1-s2", uint64(2000)}}, false},
+		{"/NODS/NODE", []string{"USED", "ONLN", "NONE", "INFO", "MISS"}, [][]interface{}{
+			{0.5, nil, nil, nil, nil},
+			{nil, true, nil, nil, nil}}, false},
+		{"//NODE", []string{"INFO/ZONE", "INFO/STAT"}, [][]interface{}{
+			{int64(-3), int64(2)},
+			{nil, nil}}, false},
+		{"/NODS/*", []string{"NAME"}, [][]interface{}{{"dc1-s1"}, {"dc1-s2"}, {"sda"}}, false},
+		{"//NODE[2]", []string{"NAME"}, [][]interface{}{{"dc1-s2"}}, false},
+		{"//*[NAME = sda]", []string{"NAME", "CAPA"}, [][]interface{}{{"sda", nil}}, false},
+		{"//MISS", []string{"NAME"}, nil, false},
+		{"//NODE", nil, [][]interface{}{{}, {}}, false},
+		{"//NODE/", []string{"NAME"}, nil, true},
+		{"//NODE", []string{"NAME", "CAPA/"}, nil, true},
+	}
+	var a = new(Atom)
+	if err := a.UnmarshalText([]byte(nodes)); err != nil {
+		t.Fatalf("Table: unable to create test atom: %s", err)
+	}
+	for _, test := range tests {
+		got, err := Table(a, test.rowPath, test.columns...)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Table(%s, %q): got err {%v}, want error %t", test.rowPath, test.columns, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Table(%s, %q): got %#v, want %#v", test.rowPath, test.columns, got, test.want)
+		}
+	}
+}
@@ -64,7 +64,7 @@ func atomToTextBuffer(a *Atom, depth int) (output bytes.Buffer, err error) {
 
 	if a.typ == codec.CONT {
 		// write children
-		for _, childPtr := range a.children {
+		for _, childPtr := range a.Children() {
 			buf, err := atomToTextBuffer(childPtr, depth+1)
 			if err != nil {
 				return output, err
@@ -156,6 +156,7 @@ type (
 		typ   tokenEnum // type of token, such as tokenAtomName/tokenAtomType
 		value string    // Value, such as "23.2"
 		line  uint32    // line number at the start of this line
+		col   uint32    // column number of the start of the token
 	}
 
 	// lexer holds the state of the scanner
@@ -167,6 +168,7 @@ type (
 		pos           uint32     // current string offset
 		lineNumber    uint32     // 1+number of newlines seen
 		prevTokenType tokenEnum  // type of previous token emitted
+		depth         int        // number of predicates open, when lexing a path
 	}
 )
 
@@ -255,7 +257,7 @@ func (l *lexer) acceptRun(valid string) int {
 
 // token emitter
 func (l *lexer) emit(t tokenEnum) {
-	l.tokens <- token{t, l.input[l.start:l.pos], l.lineNumber}
+	l.tokens <- token{t, l.input[l.start:l.pos], l.lineNumber, l.column(l.start)}
 	l.start = l.pos
 	l.prevTokenType = t
 }
@@ -272,23 +274,28 @@ func (l *lexer) buffer() string {
 
 // Return the entire current line as a string
 func (l *lexer) line() string {
-	var iStart, iEnd uint32
+	var end = int(l.pos)
+	if end > len(l.input) {
+		end = len(l.input)
+	}
 
-	// find line end
-	if l.input[l.pos] == '\n' {
-		iEnd = l.pos
-		l.backup() // at line end, take preceding line
+	// find line end. At line end, take preceding line.
+	if i := strings.IndexByte(l.input[end:], '\n'); i >= 0 {
+		end += i
 	} else {
-		for iEnd = l.pos; l.input[iEnd] != '\n'; iEnd++ {
-		}
+		end = len(l.input)
 	}
 
 	// find line start
-	for iStart = l.pos; l.input[iStart] != '\n' && iStart != 0; iStart-- {
-	}
-	iStart++
+	start := strings.LastIndexByte(l.input[:end], '\n') + 1
+	return l.input[start:end]
+}
 
-	return l.input[iStart:iEnd]
+// column returns the column number of an input position within its line.
+// Columns count characters, starting from 1.
+func (l *lexer) column(pos uint32) uint32 {
+	lineStart := strings.LastIndexByte(l.input[:pos], '\n') + 1
+	return uint32(utf8.RuneCountInString(l.input[lineStart:pos])) + 1
 }
 
 // first returns the first rune in the value
@@ -310,7 +317,9 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 			fmt.Sprintf(format, args...),
 		}, ""),
 		l.lineNumber,
+		l.column(l.pos),
 	}
+	l.prevTokenType = tokenError
 	return nil
 }
 
@@ -555,7 +564,7 @@ func lexFourCharCode(l *lexer) stateFn {
 	}
 
 	if err != nil {
-		return l.errorf(err.Error())
+		return l.errorf("%s", err)
 	}
 
 	l.emit(tokenType)
@@ -615,6 +624,10 @@ func lexEndOfLine(l *lexer) stateFn {
 		l.ignore()
 		return lexLine
 	}
+	if l.peek() == eof {
+		l.ignore()
+		return lexLine // last line lacks a newline
+	}
 	return l.errorf("trailing characters at end of line: %s", l.line())
 }
 
@@ -685,6 +698,7 @@ type (
 		containers atomStack    // containers kept in a stack to track hierarchy
 		atoms      []*Atom      // array of output atoms
 		line       uint32       // 1+number of newlines seen
+		col        uint32       // column of the most recent token
 		tokens     <-chan token // source of token text strings
 		err        error        // indicates parsing succeeded or describes what failed
 	}
@@ -750,18 +764,46 @@ func readToken(p *parser) (tk token) {
 			}
 		}
 	}
+	p.line = tk.line
+	p.col = tk.col
 	if tk.typ == tokenError {
-		p.err = fmt.Errorf("line %d: %s", tk.line, tk.value)
+		p.errorf("%s", tk.value)
 	}
-	p.line = tk.line
 	return
 }
+
+// errorf records a syntax error at the location of the most recent token.
+// Only the first error is kept.
 func (p *parser) errorf(format string, args ...interface{}) parseFunc {
-	p.err = fmt.Errorf(
-		strings.Join([]string{
-			fmt.Sprintf("parse error on line %d: ", p.line),
-			fmt.Sprintf(format, args...),
-		}, ""))
+	return p.errorfCause(ErrSyntax, format, args...)
+}
+
+// errorfCause records a DecodeError with the given cause at the location of
+// the most recent token. Only the first error is kept.
+func (p *parser) errorfCause(cause error, format string, args ...interface{}) parseFunc {
+	if p.err != nil {
+		return nil
+	}
+	e := &DecodeError{
+		Err:    cause,
+		Msg:    fmt.Sprintf(format, args...),
+		Line:   int(p.line),
+		Column: int(p.col),
+	}
+
+	// locate the error within the open containers, excluding the atom itself
+	var names []string
+	for _, c := range p.containers {
+		if c != p.theAtom {
+			names = append(names, c.Name())
+		}
+	}
+	e.Path = containerPath(names)
+	if a := p.theAtom; a != nil && len(a.name) == 4 {
+		e.Name = a.Name()
+		e.Type = a.typ
+	}
+	p.err = e
 	return nil
 }
 func (ptr *atomStack) push(a *Atom) {
@@ -799,24 +841,23 @@ func parseAtomName(p *parser) parseFunc {
 	switch tk.typ {
 	case tokenAtomName: // may be hex or 4 printable chars
 		if e := codec.StringToFC32Bytes(&p.theAtom.name, tk.value); e != nil {
-			return p.errorf(fmt.Sprint("invalid atom name: ", tk.value))
+			return p.errorf("invalid atom name: %s", tk.value)
 		}
 	case tokenError:
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	case tokenEOF:
 		return nil
 	case tokenContainerEnd:
 		return parseContainerEnd(p)
 	default:
-		return p.errorf("line %d: expecting atom name, got %s", tk.line, tk.typ)
+		return p.errorf("expecting atom name, got %s", tk.typ)
 	}
 	return parseAtomType
 }
 
 func parseContainerEnd(p *parser) parseFunc {
 	if p.containers.empty() {
-		p.err = fmt.Errorf("got END but there are no open containers")
-		return nil
+		return p.errorf("got END but there are no open containers")
 	}
 	cont := p.containers.pop()
 	if p.containers.empty() {
@@ -829,7 +870,7 @@ func parseContainerEnd(p *parser) parseFunc {
 func parseAtomType(p *parser) parseFunc {
 	tk := readToken(p)
 	if tk.typ == tokenError {
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	}
 	if tk.typ == tokenEOF {
 		return p.errorf("end of input while parsing atom %s", p.theAtom.Name())
@@ -839,6 +880,9 @@ func parseAtomType(p *parser) parseFunc {
 	if tk.typ != tokenAtomType {
 		return p.errorf("expecting token type tokenAtomType, got %s", tk.typ)
 	}
+	if !codec.IsValidType(codec.ADEType(tk.value)) {
+		return p.errorfCause(ErrUnknownType, "atom %s has unknown ADE type %q", p.theAtom.Name(), tk.value)
+	}
 	p.theAtom.SetType(codec.ADEType(tk.value))
 
 	// Add atom to children of parent, if any
@@ -862,7 +906,7 @@ func parseAtomType(p *parser) parseFunc {
 func parseAtomData(p *parser) parseFunc {
 	parseFunc := parseType[p.theAtom.typ]
 	if parseFunc == nil {
-		return p.errorf("no data parse function defined for type %s", p.theAtom.Type())
+		return p.errorfCause(ErrUnknownType, "no data parse function defined for type %s", p.theAtom.Type())
 	}
 	retval := parseFunc(p)
 	if retval == nil { // nil function returned means error
@@ -874,7 +918,7 @@ func parseAtomData(p *parser) parseFunc {
 func parseNumber(p *parser) parseFunc {
 	tk := readToken(p)
 	if tk.typ == tokenError {
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	}
 	if tk.typ != tokenNumber {
 		return p.errorf("expected atom data with type Number, got type %s", tk.typ)
@@ -882,7 +926,7 @@ func parseNumber(p *parser) parseFunc {
 
 	err := p.theAtom.Value.SetString(tk.value)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%s", err)
 	}
 	return parseAtomName
 }
@@ -909,7 +953,7 @@ func parseFraction(p *parser) parseFunc {
 	// Send tokens for type conversion
 	err := p.theAtom.Value.SetString(strings.Join(values, ""))
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%s", err)
 	}
 
 	return parseAtomName
@@ -921,7 +965,7 @@ func parseFC32Value(p *parser) parseFunc {
 	case tokenFC32Hex, tokenFC32Quoted:
 		p.theAtom.Value.SetString(tk.value)
 	case tokenError:
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	default:
 		return p.errorf("expected atom data with type FC32, got type %s", tk.typ)
 	}
@@ -940,7 +984,7 @@ func parseIP32Value(p *parser) parseFunc {
 
 	err := p.theAtom.Value.SetString(tk.value)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%s", err)
 	}
 	return parseAtomName
 }
@@ -948,12 +992,12 @@ func parseIP32Value(p *parser) parseFunc {
 func parseString(p *parser) parseFunc {
 	tk := readToken(p)
 	if tk.typ == tokenError {
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	}
 
 	err := p.theAtom.Value.SetString(tk.value)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%s", err)
 	}
 	return parseAtomName
 }
@@ -961,12 +1005,12 @@ func parseString(p *parser) parseFunc {
 func parseStringDelimited(p *parser) parseFunc {
 	tk := readToken(p)
 	if tk.typ == tokenError {
-		return p.errorf(tk.value)
+		return p.errorf("%s", tk.value)
 	}
 
 	err := p.theAtom.Value.SetStringDelimited(tk.value)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%s", err)
 	}
 	return parseAtomName
 }
@@ -42,6 +42,30 @@ func TestUnmarshalText(t *testing.T) {
 	}
 }
 
+func TestUnmarshalTextNoFinalNewline(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"NULL:NULL:", true},
+		{"BVER:UI32:6", true},
+		{"BVER:UI32:6  ", true},
+		{"ROOT:CONT:\n\tBVER:UI32:6\nEND", true},
+		{"BVER:UI32:6 x", false},
+		{"ROOT:CONT:\n\tBVER:UI32:6", false},
+	}
+	for _, test := range tests {
+		var a Atom
+		err := a.UnmarshalText([]byte(test.input))
+		if test.valid && err != nil {
+			t.Errorf("UnmarshalText(%q): expect no error, got %s", test.input, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("UnmarshalText(%q): expect error, got none", test.input)
+		}
+	}
+}
+
 func checkFailedTest(t *testing.T) {
 	if t.Failed() && testWriteDebugFiles {
 		fmt.Println("text_test.go: failed test results are available for inspection here: ", failedOutputDir)
@@ -0,0 +1,389 @@
+package ade
+
+// Conversion of Atoms to Go values, using the same struct field tags as
+// Marshal. Each child atom of a container is stored in the struct field whose
+// tag has the same atom name.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// Causes of an UnmarshalError.
+var (
+	// ErrUnknownAtom means that an atom has no struct field to hold it, in
+	// strict mode.
+	ErrUnknownAtom = errors.New("unknown atom")
+
+	// ErrTypeMismatch means that an atom value can't be stored in the Go
+	// value, or in strict mode that the atom does not have the ADE type of the
+	// struct field.
+	ErrTypeMismatch = errors.New("atom type mismatch")
+
+	// ErrRepeatedAtom means that an atom is repeated, but its struct field
+	// holds only one atom, in strict mode.
+	ErrRepeatedAtom = errors.New("repeated atom")
+)
+
+// An UnmarshalError describes an atom that could not be stored in a Go value,
+// and where it was found.
+type UnmarshalError struct {
+	// Cause of the error: ErrUnknownAtom, ErrTypeMismatch, ErrRepeatedAtom or
+	// an error returned by an Unmarshaler.
+	Err error
+	Msg string // description of the problem
+
+	// Path of the offending atom, such as /GRID/NODE[2]/ADDR[1], which selects
+	// only that atom when used with AtomsAtPath on the root atom.
+	Path string
+	Type codec.ADEType // ADE type of the offending atom
+}
+
+// Error returns the description of the problem along with its location.
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf("%s (path %s)", e.Msg, e.Path)
+}
+
+// Unwrap returns the cause of the error, for use by errors.Is.
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// Unmarshaler is the interface implemented by types that can set themselves
+// from an atom.
+type Unmarshaler interface {
+	UnmarshalADE(a *Atom) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// UnmarshalADE sets the receiver to a copy of the atom, so that a struct field
+// of type *Atom may hold an atom of any type.
+//
+// It implements the Unmarshaler interface.
+func (a *Atom) UnmarshalADE(atom *Atom) error {
+	c := copyAtom(atom)
+	a.name, a.typ, a.data, a.children, a.lazy = c.name, c.typ, c.data, c.children, nil
+	a.Value = codec.NewCodec(&a.data, a.typ)
+	return nil
+}
+
+// UnmarshalOptions control how Unmarshal stores atoms in Go values.
+type UnmarshalOptions struct {
+	// Strict makes it an error for an atom to have no struct field, to have
+	// an ADE type other than the type of its struct field, or to be repeated
+	// when its struct field holds only one atom. The type of a field is given
+	// by its tag, or chosen from its Go type as Marshal does.
+	Strict bool
+}
+
+// Unmarshal stores the atom in the value pointed to by v, which is typically
+// a struct with fields tagged as described for Marshal.
+//
+// A container is stored in a struct by storing each child atom in the field
+// tagged with its name. If the struct has an ADEName field, its tag must give
+// the name of the atom. Fields without an atom are left unchanged, and atoms
+// without a field are ignored. A container may also be stored in a map with
+// string keys, holding an element for each child atom, keyed by atom name.
+//
+// Each repeated atom is appended to a slice, except for slices holding a
+// fraction value as described for Marshal. A repeated fraction atom needs a
+// slice of slices. Pointers are allocated as needed, so a pointer field is nil
+// if there is no atom for it. Types implementing Unmarshaler set themselves,
+// and a field of type *Atom holds an atom of any type.
+//
+// Atom values are converted to the Go type of the field using the atom's
+// Codec, so a field may hold an atom of any ADE type with a compatible value.
+// Any atom value can be stored in a string, in the same form as ContainerText
+// without delimiters or escaping. A CSTR value keeps its bytes in the string,
+// even those that are not valid UTF-8.
+//
+// If UnmarshalOptions are given, they control how atoms are stored.
+// Problems with atoms are reported as an *UnmarshalError giving the path of
+// the atom.
+func Unmarshal(a *Atom, v interface{}, opts ...UnmarshalOptions) error {
+	var rv = reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unable to unmarshal into %s, expecting a non-nil pointer", typeName(rv))
+	}
+	var u = unmarshalState{}
+	if len(opts) > 0 {
+		u.UnmarshalOptions = opts[0]
+	}
+
+	var path = "/" + pathStepName(a)
+	if t := rv.Elem().Type(); t.Kind() == reflect.Struct {
+		if f, ok := t.FieldByName("ADEName"); ok && f.Tag.Get("ade") != "" {
+			name, err := structName(t)
+			if err != nil {
+				return err
+			}
+			if !nameMatches(a.Name(), name) {
+				return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal atom %s into %s, expecting atom %s", a.Name(), t, name)
+			}
+		}
+	}
+	return u.unmarshalValue(a, "", rv.Elem(), path)
+}
+
+// unmarshalState holds the state of a call to Unmarshal.
+type unmarshalState struct {
+	UnmarshalOptions
+}
+
+// errorf returns an UnmarshalError for the atom at the given path.
+func (u *unmarshalState) errorf(cause error, path string, a *Atom, format string, args ...interface{}) error {
+	return &UnmarshalError{Err: cause, Msg: fmt.Sprintf(format, args...), Path: path, Type: a.typ}
+}
+
+// unmarshalValue stores the atom at the given path in the Go value. The type
+// is the ADE type given by a struct tag, if any.
+func (u *unmarshalState) unmarshalValue(a *Atom, typ codec.ADEType, v reflect.Value, path string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return u.unmarshalValue(a, typ, v.Elem(), path)
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(unmarshalerType) {
+		if err := v.Addr().Interface().(Unmarshaler).UnmarshalADE(a); err != nil {
+			return &UnmarshalError{Err: err, Msg: err.Error(), Path: path, Type: a.typ}
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		if isRepeated(v.Type(), typ) {
+			var elem = reflect.New(v.Type().Elem()).Elem()
+			if err := u.unmarshalValue(a, typ, elem, path); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, elem))
+			return nil
+		}
+	case reflect.Map:
+		return u.unmarshalMap(a, typ, v, path)
+	case reflect.Struct:
+		return u.unmarshalStruct(a, v, path)
+	}
+
+	if a.typ == codec.CONT {
+		return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal container %s into Go type %s", a.Name(), v.Type())
+	}
+	if u.Strict {
+		if want := fieldType(typ, v.Type()); a.typ != want {
+			return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal atom %s:%s, expecting type %s", a.Name(), a.typ, want)
+		}
+	}
+	if err := getReflectValue(a, v); err != nil {
+		return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal atom %s:%s into Go type %s: %s", a.Name(), a.typ, v.Type(), err)
+	}
+	return nil
+}
+
+// unmarshalStruct stores the children of the container at the given path in
+// the fields of the struct.
+func (u *unmarshalState) unmarshalStruct(a *Atom, v reflect.Value, path string) error {
+	if a.typ != codec.CONT {
+		return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal atom %s:%s into struct %s, expecting a container", a.Name(), a.typ, v.Type())
+	}
+	fields, err := structFields(v.Type())
+	if err != nil {
+		return err
+	}
+	var byName = make(map[string]fieldInfo, len(fields))
+	for i := len(fields) - 1; i >= 0; i-- {
+		byName[fields[i].name] = fields[i] // first field of each name wins
+	}
+
+	var count = make(map[string]int)
+	for _, child := range a.Children() {
+		var (
+			cpath = childPath(path, child, count)
+			f, ok = byName[child.Name()]
+		)
+		if !ok {
+			if u.Strict {
+				return u.errorf(ErrUnknownAtom, cpath, child, "no field of %s for atom %s:%s", v.Type(), child.Name(), child.typ)
+			}
+			continue
+		}
+		var field = v.FieldByIndex(f.index)
+		if u.Strict && count[pathStepName(child)] > 1 && !holdsRepeated(field.Type(), f.typ) {
+			return u.errorf(ErrRepeatedAtom, cpath, child, "atom %s is repeated, but field %s holds only one atom", child.Name(), f.goName)
+		}
+		if err := u.unmarshalValue(child, f.typ, field, cpath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalMap stores the children of the container at the given path in the
+// map, keyed by atom name. The type is the ADE type of the map elements.
+func (u *unmarshalState) unmarshalMap(a *Atom, typ codec.ADEType, v reflect.Value, path string) error {
+	if a.typ != codec.CONT {
+		return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal atom %s:%s into map %s, expecting a container", a.Name(), a.typ, v.Type())
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return u.errorf(ErrTypeMismatch, path, a, "unable to unmarshal container %s into map %s, map keys must be strings", a.Name(), v.Type())
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+
+	var count = make(map[string]int)
+	for _, child := range a.Children() {
+		var (
+			key  = reflect.ValueOf(child.Name()).Convert(v.Type().Key())
+			elem = reflect.New(v.Type().Elem()).Elem()
+		)
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing) // repeated atoms append to a slice
+		}
+		if err := u.unmarshalValue(child, typ, elem, childPath(path, child, count)); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+// holdsRepeated returns true if a struct field of the Go type holds repeated
+// atoms of the ADE type, rather than a single atom.
+func holdsRepeated(t reflect.Type, typ codec.ADEType) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Slice && isRepeated(t, typ)
+}
+
+// fieldType returns the ADE type given by a struct tag, or chosen from the Go
+// type if the tag does not give one.
+func fieldType(typ codec.ADEType, t reflect.Type) codec.ADEType {
+	if typ != "" {
+		return typ
+	}
+	return defaultType(t)
+}
+
+// getReflectValue sets a Go value of a basic kind from the atom value.
+func getReflectValue(a *Atom, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := a.Value.Bool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if a.Value.IsInt() || a.typ == codec.ENUM {
+			i, err := a.Value.Int()
+			if err != nil {
+				return err
+			}
+			if i < 0 {
+				return fmt.Errorf("value %d is negative", i)
+			}
+			n = uint64(i)
+		} else {
+			var err error
+			if n, err = a.Value.Uint(); err != nil {
+				return err
+			}
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("value %d overflows Go type %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if a.Value.IsUint() || a.Value.IsBool() {
+			n, err := a.Value.Uint()
+			if err != nil {
+				return err
+			}
+			if n > math.MaxInt64 {
+				return fmt.Errorf("value %d overflows Go type %s", n, v.Type())
+			}
+			i = int64(n)
+		} else {
+			var err error
+			if i, err = a.Value.Int(); err != nil {
+				return err
+			}
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("value %d overflows Go type %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := a.Value.Float()
+		if err != nil {
+			return err
+		}
+		if v.OverflowFloat(f) && !math.IsInf(f, 0) {
+			return fmt.Errorf("value %g overflows Go type %s", f, v.Type())
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		s, err := a.Value.String()
+		if err != nil {
+			return err
+		}
+		if a.typ == codec.CSTR {
+			s = string(a.data[:len(a.data)-1]) // bytes that are not UTF-8 are kept, not escaped
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		return getReflectList(a, v)
+	default:
+		return fmt.Errorf("unsupported Go type")
+	}
+	return nil
+}
+
+// getReflectList sets a slice of bytes or numbers from the atom value.
+func getReflectList(a *Atom, v reflect.Value) error {
+	switch v.Type().Elem().Kind() {
+	case reflect.Uint8:
+		data, _ := a.Value.SliceOfByte()
+		v.SetBytes(append([]byte(nil), data...))
+		return nil
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		list, err := a.Value.SliceOfUint()
+		if err != nil {
+			return err
+		}
+		var s = reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, n := range list {
+			if s.Index(i).OverflowUint(n) {
+				return fmt.Errorf("value %d overflows Go type %s", n, v.Type().Elem())
+			}
+			s.Index(i).SetUint(n)
+		}
+		v.Set(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		list, err := a.Value.SliceOfInt()
+		if err != nil {
+			return err
+		}
+		var s = reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, n := range list {
+			if s.Index(i).OverflowInt(n) {
+				return fmt.Errorf("value %d overflows Go type %s", n, v.Type().Elem())
+			}
+			s.Index(i).SetInt(n)
+		}
+		v.Set(s)
+		return nil
+	}
+	return fmt.Errorf("unsupported Go type")
+}
@@ -0,0 +1,251 @@
+package ade
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+func (p *testPerms) UnmarshalADE(a *Atom) error {
+	s, err := a.Value.String()
+	if err != nil {
+		return err
+	}
+	*p = nil
+	if s != "" {
+		*p = strings.Split(s, ",")
+	}
+	return nil
+}
+
+func (z *testZone) UnmarshalADE(a *Atom) error {
+	if a.Type() != "ENUM" {
+		return errors.New("zone must be an ENUM")
+	}
+	i, err := a.Value.Int()
+	*z = testZone(i)
+	return err
+}
+
+func TestUnmarshal(t *testing.T) {
+	var zone = testZone(3)
+	var want = testGrid{
+		Version: 6,
+		Time:    1484723582627327,
+		Name:    "grid",
+		Note:    "note",
+		Ratio:   1.5,
+		Online:  true,
+		Nodes: []testNode{
+			{ID: 12001, Addr: "10.0.0.1", Perms: testPerms{"READ", "WRITE"}, Roles: []string{"ADMN", "STOR"}, Zone: &zone},
+			{ID: -1, Addr: "10.0.0.2"},
+		},
+		Primary:     &testNode{ID: 7, Addr: "10.0.0.7"},
+		Values:      map[string]uint32{"0x00000001": 908767, "0x00000000": 2},
+		Key:         []byte{0xde, 0xad},
+		testVersion: testVersion{Major: 10},
+	}
+	a, err := Marshal(want)
+	if err != nil {
+		t.Fatalf("Unmarshal: unable to create test atom: %s", err)
+	}
+	for _, opts := range []UnmarshalOptions{{}, {Strict: true}} {
+		var got testGrid
+		if err := Unmarshal(a, &got, opts); err != nil {
+			t.Errorf("Unmarshal(%+v): expect no error, got %s", opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal(%+v): got\n%+v\nwant\n%+v", opts, got, want)
+		}
+	}
+}
+
+func TestUnmarshalValues(t *testing.T) {
+	type values struct {
+		Small   uint8             `ade:"SMAL"`
+		Signed  int               `ade:"SIGN"`
+		Count   uint32            `ade:"CONT"`
+		Flag    bool              `ade:"FLAG"`
+		Flags   int               `ade:"FLGS"`
+		Float   float32           `ade:"FLOT"`
+		Text    string            `ade:"TEXT"`
+		Addr    *string           `ade:"ADDR"`
+		Missing *string           `ade:"MISS"`
+		UFrac   []uint64          `ade:"UFRA,UR32"`
+		SFrac   []int32           `ade:"SFRA,SR64"`
+		Dynamic map[string]string `ade:"DYNA"`
+		Repeats map[string][]int8 `ade:"REPT"`
+		Hex     uint8             `ade:"0x0000003a"`
+		Binary  string            `ade:"BINS"`
+	}
+	const input = "ROOT:CONT:\n\tSMAL:UI32:200\n\tSIGN:UI16:65535\n\tCONT:SI08:7\n\tFLAG:UI01:1\n\tFLGS:UI01:1\n" +
+		"\tFLOT:FP64:0.5\n\tTEXT:UF32:1.5\n\tADDR:IP32:10.0.0.1\n\tUFRA:UR32:1/3\n\tSFRA:SR64:-1/2\n" +
+		"\tDYNA:CONT:\n\t\tKEY1:CSTR:\"one\"\n\t\t0x00000002:UI32:2\n\tEND\n" +
+		"\tREPT:CONT:\n\t\tLIST:SI08:1\n\t\tLIST:SI08:-2\n\tEND\n\t0x0000003A:UI08:58\nEND\n"
+
+	var a = new(Atom)
+	if err := a.UnmarshalText([]byte(input)); err != nil {
+		t.Fatalf("Unmarshal: unable to create test atom: %s", err)
+	}
+	bin, _ := NewAtom("BINS", codec.CSTR, "")
+	bin.data = []byte("bin\xFF\x00") // not valid UTF-8
+	a.AddChild(bin)
+	var addr = "10.0.0.1"
+	var want = values{
+		Small:   200,
+		Signed:  65535,
+		Count:   7,
+		Flag:    true,
+		Flags:   1,
+		Float:   0.5,
+		Text:    "1.5000",
+		Addr:    &addr,
+		UFrac:   []uint64{1, 3},
+		SFrac:   []int32{-1, 2},
+		Dynamic: map[string]string{"KEY1": "one", "0x00000002": "2"},
+		Repeats: map[string][]int8{"LIST": {1, -2}},
+		Hex:     58,
+		Binary:  "bin\xFF",
+	}
+	var got values
+	if err := Unmarshal(a, &got); err != nil {
+		t.Fatalf("Unmarshal: expect no error, got %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal: got\n%+v\nwant\n%+v", got, want)
+	}
+
+	// fraction lists and CSTR bytes round trip through Marshal
+	type fractions struct {
+		ADEName struct{} `ade:"ROOT"`
+		UFrac   []uint64 `ade:"UFRA,UR32"`
+		SFrac   []int32  `ade:"SFRA,SR64"`
+		Binary  string   `ade:"BINS"`
+	}
+	b, err := Marshal(fractions{UFrac: want.UFrac, SFrac: want.SFrac, Binary: got.Binary})
+	if err != nil {
+		t.Fatalf("Marshal(fractions): expect no error, got %s", err)
+	}
+	if got, _ := b.MarshalText(); string(got) != "ROOT:CONT:\n\tUFRA:UR32:1/3\n\tSFRA:SR64:-1/2\n\tBINS:CSTR:\"bin\\xFF\"\nEND\n" {
+		t.Errorf("Marshal(fractions): got\n%s", got)
+	}
+	if data := b.Children()[2].data; string(data) != "bin\xFF\x00" {
+		t.Errorf("Marshal(fractions): got CSTR data %q, want %q", data, "bin\xFF\x00")
+	}
+}
+
+// Repeated fraction atoms need a slice of slices, and atoms of any type can
+// be held by an Atom.
+func TestUnmarshalRepeated(t *testing.T) {
+	type repeated struct {
+		ADEName struct{}   `ade:"ROOT"`
+		Fracs   [][]int64  `ade:"FRAC,SR32"`
+		Any     []*Atom    `ade:"ANY_"`
+		Box     *Atom      `ade:"BOX_"`
+		Missing *Atom      `ade:"MISS"`
+		UFracs  [][]uint32 `ade:"UFRA,UR64"`
+		Bytes   []uint8    `ade:"UNSB,UI08"`
+		Data    []byte     `ade:"DATA"`
+	}
+	const input = "ROOT:CONT:\n\tFRAC:SR32:-1/3\n\tFRAC:SR32:2/-5\n\tANY_:UI32:1\n\tANY_:UI64:2\n" +
+		"\tBOX_:CONT:\n\t\tBVER:UI32:6\n\tEND\n\tUFRA:UR64:1/2\n" +
+		"\tUNSB:UI08:1\n\tUNSB:UI08:2\n\tUNSB:UI08:3\n\tDATA:DATA:0x0102\nEND\n"
+
+	var a = new(Atom)
+	if err := a.UnmarshalText([]byte(input)); err != nil {
+		t.Fatalf("Unmarshal(repeated): unable to create test atom: %s", err)
+	}
+	for _, opts := range []UnmarshalOptions{{}, {Strict: true}} {
+		var got repeated
+		if err := Unmarshal(a, &got, opts); err != nil {
+			t.Errorf("Unmarshal(repeated, %+v): expect no error, got %s", opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Fracs, [][]int64{{-1, 3}, {2, -5}}) || !reflect.DeepEqual(got.UFracs, [][]uint32{{1, 2}}) {
+			t.Errorf("Unmarshal(repeated, %+v): got fractions %v %v", opts, got.Fracs, got.UFracs)
+		}
+		if !reflect.DeepEqual(got.Bytes, []uint8{1, 2, 3}) || !reflect.DeepEqual(got.Data, []byte{1, 2}) {
+			t.Errorf("Unmarshal(repeated, %+v): got bytes %v and data %v", opts, got.Bytes, got.Data)
+		}
+		if len(got.Any) != 2 || got.Any[0].String() != "ANY_:UI32:1" || got.Any[1].String() != "ANY_:UI64:2" {
+			t.Errorf("Unmarshal(repeated, %+v): got atoms %v", opts, got.Any)
+		}
+		if got.Box == nil || got.Box.NumChildren() != 1 || got.Missing != nil {
+			t.Errorf("Unmarshal(repeated, %+v): got atom %v, and %v for missing atom", opts, got.Box, got.Missing)
+		}
+
+		// atoms are copied, and convert back to the same container
+		got.Any[0].SetValue(uint32(9))
+		if s := a.Children()[2].String(); s != "ANY_:UI32:1" {
+			t.Errorf("Unmarshal(repeated, %+v): setting a copied atom changed the original to %s", opts, s)
+		}
+		got.Any[0].SetValue(uint32(1))
+		b, err := Marshal(got)
+		if err != nil {
+			t.Errorf("Marshal(repeated, %+v): expect no error, got %s", opts, err)
+			continue
+		}
+		if text, _ := b.MarshalText(); string(text) != input {
+			t.Errorf("Marshal(repeated, %+v): got\n%s\nwant\n%s", opts, text, input)
+		}
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	type scalars struct {
+		Small  uint8       `ade:"SMAL"`
+		Count  uint32      `ade:"CONT"`
+		Node   testNode    `ade:"NODE"`
+		Values map[int]int `ade:"VALS"`
+	}
+	tests := []struct {
+		input  string
+		v      interface{}
+		strict bool
+		cause  error
+		path   string
+	}{
+		{"GRID:CONT:\n\tJUNK:UI32:1\nEND\n", &testGrid{}, true, ErrUnknownAtom, "/GRID/JUNK[1]"},
+		{"GRID:CONT:\n\tBVER:UI16:1\nEND\n", &testGrid{}, true, ErrTypeMismatch, "/GRID/BVER[1]"},
+		{"GRID:CONT:\n\tBVER:UI32:1\n\tBVER:UI32:2\nEND\n", &testGrid{}, true, ErrRepeatedAtom, "/GRID/BVER[2]"},
+		{"GRID:CONT:\n\tKEY_:DATA:0x01\n\tKEY_:DATA:0x02\nEND\n", &testGrid{}, true, ErrRepeatedAtom, "/GRID/KEY_[2]"},
+		{"GRID:CONT:\n\tNODE:CONT:\n\tEND\n\tNODE:CONT:\n\t\tNDID:UI32:1\n\tEND\nEND\n", &testGrid{}, true, ErrTypeMismatch, "/GRID/NODE[2]/NDID[1]"},
+		{"GRID:CONT:\n\tNODE:CONT:\n\tEND\n\tNODE:CONT:\n\t\tADDR:CSTR:\"x\"\n\tEND\nEND\n", &testGrid{}, true, ErrTypeMismatch, "/GRID/NODE[2]/ADDR[1]"},
+		{"GRID:CONT:\n\tNODE:CONT:\n\t\tZONE:UI32:1\n\tEND\nEND\n", &testGrid{}, false, errors.New("zone must be an ENUM"), "/GRID/NODE[1]/ZONE[1]"},
+		{"ROOT:CONT:\nEND\n", &testGrid{}, false, ErrTypeMismatch, "/ROOT"},
+		{"GRID:UI32:1\n", &testGrid{}, false, ErrTypeMismatch, "/GRID"},
+		{"ROOT:CONT:\n\tSMAL:UI32:256\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/SMAL[1]"},
+		{"ROOT:CONT:\n\tSMAL:SI32:-1\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/SMAL[1]"},
+		{"ROOT:CONT:\n\tCONT:CSTR:\"x\"\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/CONT[1]"},
+		{"ROOT:CONT:\n\tCONT:CONT:\n\tEND\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/CONT[1]"},
+		{"ROOT:CONT:\n\tNODE:UI32:1\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/NODE[1]"},
+		{"ROOT:CONT:\n\tVALS:CONT:\n\tEND\nEND\n", &scalars{}, false, ErrTypeMismatch, "/ROOT/VALS[1]"},
+	}
+	for _, test := range tests {
+		var a = new(Atom)
+		if err := a.UnmarshalText([]byte(test.input)); err != nil {
+			t.Fatalf("Unmarshal(%q): unable to create test atom: %s", test.input, err)
+		}
+		err := Unmarshal(a, test.v, UnmarshalOptions{Strict: test.strict})
+		var got *UnmarshalError
+		if !errors.As(err, &got) {
+			t.Errorf("Unmarshal(%q): got err {%v}, want *UnmarshalError", test.input, err)
+			continue
+		}
+		if got.Err.Error() != test.cause.Error() || got.Path != test.path {
+			t.Errorf("Unmarshal(%q): got cause {%s} at %s, want {%s} at %s", test.input, got.Err, got.Path, test.cause, test.path)
+		}
+	}
+
+	// the value must be a pointer
+	var a = new(Atom)
+	for _, v := range []interface{}{nil, testGrid{}, (*testGrid)(nil)} {
+		if err := Unmarshal(a, v); err == nil {
+			t.Errorf("Unmarshal(%T): expect error for non-pointer, got none", v)
+		}
+	}
+}
@@ -3,123 +3,593 @@ package ade
 import (
 	"bytes"
 	"encoding/xml"
-)
-
-type xmlElement interface {
-	GetXMLName() xml.Name
-}
-type XMLAtom struct {
-	XMLName xml.Name `xml:"atom"`
-	Name    string   `xml:"name,attr"`
-	Type    string   `xml:"type,attr"`
-	Value   string   `xml:"value,attr"`
-}
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
-type XMLContainer struct {
-	XMLName  xml.Name `xml:"container"`
-	Name     string   `xml:"name,attr"`
-	Children []*Atom
-}
-
-func (a *Atom) AsXmlObject() (xe xmlElement, err error) {
-	if a.Type() == "CONT" {
-		xe = XMLContainer{Name: a.Name(), Children: a.Children()}
-		return
-	}
-	// Atom is not a container.  Take unescaped string representation of atom
-	// data, and add escape sequences for XML.
-	var buf bytes.Buffer
-	Log.Printf("Atom.AsXMLObject: Atom data %q", a.ValueString())
-	if err := xml.EscapeText(&buf, []byte(a.ValueString())); err != nil {
-		return nil, err
-	}
-	return XMLAtom{Name: a.Name(), Type: a.Type(), Value: a.ValueString()}, nil
-}
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
 
-// XMLName() funcs exist only to satisfy a common interface that can hold both Atoms
-// and Containers.
-func (elt XMLAtom) GetXMLName() xml.Name {
-	return elt.XMLName
-}
-func (elt XMLContainer) GetXMLName() xml.Name {
-	return elt.XMLName
-}
-func (elt XMLContainer) ToAtom() (a *Atom) {
-	return
-}
+// containerxml is the XML format for AtomContainers used by the C ADE
+// library. Containers are written as <container> elements, and other atoms as
+// self-closing <atom> elements with name, type and value attributes:
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<containerxml version="1" xmlns="http://www.bycast.com/schemas/XML-container-1.0.0">
+//		<container name="ROOT">
+//			<atom name="NOTE" type="CSTR" value="tab&#9;separated"/>
+//			<container name="NONE"/>
+//		</container>
+//	</containerxml>
+const (
+	containerXMLNamespace = "http://www.bycast.com/schemas/XML-container-1.0.0"
+	containerXMLStart     = `<containerxml version="1" xmlns="` + containerXMLNamespace + `">` + "\n"
+	containerXMLEnd       = "</containerxml>\n"
+)
 
-// Create a complete XML document in containerxml format with the given atom as
-// the root.
+// AtomToXMLDocumentText returns a complete XML document in containerxml format
+// with the given atom as the root.
+//
+// The output is identical to the containerxml written by the C ADE tools,
+// including their choice of character escapes.
 func AtomToXMLDocumentText(root *Atom) ([]byte, error) {
-	cxmlStart := `<containerxml version="1" xmlns="http://www.bycast.com/schemas/XML-container-1.0.0">` + "\n"
-	cxmlEnd := "\n</containerxml>\n"
 	var buf bytes.Buffer
 
 	// Write xml header, containerxml start tag
 	buf.WriteString(xml.Header)
-	buf.WriteString(cxmlStart)
+	buf.WriteString(containerXMLStart)
 
 	// Write Atom data
-	if moreBytes, e := xml.MarshalIndent(root, "\t", "\t"); e != nil {
-		return nil, e
-	} else {
-		buf.Write(moreBytes)
+	if err := atomToXMLBuffer(&buf, root, 1); err != nil {
+		return nil, err
 	}
 
 	// Write containerxml close tag
-	buf.WriteString(cxmlEnd)
+	buf.WriteString(containerXMLEnd)
 
 	return buf.Bytes(), nil
 }
 
-func XMLDocumentToAtom(data []byte) (a *Atom, e error) {
-	e = xml.Unmarshal(data, a)
-	if e != nil {
-		return nil, e
+// atomToXMLBuffer writes the atom and its children as containerxml elements,
+// indented with tabs to the given depth.
+func atomToXMLBuffer(buf *bytes.Buffer, a *Atom, depth int) error {
+	for i := 0; i < depth; i++ {
+		buf.WriteByte('\t')
+	}
+
+	if a.typ == codec.CONT {
+		buf.WriteString(`<container name="`)
+		escapeXMLAttr(buf, a.Name())
+		if len(a.Children()) == 0 {
+			buf.WriteString("\"/>\n")
+			return nil
+		}
+		buf.WriteString("\">\n")
+		for _, child := range a.Children() {
+			if err := atomToXMLBuffer(buf, child, depth+1); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < depth; i++ {
+			buf.WriteByte('\t')
+		}
+		buf.WriteString("</container>\n")
+		return nil
+	}
+
+	value, err := xmlValue(a)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(`<atom name="`)
+	escapeXMLAttr(buf, a.Name())
+	buf.WriteString(`" type="`)
+	escapeXMLAttr(buf, a.Type())
+	buf.WriteString(`" value="`)
+	escapeXMLAttr(buf, value)
+	buf.WriteString("\"/>\n")
+	return nil
+}
+
+// xmlValue returns the value attribute text for a non-container atom.
+func xmlValue(a *Atom) (string, error) {
+	value, err := a.Value.String()
+	if err != nil {
+		return "", fmt.Errorf("conversion of atom to xml failed for atom '%s:%s': %s", a.Name(), a.Type(), err)
+	}
+	if a.typ == codec.CSTR {
+		value = escapeInvalidBytes(a.data[:len(a.data)-1])
+	}
+	return value, nil
+}
+
+// escapeInvalidBytes returns CSTR bytes as text. Bytes which are not valid
+// UTF-8 are written as \xHH, the same as by the C ADE library. A backslash is
+// written as \\ where it would otherwise be read back as part of an escape,
+// which is when it comes before another backslash, before a byte written as
+// \xHH, or before text that looks like one. Other backslashes are written
+// unchanged, so the output only differs from the C ADE library for values
+// holding such text.
+func escapeInvalidBytes(buf []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(buf); i++ {
+		switch r, width := utf8.DecodeRune(buf[i:]); {
+		case r == utf8.RuneError:
+			fmt.Fprintf(&b, `\x%02X`, buf[i])
+		case r == '\\' && isEscapePrefix(buf[i+1:]):
+			b.WriteString(`\\`)
+		default:
+			b.Write(buf[i : i+width])
+			i += width - 1
+		}
+	}
+	return b.String()
+}
+
+// isEscapePrefix returns true if the CSTR bytes written as text would start
+// with a backslash, or with x and two hex digits for a byte from 80 to FF.
+func isEscapePrefix(buf []byte) bool {
+	if len(buf) == 0 {
+		return false
+	}
+	if r, _ := utf8.DecodeRune(buf); buf[0] == '\\' || r == utf8.RuneError {
+		return true
+	}
+	return len(buf) >= 3 && buf[0] == 'x' && isByteEscapeDigits(buf[1], buf[2])
+}
+
+// escapeXMLAttr writes a string as an XML attribute value, escaped the same
+// way as by the C ADE library. Markup characters, and the whitespace
+// characters that XML attribute value normalization would change, are
+// replaced by references. All other characters are written unchanged,
+// including control characters.
+func escapeXMLAttr(buf *bytes.Buffer, s string) {
+	var last = 0
+	for i := 0; i < len(s); i++ {
+		var esc string
+		switch s[i] {
+		case '"':
+			esc = "&quot;"
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\t':
+			esc = "&#9;"
+		case '\n':
+			esc = "&#10;"
+		case '\r':
+			esc = "&#13;"
+		default:
+			continue
+		}
+		buf.WriteString(s[last:i])
+		buf.WriteString(esc)
+		last = i + 1
 	}
-	return
+	buf.WriteString(s[last:])
 }
 
-// MarshalXML writes an Atom to containerxml, rooted in the startElement.
+// MarshalXML writes an Atom to an xml.Encoder as a containerxml element. The
+// name of the given start element is not used, because containerxml element
+// names are determined by the atom type.
+//
+// It implements the xml.Marshaler interface. The elements and attributes
+// written are the same as from AtomToXMLDocumentText, but encoding/xml does
+// not allow writing the same bytes:
+//
+//   - empty elements are written with an end tag, as <atom ...></atom>
+//   - tab, newline and carriage return are written as &#x9;, &#xA; and &#xD;
+//   - the control characters that XML does not allow are written as the
+//     Unicode noncharacter U+FDD0 plus the character, rather than unescaped
+//
+// UnmarshalXML and ReadAtomsFromXML read these back as the original
+// characters, so values still round trip.
 func (a *Atom) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
-	if x, err := a.AsXmlObject(); err != nil {
+	var nameAttr = xml.Attr{Name: xml.Name{Local: "name"}, Value: a.Name()}
+	if a.typ == codec.CONT {
+		start = xml.StartElement{Name: xml.Name{Local: "container"}, Attr: []xml.Attr{nameAttr}}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, child := range a.Children() {
+			if err := e.Encode(child); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	value, err := xmlValue(a)
+	if err != nil {
 		return err
-	} else {
-		err := e.Encode(x)
+	}
+	start = xml.StartElement{Name: xml.Name{Local: "atom"}, Attr: []xml.Attr{
+		nameAttr,
+		{Name: xml.Name{Local: "type"}, Value: a.Type()},
+		{Name: xml.Name{Local: "value"}, Value: strings.Map(mapControlChar, value)},
+	}}
+	if err := e.EncodeToken(start); err != nil {
 		return err
 	}
-	return nil
+	return e.EncodeToken(start.End())
+}
+
+// XMLDocumentToAtom reads a containerxml document holding a single top-level
+// atom, and returns that atom.
+//
+// An error is returned if the document is not valid containerxml, or does not
+// hold exactly one top-level atom. Errors describing invalid input are of
+// type *DecodeError, and give the line and column of the offending element.
+func XMLDocumentToAtom(data []byte) (*Atom, error) {
+	atoms, err := ReadAtomsFromXML(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	switch len(atoms) {
+	case 0:
+		return nil, fmt.Errorf("no atoms found in xml")
+	case 1:
+		return atoms[0], nil
+	default:
+		return nil, fmt.Errorf("multiple top-level atoms (%d) found in xml", len(atoms))
+	}
 }
 
-// MarshalXML writes an Atom to containerxml, rooted in the startElement.
+// ReadAtomsFromXML reads a containerxml document from r, and returns the
+// atoms within its containerxml element. Each atom value is set from its
+// value attribute, which must be valid for the atom type.
+//
+// An error is returned if the document is not valid containerxml. Errors
+// describing invalid input are of type *DecodeError, and give the line and
+// column of the offending element.
+func ReadAtomsFromXML(r io.Reader) (atoms []*Atom, err error) {
+	var x = xmlDecoder{d: xml.NewDecoder(&controlCharReader{r: r})}
+
+	// find the containerxml element
+	var root xml.StartElement
+	for root.Name.Local == "" {
+		tk, err := x.token()
+		if err == io.EOF {
+			return nil, x.errorf(ErrSyntax, nil, "no containerxml element found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			if tk.Name.Local != "containerxml" || (tk.Name.Space != "" && tk.Name.Space != containerXMLNamespace) {
+				return nil, x.errorf(ErrSyntax, nil, "expecting containerxml element, got %s", xmlElementName(tk.Name))
+			}
+			root = tk
+		case xml.CharData:
+			if err = x.checkSpace(tk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// read the atoms, up to the end of the containerxml element
+	for {
+		tk, err := x.token()
+		if err != nil {
+			return nil, err
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			a, err := x.decodeElement(tk)
+			if err != nil {
+				return nil, err
+			}
+			atoms = append(atoms, a)
+		case xml.CharData:
+			if err = x.checkSpace(tk); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			return atoms, x.checkEnd()
+		}
+	}
+}
+
+// UnmarshalXML reads a containerxml element from an xml.Decoder into the
+// Atom receiver. A container element is read along with all of its children.
+//
+// It implements the xml.Unmarshaler interface.
 func (a *Atom) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	var x XMLContainer
-	err := d.Decode(&x)
-	a = x.ToAtom()
+	var x = xmlDecoder{d: d}
+	x.line, x.col = d.InputPos()
+	atom, err := x.decodeElement(start)
 	if err != nil {
 		return err
 	}
+	a.Zero()
+	*a = *atom
 	return nil
 }
 
-// // Postprocess to match existing C ADE xml handling, so unit tests will match.
-// // This only exists to make the output match ADE, the XML is already
-// // well-formed before this.
-// func normalizeToADEStyle(buf []byte) []byte {
-//
-// 	// Replace atom closing tags with self-closing tags
-// 	s := strings.Replace(string(buf), "></atom>", "/>", -1)
-//
-// 	// Replace container closing tags on empty containers with self-closing tags
-// 	s = strings.Replace(s, "></container>", "/>", -1)
-//
-// 	// Use those instead of these
-// 	s = strings.Replace(s, `\\`, `\`, -1)
-// 	s = strings.Replace(s, `\x09`, `&#9;`, -1)
-// 	s = strings.Replace(s, `\n`, `&#10;`, -1)
-// 	s = strings.Replace(s, `\r`, `&#13;`, -1)
-// 	s = strings.Replace(s, `\&#34;`, "&quot;", -1)
-// 	s = strings.Replace(s, "&#39;", "'", -1)
-//
-// 	return []byte(s)
-// }
+// xmlDecoder converts containerxml elements into atoms, keeping track of
+// where they were found for use in error messages.
+type xmlDecoder struct {
+	d         *xml.Decoder
+	path      []string // names of the containers being read
+	line, col int      // location of the most recent token
+}
+
+// token returns the next XML token, and records where it starts. Syntax
+// errors are returned as a DecodeError.
+func (x *xmlDecoder) token() (xml.Token, error) {
+	x.line, x.col = x.d.InputPos()
+	tk, err := x.d.Token()
+	var e *xml.SyntaxError
+	if errors.As(err, &e) {
+		x.line, x.col = x.d.InputPos()
+		return nil, x.errorf(ErrSyntax, nil, "%s", e.Msg)
+	}
+	return tk, err
+}
+
+// decodeElement returns the atom described by the given container or atom
+// element, reading the rest of the element from the input.
+func (x *xmlDecoder) decodeElement(start xml.StartElement) (*Atom, error) {
+	var attrs = make(map[string]string, len(start.Attr))
+	for _, attr := range start.Attr {
+		attrs[attr.Name.Local] = strings.Map(unmapControlChar, attr.Value)
+	}
+	var a = new(Atom)
+	switch start.Name.Local {
+	case "container":
+		if err := x.setName(a, attrs); err != nil {
+			return nil, err
+		}
+		a.SetType(codec.CONT)
+		return a, x.decodeChildren(a)
+	case "atom":
+		if err := x.setName(a, attrs); err != nil {
+			return nil, err
+		}
+		typ, ok := attrs["type"]
+		if !ok {
+			return nil, x.errorf(ErrSyntax, a, "atom %s has no type attribute", a.Name())
+		}
+		if !codec.IsValidType(codec.ADEType(typ)) {
+			return nil, x.errorf(ErrUnknownType, a, "atom %s has unknown ADE type %q", a.Name(), typ)
+		}
+		if codec.ADEType(typ) == codec.CONT {
+			return nil, x.errorf(ErrSyntax, a, "atom %s has type CONT, which requires a container element", a.Name())
+		}
+		a.SetType(codec.ADEType(typ))
+		value, ok := attrs["value"]
+		if !ok {
+			return nil, x.errorf(ErrSyntax, a, "atom %s:%s has no value attribute", a.Name(), a.Type())
+		}
+		if a.typ == codec.CSTR {
+			value = unescapeInvalidBytes(value)
+		}
+		if err := a.Value.SetString(value); err != nil {
+			return nil, x.errorf(ErrSyntax, a, "invalid value for atom %s:%s: %s", a.Name(), a.Type(), err)
+		}
+		return a, x.decodeEmpty(a)
+	default:
+		return nil, x.errorf(ErrSyntax, nil, "expecting container or atom element, got %s", xmlElementName(start.Name))
+	}
+}
+
+// unescapeInvalidBytes reverses escapeInvalidBytes. Each \xHH escape, with
+// uppercase hex digits from 80 to FF, is read back as that byte, and \\ is
+// read back as one backslash. Everything else is read unchanged.
+func unescapeInvalidBytes(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] != '\\' || i+1 == len(s):
+		case s[i+1] == '\\':
+			i++
+		case i+3 < len(s) && s[i+1] == 'x' && isByteEscapeDigits(s[i+2], s[i+3]):
+			n, _ := strconv.ParseUint(s[i+2:i+4], 16, 8)
+			b.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isByteEscapeDigits returns true if the two characters are the uppercase hex
+// digits of a byte from 80 to FF, which is not valid UTF-8 on its own.
+func isByteEscapeDigits(hi, lo byte) bool {
+	return hi >= '8' && isUpperHexDigit(hi) && isUpperHexDigit(lo)
+}
+
+// isUpperHexDigit returns true if c is a hex digit, with letters in uppercase.
+func isUpperHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'F'
+}
+
+// setName sets the atom name from the name attribute of its element.
+func (x *xmlDecoder) setName(a *Atom, attrs map[string]string) error {
+	name, ok := attrs["name"]
+	if !ok {
+		return x.errorf(ErrSyntax, nil, "element has no name attribute")
+	}
+	if err := codec.StringToFC32Bytes(&a.name, name); err != nil {
+		return x.errorf(ErrSyntax, nil, "invalid atom name %q", name)
+	}
+	return nil
+}
+
+// decodeChildren reads the children of a container element, up to its end.
+func (x *xmlDecoder) decodeChildren(a *Atom) error {
+	x.path = append(x.path, a.Name())
+	defer func() { x.path = x.path[:len(x.path)-1] }()
+	for {
+		tk, err := x.token()
+		if err != nil {
+			return err
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			child, err := x.decodeElement(tk)
+			if err != nil {
+				return err
+			}
+			a.AddChild(child)
+		case xml.CharData:
+			if err = x.checkSpace(tk); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// decodeEmpty reads up to the end of an atom element, which must be empty.
+func (x *xmlDecoder) decodeEmpty(a *Atom) error {
+	for {
+		tk, err := x.token()
+		if err != nil {
+			return err
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			return x.errorf(ErrSyntax, a, "atom %s:%s may not contain elements, got %s", a.Name(), a.Type(), xmlElementName(tk.Name))
+		case xml.CharData:
+			if err = x.checkSpace(tk); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// checkSpace returns an error if character data outside of attributes is
+// anything other than whitespace.
+func (x *xmlDecoder) checkSpace(data xml.CharData) error {
+	if len(bytes.TrimSpace(data)) > 0 {
+		return x.errorf(ErrSyntax, nil, "unexpected text %q", bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// checkEnd returns an error if anything other than comments, processing
+// instructions and whitespace follows the containerxml element.
+func (x *xmlDecoder) checkEnd() error {
+	for {
+		tk, err := x.token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			return x.errorf(ErrSyntax, nil, "unexpected %s element after end of containerxml", xmlElementName(tk.Name))
+		case xml.CharData:
+			if err = x.checkSpace(tk); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// errorf returns a DecodeError at the location of the most recent token. If
+// an atom is given, its name and type are included.
+func (x *xmlDecoder) errorf(cause error, a *Atom, format string, args ...interface{}) error {
+	e := &DecodeError{
+		Err:    cause,
+		Msg:    fmt.Sprintf(format, args...),
+		Path:   containerPath(x.path),
+		Line:   x.line,
+		Column: x.col,
+	}
+	if a != nil {
+		e.Name = a.Name()
+		e.Type = a.typ
+	}
+	return e
+}
+
+// controlCharReader replaces the control characters that are not allowed in
+// XML with Unicode noncharacters, which are. The C ADE library writes control
+// characters in attribute values without escaping them, and encoding/xml
+// rejects them. Each control character is replaced by mapControlChar, and
+// changed back by unmapControlChar once the attribute value is decoded.
+type controlCharReader struct {
+	r       io.Reader
+	pending []byte // replaced input not yet returned
+	err     error  // error to return once pending is empty
+}
+
+// Read implements the io.Reader interface.
+func (c *controlCharReader) Read(p []byte) (n int, err error) {
+	if len(c.pending) > 0 {
+		n = copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err = c.r.Read(p)
+	if bytes.IndexFunc(p[:n], isControlChar) < 0 {
+		return n, err
+	}
+	var out = make([]byte, 0, 2*n)
+	for _, b := range p[:n] {
+		if isControlChar(rune(b)) {
+			out = utf8.AppendRune(out, mapControlChar(rune(b)))
+		} else {
+			out = append(out, b)
+		}
+	}
+	n = copy(p, out)
+	if c.pending = out[n:]; len(c.pending) > 0 {
+		c.err = err
+		return n, nil
+	}
+	return n, err
+}
+
+// isControlChar returns true for characters below U+0020 that XML does not
+// allow, which are all except tab, newline and carriage return.
+func isControlChar(r rune) bool {
+	return r < 0x20 && r != '\t' && r != '\n' && r != '\r'
+}
+
+// mapControlChar replaces a control character c that XML does not allow with
+// U+FDD0+c, which is a Unicode noncharacter that XML does allow.
+func mapControlChar(r rune) rune {
+	if isControlChar(r) {
+		return 0xFDD0 + r
+	}
+	return r
+}
+
+// unmapControlChar reverses the replacement done by mapControlChar.
+func unmapControlChar(r rune) rune {
+	if r >= 0xFDD0 && isControlChar(r-0xFDD0) {
+		return r - 0xFDD0
+	}
+	return r
+}
+
+// xmlElementName returns an element name for use in error messages.
+func xmlElementName(name xml.Name) string {
+	if name.Space != "" {
+		return fmt.Sprintf("<%s> in namespace %s", name.Local, name.Space)
+	}
+	return "<" + name.Local + ">"
+}
@@ -2,59 +2,61 @@ package ade
 
 import (
 	"bytes"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os/exec"
-	"path/filepath"
+	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
 )
 
 var xmlGINF = strings.TrimSpace(`
 <?xml version="1.0" encoding="UTF-8"?>
 <containerxml version="1" xmlns="http://www.bycast.com/schemas/XML-container-1.0.0">
 	<container name="GINF">
-		<atom name="BVER" type="UI32" value="4"></atom>
-		<atom name="BTIM" type="UI64" value="1484723582627327"></atom>
+		<atom name="BVER" type="UI32" value="4"/>
+		<atom name="BTIM" type="UI64" value="1484723582627327"/>
 		<container name="GIDV">
-			<atom name="AVER" type="UI32" value="2"></atom>
-			<atom name="ATIM" type="UI64" value="1"></atom>
-			<atom name="AVTP" type="FC32" value="UI32"></atom>
-			<atom name="APER" type="FC32" value="READ"></atom>
+			<atom name="AVER" type="UI32" value="2"/>
+			<atom name="ATIM" type="UI64" value="1"/>
+			<atom name="AVTP" type="FC32" value="UI32"/>
+			<atom name="APER" type="FC32" value="READ"/>
 			<container name="AVAL">
-				<atom name="0x00000000" type="UI32" value="2"></atom>
-				<atom name="0x00000001" type="UI32" value="908767"></atom>
+				<atom name="0x00000000" type="UI32" value="2"/>
+				<atom name="0x00000001" type="UI32" value="908767"/>
 			</container>
 		</container>
 		<container name="GPVD">
-			<atom name="AVER" type="UI32" value="2"></atom>
-			<atom name="ATIM" type="UI64" value="1"></atom>
-			<atom name="AVTP" type="FC32" value="UI64"></atom>
-			<atom name="APER" type="FC32" value="READ"></atom>
+			<atom name="AVER" type="UI32" value="2"/>
+			<atom name="ATIM" type="UI64" value="1"/>
+			<atom name="AVTP" type="FC32" value="UI64"/>
+			<atom name="APER" type="FC32" value="READ"/>
 			<container name="AVAL">
-				<atom name="0x00000000" type="UI32" value="2"></atom>
-				<atom name="0x00000001" type="UI64" value="1484722540084888"></atom>
+				<atom name="0x00000000" type="UI32" value="2"/>
+				<atom name="0x00000001" type="UI64" value="1484722540084888"/>
 			</container>
 		</container>
 		<container name="GVND">
-			<atom name="AVER" type="UI32" value="2"></atom>
-			<atom name="ATIM" type="UI64" value="1"></atom>
-			<atom name="AVTP" type="FC32" value="CSTR"></atom>
-			<atom name="APER" type="FC32" value="READ"></atom>
+			<atom name="AVER" type="UI32" value="2"/>
+			<atom name="ATIM" type="UI64" value="1"/>
+			<atom name="AVTP" type="FC32" value="CSTR"/>
+			<atom name="APER" type="FC32" value="READ"/>
 			<container name="AVAL">
-				<atom name="0x00000000" type="UI32" value="2"></atom>
-				<atom name="0x00000001" type="CSTR" value="{OID=&#39;2.16.124.113590.3.1.3.3.1&#39;}"></atom>
+				<atom name="0x00000000" type="UI32" value="2"/>
+				<atom name="0x00000001" type="CSTR" value="{OID='2.16.124.113590.3.1.3.3.1'}"/>
 			</container>
 		</container>
 		<container name="GSIV">
-			<atom name="AVER" type="UI32" value="2"></atom>
-			<atom name="ATIM" type="UI64" value="1"></atom>
-			<atom name="AVTP" type="FC32" value="CSTR"></atom>
-			<atom name="APER" type="FC32" value="READ"></atom>
+			<atom name="AVER" type="UI32" value="2"/>
+			<atom name="ATIM" type="UI64" value="1"/>
+			<atom name="AVTP" type="FC32" value="CSTR"/>
+			<atom name="APER" type="FC32" value="READ"/>
 			<container name="AVAL">
-				<atom name="0x00000000" type="UI32" value="2"></atom>
-				<atom name="0x00000001" type="CSTR" value="10.4.0"></atom>
+				<atom name="0x00000000" type="UI32" value="2"/>
+				<atom name="0x00000001" type="CSTR" value="10.4.0"/>
 			</container>
 		</container>
 	</container>
@@ -81,72 +83,286 @@ func TestMarshalXML(t *testing.T) {
 
 func TestAtomToXMLDocumentText(t *testing.T) {
 	defer checkFailedTest(t)
-	var got []byte
-	var err error
 	var fn = "AtomToXMLDocumentText"
-	//	if testWriteDebugFiles {
-	//		os.RemoveAll(failedOutputDir)
-	//		os.Mkdir(failedOutputDir, 0766)
-	//	}
 
-	// Assumes testfiles and TestAtoms have matching order
 	for _, test := range Tests {
-		// Test that AtomToXMLDocumentText succeeds
-		if got, err = AtomToXMLDocumentText(test.atom); err != nil {
+		// An empty XML file means the C ADE tools could not convert the atom.
+		if len(test.xmlBytes) == 0 {
+			continue
+		}
+
+		got, err := AtomToXMLDocumentText(test.atom)
+		if err != nil {
 			t.Errorf("%s(%s): expect no error, got %s", fn, test.Name(), err.Error())
+			continue
+		}
+		if !bytes.Equal(got, test.xmlBytes) {
+			t.Errorf("%s(%s): generated XML does not match expected", fn, test.Name())
 			if testWriteDebugFiles {
 				writeDebugFiles(got, test.xmlBytes, test.Name(), failedOutputDir, "xml")
 			}
 		}
+	}
+}
 
-		if result := compareXML(got, test.xmlBytes); result != nil {
-			t.Errorf("%s: generated XML does not match expected for test %s", fn, test.Name())
-			break
+func TestEscapeXMLAttr(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{`"quoted" 'single'`, "&quot;quoted&quot; 'single'"},
+		{"a&b<c>d", "a&amp;b&lt;c&gt;d"},
+		{"tab\tnewline\ncr\r", "tab&#9;newline&#10;cr&#13;"},
+		{`back\slash`, `back\slash`},
+		{"ctrl\x01\x0B", "ctrl\x01\x0B"},
+		{"unicode 英国 ⠙", "unicode 英国 ⠙"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		escapeXMLAttr(&buf, test.input)
+		if got := buf.String(); got != test.want {
+			t.Errorf("escapeXMLAttr(%q): got %q, want %q", test.input, got, test.want)
 		}
 	}
 }
 
-func compareXML(xdoc1_bytes, xdoc2_bytes []byte) error {
-	var filepaths = []string{
-		filepath.Join(failedOutputDir, "xdoc1-raw.xml"),
-		filepath.Join(failedOutputDir, "xdoc2-raw.xml"),
-		filepath.Join(failedOutputDir, "xdoc1-canonical.xml"),
-		filepath.Join(failedOutputDir, "xdoc2-canonical.xml"),
+// CSTR values are written with escapes for bytes which are not valid UTF-8,
+// and for backslashes which would otherwise be read back as part of one.
+func TestXMLCSTREscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`plain`, `plain`},
+		{`back\slash`, `back\slash`},
+		{`trailing\`, `trailing\`},
+		{`\xFF`, `\\xFF`},
+		{`\\xFF`, `\\\\xFF`},
+		{`\x41`, `\x41`},
+		{`\xff`, `\xff`},
+		{`a\\b`, `a\\\b`},
+		{"bin\xFF", `bin\xFF`},
+		{"\\\x80", `\\\x80`},
+		{"\xEF\xBF\xBD", `\xEF\xBF\xBD`},
+	}
+	for _, test := range tests {
+		a, err := NewAtom("NOTE", codec.CSTR, "")
+		if err != nil {
+			t.Fatalf("XML CSTR escapes: unable to create test atom: %s", err)
+		}
+		a.data = append([]byte(test.input), 0)
+		text, err := AtomToXMLDocumentText(a)
+		if err != nil {
+			t.Errorf("XML CSTR escapes(%q): expect no error, got %s", test.input, err)
+			continue
+		}
+		if want := `value="` + test.want + `"`; !bytes.Contains(text, []byte(want)) {
+			t.Errorf("XML CSTR escapes(%q): got\n%s\nwant %s", test.input, text, want)
+		}
+		got, err := XMLDocumentToAtom(text)
+		if err != nil {
+			t.Errorf("XML CSTR escapes(%q): expect no error reading back, got %s", test.input, err)
+			continue
+		}
+		if !bytes.Equal(got.data, a.data) {
+			t.Errorf("XML CSTR escapes(%q): read back as %q", test.input, got.data)
+		}
 	}
-	var err error
-	//	os.Mkdir(failedOutputDir, 0766) // dir might already exist, ignore error
+}
 
-	// Write xml to file
-	if err = ioutil.WriteFile(filepaths[0], xdoc1_bytes, 0666); err != nil {
-		log.Fatalf("Unable to write XML file for comparison")
+func TestMarshalXMLEncoder(t *testing.T) {
+	var a = new(Atom)
+	if err := a.UnmarshalText([]byte("ROOT:CONT:\n\tNOTE:CSTR:\"a\\x09b\"\n\tNONE:CONT:\n\tEND\nEND\n")); err != nil {
+		t.Fatalf("MarshalXML: unable to create test atom: %s", err)
+	}
+	got, err := xml.Marshal(a)
+	if err != nil {
+		t.Fatalf("MarshalXML: expect no error, got %s", err)
 	}
-	if err = ioutil.WriteFile(filepaths[1], xdoc2_bytes, 0666); err != nil {
-		log.Fatalf("Unable to write XML file for comparison")
+	want := `<container name="ROOT"><atom name="NOTE" type="CSTR" value="a&#x9;b"></atom><container name="NONE"></container></container>`
+	if string(got) != want {
+		t.Errorf("MarshalXML: got\n%s\nwant\n%s", got, want)
 	}
+}
 
-	// Canonicalize xml
-	_, err = runCommand(fmt.Sprintf("xmllint --c14n %s > %s", filepaths[0], filepaths[2]))
-	if err != nil {
-		fmt.Println("Got error ", err)
-		panic("Unable to canonicalize XML")
+// MarshalXML writes the same elements and attributes as AtomToXMLDocumentText,
+// with the differences in escaping and formatting that its doc describes, and
+// they read back as the same atoms.
+func TestMarshalXMLMatchesDocumentText(t *testing.T) {
+	var fn = "MarshalXML"
+	for _, test := range Tests {
+		got, err := xml.Marshal(test.atom)
+		if err != nil {
+			t.Errorf("%s(%s): expect no error, got %s", fn, test.Name(), err)
+			continue
+		}
+		want, err := AtomToXMLDocumentText(test.atom)
+		if err != nil {
+			t.Errorf("%s(%s): unable to create XML document: %s", fn, test.Name(), err)
+			continue
+		}
+		gotTokens := xmlAtomTokens(t, bytes.NewReader(got))
+		wantTokens := xmlAtomTokens(t, &controlCharReader{r: bytes.NewReader(want)})
+		if gotTokens != wantTokens {
+			t.Errorf("%s(%s): elements differ from AtomToXMLDocumentText, got\n%s\nwant\n%s", fn, test.Name(), gotTokens, wantTokens)
+		}
+
+		var a Atom
+		if err := xml.Unmarshal(got, &a); err != nil {
+			t.Errorf("%s(%s): unable to read back XML: %s", fn, test.Name(), err)
+			continue
+		}
+		gotBin, _ := a.MarshalBinary()
+		wantBin, _ := test.atom.MarshalBinary()
+		if !bytes.Equal(gotBin, wantBin) {
+			t.Errorf("%s(%s): XML does not read back as the same atom", fn, test.Name())
+		}
 	}
-	_, err = runCommand(fmt.Sprintf("xmllint --c14n %s > %s", filepaths[1], filepaths[3]))
+}
+
+// xmlAtomTokens returns the container and atom elements of XML input as text,
+// one per line, ignoring the containerxml element and whitespace between
+// elements.
+func xmlAtomTokens(t *testing.T, r io.Reader) string {
+	var b strings.Builder
+	var d = xml.NewDecoder(r)
+	for {
+		tk, err := d.Token()
+		if err == io.EOF {
+			return b.String()
+		}
+		if err != nil {
+			t.Fatalf("xmlAtomTokens: unable to read XML: %s", err)
+		}
+		switch tk := tk.(type) {
+		case xml.StartElement:
+			if tk.Name.Local != "containerxml" {
+				fmt.Fprintf(&b, "<%s %v>\n", tk.Name.Local, tk.Attr)
+			}
+		case xml.EndElement:
+			if tk.Name.Local != "containerxml" {
+				fmt.Fprintf(&b, "</%s>\n", tk.Name.Local)
+			}
+		case xml.CharData:
+			if len(bytes.TrimSpace(tk)) > 0 {
+				t.Fatalf("xmlAtomTokens: unexpected text %q", tk)
+			}
+		}
+	}
+}
+
+func TestXMLDocumentToAtom(t *testing.T) {
+	var fn = "XMLDocumentToAtom"
+	for _, test := range Tests {
+		if len(test.xmlBytes) == 0 {
+			continue
+		}
+		got, err := XMLDocumentToAtom(test.xmlBytes)
+		if err != nil {
+			t.Errorf("%s(%s): expect no error, got %s", fn, test.Name(), err)
+			continue
+		}
+
+		// converting back to XML must reproduce the input
+		if xmlText, err := AtomToXMLDocumentText(got); err != nil || !bytes.Equal(xmlText, test.xmlBytes) {
+			t.Errorf("%s(%s): result does not convert back to the same XML, err %v", fn, test.Name(), err)
+		}
+		gotBin, _ := got.MarshalBinary()
+		wantBin, _ := test.atom.MarshalBinary()
+		if !bytes.Equal(gotBin, wantBin) {
+			t.Errorf("%s(%s): result differs from binary test data", fn, test.Name())
+		}
+	}
+
+	got, err := XMLDocumentToAtom([]byte(xmlGINF))
 	if err != nil {
-		fmt.Println("Got error ", err)
-		panic("Unable to canonicalize XML")
+		t.Fatalf("%s(GINF): expect no error, got %s", fn, err)
+	}
+	if atomsText([]*Atom{got}) != atomsText([]*Atom{TestAtomGINF}) {
+		t.Errorf("%s(GINF): result differs from test atom", fn)
+	}
+}
+
+func TestXMLDocumentToAtomInvalid(t *testing.T) {
+	const (
+		head = "<?xml version=\"1.0\"?>\n<containerxml version=\"1\">\n"
+		tail = "</containerxml>\n"
+	)
+	tests := []struct {
+		name   string
+		input  string
+		want   error
+		path   string
+		line   int
+		column int
+	}{
+		{"empty", "", ErrSyntax, "/", 1, 1},
+		{"wrong root", "<containers/>", ErrSyntax, "/", 1, 1},
+		{"no atoms", head + tail, nil, "", 0, 0},
+		{"unknown type", head + "<container name=\"ROOT\">\n\t<atom name=\"NUMB\" type=\"UI33\" value=\"1\"/>\n</container>\n" + tail,
+			ErrUnknownType, "/ROOT", 4, 2},
+		{"bad value", head + "<container name=\"ROOT\">\n\t<container name=\"INTS\">\n\t\t<atom name=\"NUMB\" type=\"UI08\" value=\"256\"/>\n" +
+			"\t</container>\n</container>\n" + tail, ErrSyntax, "/ROOT/INTS", 5, 3},
+		{"bad name", head + "<atom name=\"TOOLONG\" type=\"NULL\" value=\"\"/>\n" + tail, ErrSyntax, "/", 3, 1},
+		{"no type", head + "<atom name=\"NUMB\" value=\"1\"/>\n" + tail, ErrSyntax, "/", 3, 1},
+		{"no value", head + "<atom name=\"NUMB\" type=\"UI32\"/>\n" + tail, ErrSyntax, "/", 3, 1},
+		{"no name", head + "<container/>\n" + tail, ErrSyntax, "/", 3, 1},
+		{"CONT atom", head + "<atom name=\"ROOT\" type=\"CONT\" value=\"\"/>\n" + tail, ErrSyntax, "/", 3, 1},
+		{"unknown element", head + "<container name=\"ROOT\"><list/></container>\n" + tail, ErrSyntax, "/ROOT", 3, 24},
+		{"atom with children", head + "<atom name=\"NUMB\" type=\"UI32\" value=\"1\"><atom/></atom>\n" + tail, ErrSyntax, "/", 3, 41},
+		{"text", head + "<container name=\"ROOT\">text</container>\n" + tail, ErrSyntax, "/ROOT", 3, 24},
+		{"unclosed", head + "<container name=\"ROOT\">\n", ErrSyntax, "/ROOT", 4, 1},
+		{"after end", head + tail + "<atom/>\n", ErrSyntax, "/", 4, 1},
+	}
+	for _, test := range tests {
+		_, err := ReadAtomsFromXML(strings.NewReader(test.input))
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("ReadAtomsFromXML(%s): expect no error, got %s", test.name, err)
+			}
+			continue
+		}
+		var e *DecodeError
+		if !errors.Is(err, test.want) || !errors.As(err, &e) || e.Path != test.path || e.Line != test.line || e.Column != test.column {
+			t.Errorf("ReadAtomsFromXML(%s): got err {%v}, want cause {%s} at path %s line %d column %d",
+				test.name, err, test.want, test.path, test.line, test.column)
+		}
 	}
 
-	_, err = runCommand(fmt.Sprintf("diff %s %s", filepaths[2], filepaths[3]))
-	return err
+	if _, err := XMLDocumentToAtom([]byte(head + tail)); err == nil {
+		t.Errorf("XMLDocumentToAtom(no atoms): expect error, got none")
+	}
 }
 
-func runCommand(cmdString string) (output string, err error) {
-	// using bash -c allows usage of redirect operators in cmd string, and lets
-	// us delegate command string splitting to bash instead of doing it here.
-	cmd := exec.Command("bash", "-c", cmdString)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err = cmd.Run()
-	return out.String(), err
+func TestUnmarshalXMLDecoder(t *testing.T) {
+	got := new(Atom)
+	if err := xml.Unmarshal([]byte(`<container name="ROOT"><atom name="NOTE" type="CSTR" value="a&#x9;b"></atom><container name="NONE"></container></container>`), got); err != nil {
+		t.Fatalf("UnmarshalXML: expect no error, got %s", err)
+	}
+	want := "ROOT:CONT:\n\tNOTE:CSTR:\"a\\x09b\"\n\tNONE:CONT:\n\tEND\nEND\n"
+	if text, _ := got.MarshalText(); string(text) != want {
+		t.Errorf("UnmarshalXML: got\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestReadAtomsFromXMLControlChars(t *testing.T) {
+	const input = "<containerxml>\n\t<atom name=\"NOTE\" type=\"CSTR\" value=\"vt\x0b,ff\x0c,ctrl\x01\x1f,tab&#9;\"/>\n</containerxml>\n"
+	const want = "vt\x0b,ff\x0c,ctrl\x01\x1f,tab\t"
+	readers := map[string]io.Reader{
+		"whole":    strings.NewReader(input),
+		"one byte": iotest.OneByteReader(strings.NewReader(input)),
+		"half":     iotest.HalfReader(strings.NewReader(input)),
+	}
+	for name, r := range readers {
+		atoms, err := ReadAtomsFromXML(r)
+		if err != nil {
+			t.Errorf("ReadAtomsFromXML(%s): expect no error, got %s", name, err)
+			continue
+		}
+		if got, _ := atoms[0].Value.String(); got != want {
+			t.Errorf("ReadAtomsFromXML(%s): got value %q, want %q", name, got, want)
+		}
+	}
 }
@@ -0,0 +1,150 @@
+package ade
+
+// Zero-copy reading of binary AtomContainers from a byte slice.
+//
+// ReadAtomsFromBinary copies the data of every atom into a new slice, and
+// builds the complete atom tree before returning. ReadAtomsFromBytes instead
+// returns atoms whose names and data are slices of the input buffer, and
+// decodes the children of each container only when they are first accessed.
+// When only part of a large container is used, most atoms are never created.
+//
+// The input is fully validated before any atoms are returned, so that errors
+// are never found later when children are decoded.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gongfarmer/ntap/encoding/ade/codec"
+)
+
+// ReadAtomsFromBytes returns the binary AtomContainers encoded in a byte
+// slice, without copying their data. It returns an error if the byte slice
+// does not contain valid binary container data.
+//
+// If DecodeOptions are given, their limits are enforced on the input.
+// Otherwise DefaultDecodeOptions are used.
+//
+// The returned atoms refer to the memory of buf, which must not be modified
+// while they are in use. Setting the value of an atom may write into buf.
+//
+// Container children are decoded on first access, which modifies the
+// container. To share atoms between goroutines, first call Descendants on
+// each one to decode all children.
+func ReadAtomsFromBytes(buf []byte, opts ...DecodeOptions) (atoms []*Atom, err error) {
+	if !isValidBinary(buf, getDecodeOptions(opts)) {
+		// Decode again to find the problem. This is slow, but only happens
+		// for invalid input.
+		if _, err = ReadAtomsFromBinary(bytes.NewReader(buf), opts...); err == nil {
+			err = fmt.Errorf("invalid binary AtomContainer data")
+		}
+		return nil, err
+	}
+	return atomsFromBytes(buf), nil
+}
+
+// isValidBinary returns true if buf holds valid binary container data within
+// the given limits. It checks the same conditions as the Decoder does, but
+// reads only atom headers and does not allocate.
+func isValidBinary(buf []byte, o DecodeOptions) bool {
+	var (
+		ends  = make([]int64, 0, 16) // end positions of open containers
+		atoms = 0
+		size  = int64(len(buf))
+	)
+	if o.MaxTotalBytes > 0 && size > o.MaxTotalBytes {
+		return false
+	}
+	for pos := int64(0); pos < size; {
+		if size-pos < headerSize {
+			return false
+		}
+		h := parseAtomHeader(buf[pos:])
+		typ := lookupADEType(h.Type[:])
+		end := pos + int64(h.Size)
+		switch {
+		case typ == "",
+			h.Size < headerSize,
+			isShortData(typ, h.Size-headerSize),
+			end > size,
+			len(ends) > 0 && end > ends[len(ends)-1]:
+			return false
+		}
+
+		atoms++
+		switch {
+		case o.MaxAtoms > 0 && atoms > o.MaxAtoms,
+			o.MaxDepth > 0 && h.isContainer() && len(ends) >= o.MaxDepth,
+			o.MaxDataSize > 0 && !h.isContainer() && h.Size-headerSize > o.MaxDataSize:
+			return false
+		}
+
+		if h.isContainer() {
+			ends = append(ends, end)
+			pos += headerSize
+		} else {
+			pos = end
+		}
+
+		// close completed containers
+		for len(ends) > 0 && ends[len(ends)-1] == pos {
+			ends = ends[:len(ends)-1]
+		}
+	}
+	return true
+}
+
+// atomsFromBytes returns the atoms encoded in buf, which must be valid binary
+// container data. Children of the atoms are left encoded, to be decoded on
+// first access.
+func atomsFromBytes(buf []byte) (atoms []*Atom) {
+	for pos := 0; pos < len(buf); {
+		end := pos + int(binary.BigEndian.Uint32(buf[pos:pos+4]))
+		a := &Atom{
+			name: buf[pos+4 : pos+8 : pos+8],
+			typ:  lookupADEType(buf[pos+8 : pos+12]),
+		}
+
+		// Full slice expressions limit capacity, so that appending to atom
+		// data can never overwrite the atoms that follow.
+		if a.typ == codec.CONT {
+			a.lazy = buf[pos+headerSize : end : end]
+		} else {
+			a.data = truncateDataToSize(a.typ, buf[pos+headerSize:end:end])
+		}
+		a.Value = codec.NewCodec(&a.data, a.typ)
+		atoms = append(atoms, a)
+		pos = end
+	}
+	return atoms
+}
+
+// expand decodes the children of a container read by ReadAtomsFromBytes.
+func (a *Atom) expand() {
+	a.children = atomsFromBytes(a.lazy)
+	a.lazy = nil
+}
+
+// ADE types by name, so that types can be found from bytes without allocating
+// a new string.
+var adeTypes = map[string]codec.ADEType{}
+
+func init() {
+	for _, typ := range []codec.ADEType{
+		codec.UI01, codec.UI08, codec.UI16, codec.UI32, codec.UI64,
+		codec.SI08, codec.SI16, codec.SI32, codec.SI64,
+		codec.FP32, codec.FP64, codec.UF32, codec.UF64, codec.SF32, codec.SF64,
+		codec.UR32, codec.UR64, codec.SR32, codec.SR64,
+		codec.FC32, codec.IP32, codec.IPAD, codec.CSTR, codec.USTR,
+		codec.DATA, codec.ENUM, codec.UUID, codec.NULL, codec.CNCT, codec.Cnct, codec.CONT,
+	} {
+		adeTypes[string(typ)] = typ
+	}
+}
+
+// lookupADEType returns the ADE type with the given name, or "" if the name is
+// not an ADE type.
+func lookupADEType(name []byte) codec.ADEType {
+	return adeTypes[string(name)]
+}
@@ -0,0 +1,135 @@
+package ade
+
+//
+// Verify that ReadAtomsFromBytes produces the same atoms as
+// ReadAtomsFromBinary, without copying data or decoding unused children.
+//
+// Benchmarks compare the two ways of reading binary input.
+//
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadAtomsFromBytes(t *testing.T) {
+	for _, test := range Tests {
+		want, err := ReadAtomsFromBinary(bytes.NewReader(test.binBytes))
+		if err != nil {
+			t.Fatalf("ReadAtomsFromBytes(%s): unable to create test input: %s", test.Name(), err)
+		}
+		got, err := ReadAtomsFromBytes(test.binBytes)
+		if err != nil {
+			t.Errorf("ReadAtomsFromBytes(%s): expect no error, got %s", test.Name(), err)
+			continue
+		}
+		if atomsText(got) != atomsText(want) {
+			t.Errorf("ReadAtomsFromBytes(%s): result differs from ReadAtomsFromBinary", test.Name())
+		}
+	}
+}
+
+func TestReadAtomsFromBytesLazy(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestReadAtomsFromBytesLazy: unable to create test input: %s", err)
+	}
+	atoms, err := ReadAtomsFromBytes(buf)
+	if err != nil {
+		t.Fatalf("TestReadAtomsFromBytesLazy: expect no error, got %s", err)
+	}
+	root := atoms[0]
+	if root.children != nil || len(root.lazy) != 180 {
+		t.Errorf("TestReadAtomsFromBytesLazy: children should not be decoded before access")
+	}
+
+	// decoding one container leaves its siblings encoded
+	c := root.Children()[1]
+	if root.lazy != nil || len(root.children) != 3 {
+		t.Errorf("TestReadAtomsFromBytesLazy: got %d children after access, want 3", len(root.children))
+	}
+	if root.children[0].children != nil || root.children[2].children != nil {
+		t.Errorf("TestReadAtomsFromBytesLazy: sibling containers should not be decoded")
+	}
+
+	// atom data refers to the input buffer
+	leaf := c.Children()[0]
+	if v, _ := leaf.Value.Uint(); v != 4 {
+		t.Errorf("TestReadAtomsFromBytesLazy: got value %d, want 4", v)
+	}
+	buf[99] = 40 // data of first LEAF in container 0002
+	if v, _ := leaf.Value.Uint(); v != 40 {
+		t.Errorf("TestReadAtomsFromBytesLazy: atom data should refer to input buffer, got value %d, want 40", v)
+	}
+	if root.Len() != 192 {
+		t.Errorf("TestReadAtomsFromBytesLazy: got Len() %d, want 192", root.Len())
+	}
+}
+
+func TestReadAtomsFromBytesInvalid(t *testing.T) {
+	buf, err := TestAtom1.MarshalBinary()
+	if err != nil {
+		t.Fatalf("TestReadAtomsFromBytesInvalid: unable to create test input: %s", err)
+	}
+	overrun := append([]byte{}, buf...)
+	overrun[15] = 250 // container 0001 extends past the end of ROOT
+
+	tests := []struct {
+		name  string
+		input []byte
+		opts  DecodeOptions
+		want  error
+	}{
+		{"truncated", buf[:100], DecodeOptions{}, ErrTruncated},
+		{"container overrun", overrun, DecodeOptions{}, ErrContainerOverrun},
+		{"short fixed size data", shortDataInput, DecodeOptions{}, ErrSizeMismatch},
+		{"depth limit", buf, DecodeOptions{MaxDepth: 1}, ErrLimitExceeded},
+		{"size limit", buf, DecodeOptions{MaxTotalBytes: 100}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		atoms, err := ReadAtomsFromBytes(test.input, test.opts)
+		if !errors.Is(err, test.want) || atoms != nil {
+			t.Errorf("ReadAtomsFromBytes(%s): got %d atoms, err {%v}, want cause {%s}", test.name, len(atoms), err, test.want)
+		}
+	}
+}
+
+func BenchmarkReadAtomsFromBinary(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		for _, t := range Tests {
+			if _, err := ReadAtomsFromBinary(bytes.NewReader(t.binBytes)); err != nil {
+				panic(err)
+			}
+		}
+	}
+	b.ReportAllocs()
+}
+
+// Read top-level atoms only, as when looking up a single atom.
+func BenchmarkReadAtomsFromBytes(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		for _, t := range Tests {
+			if _, err := ReadAtomsFromBytes(t.binBytes); err != nil {
+				panic(err)
+			}
+		}
+	}
+	b.ReportAllocs()
+}
+
+// Read every atom, for comparison with ReadAtomsFromBinary.
+func BenchmarkReadAtomsFromBytesExpanded(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		for _, t := range Tests {
+			atoms, err := ReadAtomsFromBytes(t.binBytes)
+			if err != nil {
+				panic(err)
+			}
+			for _, a := range atoms {
+				a.Descendants()
+			}
+		}
+	}
+	b.ReportAllocs()
+}